@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-contrib/cors"
@@ -13,9 +16,19 @@ import (
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/auth"
 	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/handlers"
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/jobs"
 	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/middleware"
 	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/models"
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/oidc"
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/services"
+	"github.com/gokulupadhyayguragain/addtocloud/backend/pkg/database"
+	"github.com/gokulupadhyayguragain/addtocloud/backend/pkg/email"
+
+	"github.com/gokulupadhyayguragain/addtocloud/libs/security/abuse"
+	"github.com/gokulupadhyayguragain/addtocloud/libs/security/clientip"
+	"github.com/gokulupadhyayguragain/addtocloud/libs/security/lockout"
 )
 
 func main() {
@@ -31,6 +44,12 @@ func main() {
 	}
 	gin.SetMode(ginMode)
 
+	// Token signing keys
+	signingKeys, err := auth.LoadKeySet()
+	if err != nil {
+		log.Fatalf("Failed to load JWT signing keys: %v", err)
+	}
+
 	// Database connection
 	dsn := os.Getenv("DATABASE_URL")
 	if dsn == "" {
@@ -55,22 +74,138 @@ func main() {
 		log.Println("✅ Database connected successfully")
 
 		// Auto-migrate
-		if err := db.AutoMigrate(&models.User{}, &models.AccessRequest{}); err != nil {
+		if err := models.MigrateUsers(db); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+		if err := db.AutoMigrate(
+			&models.AccessRequest{},
+			&models.RefreshToken{},
+			&models.RevokedToken{},
+			&models.MFARecoveryCode{},
+			&models.PasswordResetToken{},
+			&models.APIKey{},
+			&models.UserIdentity{},
+			&models.OIDCLoginState{},
+			&models.LockoutEvent{},
+			&models.Session{},
+			&models.Organization{},
+			&models.OrganizationMember{},
+			&models.OrganizationInvitation{},
+			&models.AuditLog{},
+			&models.AccessRequestTransition{},
+			&models.AccessRequestComment{},
+			&models.AccessRequestApproval{},
+			&models.AccessPolicyRule{},
+			&models.BlockedSubmission{},
+			&models.ErasureRequest{},
+		); err != nil {
 			log.Printf("Warning: Failed to migrate database: %v", err)
 		}
+		if err := models.MigrateAccessRequests(db); err != nil {
+			log.Printf("Warning: %v", err)
+		}
 	}
 
 	// Initialize handlers
+	var tokenService *auth.TokenService
 	var authHandler *handlers.AuthHandler
 	var accessRequestHandler *handlers.AccessRequestHandler
+	var accessPolicyHandler *handlers.AccessPolicyHandler
+	var adminHandler *handlers.AdminHandler
+	var apiKeyHandler *handlers.APIKeyHandler
+	var oidcHandler *handlers.OIDCHandler
+	var organizationHandler *handlers.OrganizationHandler
+	var cloudHandler *handlers.CloudHandler
+	var jobHandler *handlers.JobHandler
+	var privacyHandler *handlers.PrivacyHandler
+	var abuseGuard *abuse.Guard
 	if db != nil {
-		authHandler = handlers.NewAuthHandler(db)
-		accessRequestHandler = handlers.NewAccessRequestHandler(db)
+		mailer := email.NewSMTPConfig()
+		if !mailer.IsConfigured() {
+			log.Println("Warning: SMTP not configured - emails will not be sent")
+		}
+
+		// Failure counters are shared through Redis when it is reachable
+		var lockoutStore lockout.Store
+		if rdb, err := database.InitRedis(); err == nil {
+			lockoutStore = lockout.NewRedisStore(rdb)
+		} else {
+			log.Printf("Warning: Redis unavailable, using in-memory lockout counters: %v", err)
+			lockoutStore = lockout.NewMemoryStore()
+		}
+		guard := lockout.NewGuard(lockoutStore, handlers.NewLockoutRecorder(db))
+
+		// Public forms share the counter store with the lockout guard
+		verifier, err := abuse.VerifierFromEnv()
+		if err != nil {
+			log.Fatalf("Invalid challenge configuration: %v", err)
+		}
+		if verifier == nil {
+			log.Println("Warning: CHALLENGE_PROVIDER not set - public forms are protected by rate limits only")
+		}
+		abuseGuard = abuse.NewGuard(lockoutStore, verifier, handlers.NewBlockedSubmissionRecorder(db))
+
+		tokenService = auth.NewTokenService(db, signingKeys)
+		authHandler = handlers.NewAuthHandler(db, tokenService, mailer, guard)
+		accessRequestHandler = handlers.NewAccessRequestHandler(db, mailer)
+		go accessRequestHandler.RunExpiryJob(context.Background(), handlers.ExpiryConfigFromEnv())
+		accessPolicyHandler = handlers.NewAccessPolicyHandler(db)
+		adminHandler = handlers.NewAdminHandler(db, tokenService, guard)
+		apiKeyHandler = handlers.NewAPIKeyHandler(db, tokenService)
+		organizationHandler = handlers.NewOrganizationHandler(db, mailer)
+
+		providers, err := oidc.LoadProvidersFromEnv()
+		if err != nil {
+			log.Printf("Warning: SSO disabled: %v", err)
+			providers = nil
+		}
+		oidcHandler = handlers.NewOIDCHandler(db, authHandler, providers)
+
+		var cloudService *services.CloudService
+		if sqlDB, err := db.DB(); err == nil {
+			// Provisioning runs as jobs that survive restarts
+			queue := jobs.NewQueue(sqlDB, jobs.ConfigFromEnv())
+			if err := queue.Migrate(); err != nil {
+				log.Printf("Warning: %v", err)
+			}
+			cloudService = services.NewCloudService(sqlDB, nil, newDriverRegistry(), queue)
+			if err := cloudService.Migrate(); err != nil {
+				log.Printf("Warning: %v", err)
+			}
+			if err := cloudService.RequeueStuckInstances(context.Background()); err != nil {
+				log.Printf("Warning: %v", err)
+			}
+			go queue.Run(context.Background())
+			cloudHandler = handlers.NewCloudHandler(cloudService)
+			jobHandler = handlers.NewJobHandler(queue)
+		} else {
+			log.Printf("Warning: Cloud endpoints disabled: %v", err)
+		}
+
+		// Erasure queues the deletion of the user's instances, so it needs
+		// the cloud service
+		privacyHandler = handlers.NewPrivacyHandler(db, mailer, cloudService, handlers.ErasureConfigFromEnv())
+		go privacyHandler.RunErasureJob(context.Background())
+
+		// Periodically drop expired refresh tokens and revocation entries
+		go func() {
+			for range time.Tick(time.Hour) {
+				if err := tokenService.PurgeExpired(); err != nil {
+					log.Printf("Warning: Failed to purge expired tokens: %v", err)
+				}
+			}
+		}()
 	}
 
 	// Setup router
 	r := gin.Default()
 
+	// Only our load balancers may report the client address through
+	// X-Forwarded-For, otherwise lockouts and rate limits key off a spoofed IP
+	if err := r.SetTrustedProxies(clientip.TrustedProxiesFromEnv()); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+
 	// CORS configuration
 	config := cors.DefaultConfig()
 	config.AllowOrigins = []string{
@@ -80,7 +215,7 @@ func main() {
 		"https://addtocloud.pages.dev",
 	}
 	config.AllowMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS", "PATCH"}
-	config.AllowHeaders = []string{"Origin", "Content-Type", "Authorization", "Accept", "X-Requested-With"}
+	config.AllowHeaders = []string{"Origin", "Content-Type", "Authorization", "Accept", "X-Requested-With", "X-API-Key", middleware.OrganizationHeader, handlers.TrackingTokenHeader, middleware.ChallengeTokenHeader}
 	config.AllowCredentials = true
 	r.Use(cors.New(config))
 
@@ -103,6 +238,12 @@ func main() {
 		})
 	})
 
+	// Public keys for verifying platform tokens
+	r.GET("/.well-known/jwks.json", func(c *gin.Context) {
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(http.StatusOK, signingKeys.JWKS())
+	})
+
 	// Cloud services endpoint
 	r.GET("/api/v1/cloud/services", func(c *gin.Context) {
 		services := generateCloudServices()
@@ -122,26 +263,152 @@ func main() {
 	{
 		// Auth routes
 		if authHandler != nil && accessRequestHandler != nil {
+			authRequired := middleware.AuthMiddleware(tokenService, db)
+
 			auth := api.Group("/auth")
 			{
-				auth.POST("/request-access", accessRequestHandler.SubmitAccessRequest)
+				auth.POST("/request-access", middleware.AbuseProtection(abuseGuard, "request_access"), accessRequestHandler.SubmitAccessRequest)
+				auth.POST("/register", authHandler.Register)
 				auth.POST("/login", authHandler.Login)
+				auth.POST("/refresh", authHandler.Refresh)
+				auth.POST("/logout", authRequired, authHandler.Logout)
+				auth.POST("/mfa/verify", authHandler.VerifyMFA)
+				auth.POST("/forgot-password", middleware.AbuseProtection(abuseGuard, "forgot_password"), authHandler.ForgotPassword)
+				auth.POST("/reset-password", authHandler.ResetPassword)
+				auth.POST("/verify-email", authHandler.VerifyEmail)
+				auth.POST("/resend-verification", middleware.AbuseProtection(abuseGuard, "resend_verification"), authHandler.ResendVerification)
+
+				// Single sign-on through configured OpenID Connect providers
+				auth.GET("/oidc/providers", oidcHandler.ListProviders)
+				auth.GET("/oidc/:provider/login", oidcHandler.StartLogin)
+				auth.POST("/oidc/:provider/callback", oidcHandler.Callback)
+			}
+
+			// Requesters follow up on their access request with its tracking token
+			accessRequests := api.Group("/access-requests")
+			{
+				accessRequests.GET("/:id/status", accessRequestHandler.GetAccessRequestStatus)
+				accessRequests.GET("/:id/comments", accessRequestHandler.ListRequesterComments)
+				accessRequests.POST("/:id/comments", accessRequestHandler.AddRequesterComment)
+				accessRequests.GET("/:id/data-export", privacyHandler.ExportRequesterData)
+				accessRequests.POST("/:id/erasure", privacyHandler.RequestRequesterErasure)
+				accessRequests.DELETE("/:id/erasure", privacyHandler.CancelRequesterErasure)
+				accessRequests.POST("/:id/withdraw", accessRequestHandler.WithdrawAccessRequest)
 			}
 
 			// Admin routes for access management
 			admin := api.Group("/admin")
-			admin.Use(middleware.AuthMiddleware()) // Assuming you have admin middleware
+			admin.Use(authRequired)
 			{
-				admin.GET("/access-requests", accessRequestHandler.GetAccessRequests)
-				admin.POST("/access-requests/:id/approve", accessRequestHandler.ApproveAccessRequest)
-				admin.POST("/access-requests/:id/reject", accessRequestHandler.RejectAccessRequest)
+				admin.GET("/access-requests", middleware.RequirePermission(models.PermAccessRequestsRead), accessRequestHandler.GetAccessRequests)
+				admin.GET("/access-requests/export", middleware.RequirePermission(models.PermAccessRequestsRead), accessRequestHandler.ExportAccessRequests)
+				admin.POST("/access-requests/bulk", middleware.RequirePermission(models.PermAccessRequestsReview), accessRequestHandler.BulkAccessRequests)
+				admin.GET("/access-requests/:id", middleware.RequirePermission(models.PermAccessRequestsRead), accessRequestHandler.GetAccessRequest)
+				admin.POST("/access-requests/:id/transition", middleware.RequirePermission(models.PermAccessRequestsReview), accessRequestHandler.TransitionAccessRequest)
+				admin.POST("/access-requests/:id/comments", middleware.RequirePermission(models.PermAccessRequestsReview), accessRequestHandler.AddReviewerComment)
+				admin.POST("/access-requests/:id/approve", middleware.RequirePermission(models.PermAccessRequestsReview), accessRequestHandler.ApproveAccessRequest)
+				admin.POST("/access-requests/:id/reject", middleware.RequirePermission(models.PermAccessRequestsReview), accessRequestHandler.RejectAccessRequest)
+				admin.GET("/access-policies", middleware.RequirePermission(models.PermAccessPoliciesManage), accessPolicyHandler.ListPolicyRules)
+				admin.POST("/access-policies", middleware.RequirePermission(models.PermAccessPoliciesManage), accessPolicyHandler.CreatePolicyRule)
+				admin.POST("/access-policies/dry-run", middleware.RequirePermission(models.PermAccessPoliciesManage), accessPolicyHandler.DryRunPolicyRules)
+				admin.PUT("/access-policies/:id", middleware.RequirePermission(models.PermAccessPoliciesManage), accessPolicyHandler.UpdatePolicyRule)
+				admin.DELETE("/access-policies/:id", middleware.RequirePermission(models.PermAccessPoliciesManage), accessPolicyHandler.DeletePolicyRule)
+				admin.PUT("/users/:id/role", middleware.RequireRole(models.RoleAdmin), middleware.RequirePermission(models.PermUsersManage), adminHandler.UpdateUserRole)
+				admin.GET("/lockouts", middleware.RequirePermission(models.PermUsersManage), adminHandler.ListLockouts)
+				admin.GET("/blocked-submissions", middleware.RequirePermission(models.PermUsersManage), adminHandler.ListBlockedSubmissions)
+				admin.POST("/lockouts/unlock", middleware.RequirePermission(models.PermUsersManage), adminHandler.Unlock)
+				admin.GET("/users/:id/sessions", middleware.RequirePermission(models.PermUsersManage), adminHandler.ListUserSessions)
+				admin.DELETE("/users/:id/sessions", middleware.RequirePermission(models.PermUsersManage), adminHandler.RevokeUserSessions)
+				admin.DELETE("/users/:id/sessions/:sessionId", middleware.RequirePermission(models.PermUsersManage), adminHandler.RevokeUserSession)
+				admin.POST("/users/:id/identities", middleware.RequireRole(models.RoleAdmin), middleware.RequirePermission(models.PermUsersManage), adminHandler.LinkTrustedIdentity)
+				admin.DELETE("/users/:id/identities/:identityId", middleware.RequireRole(models.RoleAdmin), middleware.RequirePermission(models.PermUsersManage), adminHandler.UnlinkTrustedIdentity)
+				admin.POST("/users/:id/impersonate", middleware.RequireRole(models.RoleAdmin), middleware.RequirePermission(models.PermUsersManage), middleware.RequireMFA(), adminHandler.ImpersonateUser)
+				admin.GET("/audit-logs", middleware.RequireRole(models.RoleAdmin), adminHandler.ListAuditLogs)
+				admin.GET("/erasures", middleware.RequirePermission(models.PermUsersManage), privacyHandler.ListErasures)
+				admin.DELETE("/erasures/:id", middleware.RequirePermission(models.PermUsersManage), privacyHandler.CancelErasure)
+				admin.POST("/users/:id/erasure", middleware.RequireRole(models.RoleAdmin), middleware.RequirePermission(models.PermUsersManage), privacyHandler.ScheduleUserErasure)
+				if jobHandler != nil {
+					admin.GET("/jobs", middleware.RequirePermission(models.PermJobsManage), jobHandler.ListJobs)
+					admin.GET("/jobs/:id", middleware.RequirePermission(models.PermJobsManage), jobHandler.GetJob)
+					admin.POST("/jobs/:id/retry", middleware.RequirePermission(models.PermJobsManage), jobHandler.RetryJob)
+					admin.POST("/jobs/:id/cancel", middleware.RequirePermission(models.PermJobsManage), jobHandler.CancelJob)
+				}
 			}
 
 			// Protected routes
 			protected := api.Group("/")
-			protected.Use(middleware.AuthMiddleware())
+			protected.Use(authRequired)
 			{
 				protected.GET("/user/profile", authHandler.GetProfile)
+
+				// Managing the account itself takes a signed-in session; API keys
+				// are only good for the scoped routes below
+				interactive := middleware.RequireInteractiveSession()
+				account := protected.Group("/user", interactive)
+				{
+					account.POST("/mfa/enroll", authHandler.EnrollMFA)
+					account.POST("/mfa/confirm", authHandler.ConfirmMFA)
+					account.POST("/mfa/recovery-codes", authHandler.RegenerateRecoveryCodes)
+					account.POST("/mfa/disable", authHandler.DisableMFA)
+					account.GET("/api-keys", apiKeyHandler.ListAPIKeys)
+					account.POST("/api-keys", apiKeyHandler.CreateAPIKey)
+					account.DELETE("/api-keys/:id", apiKeyHandler.RevokeAPIKey)
+					account.GET("/sessions", authHandler.ListSessions)
+					account.DELETE("/sessions", authHandler.RevokeAllSessions)
+					account.DELETE("/sessions/:id", authHandler.RevokeSession)
+					account.GET("/data-export", privacyHandler.ExportMyData)
+					account.GET("/erasure", privacyHandler.GetMyErasure)
+					account.POST("/erasure", privacyHandler.RequestMyErasure)
+					account.DELETE("/erasure", privacyHandler.CancelMyErasure)
+					account.GET("/identities", oidcHandler.ListIdentities)
+					account.POST("/identities/:provider/link", oidcHandler.StartLink)
+					account.DELETE("/identities/:id", oidcHandler.UnlinkIdentity)
+				}
+
+				// Organizations and their members. Routes under :orgId act in that
+				// organization; other org-scoped routes use X-Organization-ID or the
+				// caller's default organization. Only reads are open to API keys.
+				inOrg := middleware.OrganizationContext(db)
+				protected.GET("/organizations", organizationHandler.ListOrganizations)
+				protected.POST("/organizations", interactive, organizationHandler.CreateOrganization)
+				protected.POST("/organizations/invitations/accept", interactive, organizationHandler.AcceptInvitation)
+				organization := protected.Group("/organizations/:orgId", inOrg)
+				{
+					organization.GET("", organizationHandler.GetOrganization)
+					organization.PATCH("", interactive, middleware.RequireOrgRole(models.OrgRoleAdmin), organizationHandler.UpdateOrganization)
+					organization.POST("/default", interactive, organizationHandler.SetDefaultOrganization)
+					organization.GET("/members", organizationHandler.ListMembers)
+					organization.PUT("/members/:userId", interactive, middleware.RequireOrgRole(models.OrgRoleAdmin), organizationHandler.UpdateMember)
+					organization.DELETE("/members/:userId", interactive, organizationHandler.RemoveMember)
+					organization.GET("/invitations", interactive, middleware.RequireOrgRole(models.OrgRoleAdmin), organizationHandler.ListInvitations)
+					organization.POST("/invitations", interactive, middleware.RequireOrgRole(models.OrgRoleAdmin), organizationHandler.InviteMember)
+					organization.DELETE("/invitations/:invitationId", interactive, middleware.RequireOrgRole(models.OrgRoleAdmin), organizationHandler.RevokeInvitation)
+				}
+
+				if cloudHandler != nil {
+					// Provisioning requires an MFA-verified session unless explicitly disabled
+					provision := []gin.HandlerFunc{
+						middleware.RequirePermission(models.PermInstancesWrite),
+						inOrg,
+						middleware.RequireOrgRole(models.OrgRoleMember),
+					}
+					if getEnvOrDefault("MFA_REQUIRED_FOR_PROVISIONING", "true") == "true" {
+						provision = append(provision, middleware.RequireMFA())
+					}
+					if handlers.EmailVerificationMode() != handlers.EmailVerificationOff {
+						provision = append(provision, middleware.RequireVerifiedEmail())
+					}
+
+					protected.GET("/instances", middleware.RequirePermission(models.PermInstancesRead), inOrg, cloudHandler.ListInstances)
+
+					provisioning := protected.Group("/instances", provision...)
+					provisioning.POST("", cloudHandler.CreateInstance)
+					provisioning.DELETE("/:id", cloudHandler.DeleteInstance)
+					provisioning.POST("/:id/start", cloudHandler.StartInstance)
+					provisioning.POST("/:id/stop", cloudHandler.StopInstance)
+					provisioning.POST("/:id/reboot", cloudHandler.RebootInstance)
+					provisioning.POST("/:id/resize", cloudHandler.ResizeInstance)
+				}
 			}
 		} else {
 			// Fallback endpoints
@@ -182,6 +449,38 @@ func getEnvOrDefault(key, defaultValue string) string {
 	return defaultValue
 }
 
+// newDriverRegistry registers the cloud provider drivers. Until real drivers
+// exist, no provider is available unless CLOUD_FAKE_PROVIDERS opts in to
+// simulating some in process, e.g. "aws,azure,gcp" for development, with
+// FAKE_PROVIDER_LATENCY, FAKE_PROVIDER_BOOT_TIME and FAKE_PROVIDER_FAILURE_RATE.
+func newDriverRegistry() *services.DriverRegistry {
+	latency, err := time.ParseDuration(getEnvOrDefault("FAKE_PROVIDER_LATENCY", "500ms"))
+	if err != nil || latency < 0 {
+		log.Printf("Warning: ignoring invalid FAKE_PROVIDER_LATENCY")
+		latency = 500 * time.Millisecond
+	}
+	bootTime, err := time.ParseDuration(getEnvOrDefault("FAKE_PROVIDER_BOOT_TIME", "30s"))
+	if err != nil || bootTime < 0 {
+		log.Printf("Warning: ignoring invalid FAKE_PROVIDER_BOOT_TIME")
+		bootTime = 30 * time.Second
+	}
+	failureRate, err := strconv.ParseFloat(getEnvOrDefault("FAKE_PROVIDER_FAILURE_RATE", "0"), 64)
+	if err != nil || failureRate < 0 || failureRate > 1 {
+		log.Printf("Warning: ignoring invalid FAKE_PROVIDER_FAILURE_RATE")
+		failureRate = 0
+	}
+
+	registry := services.NewDriverRegistry()
+	fake := services.NewFakeDriver(latency, bootTime, failureRate)
+	for _, provider := range strings.Split(os.Getenv("CLOUD_FAKE_PROVIDERS"), ",") {
+		if provider = strings.TrimSpace(provider); provider != "" {
+			log.Printf("Warning: simulating cloud provider %s - instances are not real", provider)
+			registry.Register(provider, fake)
+		}
+	}
+	return registry
+}
+
 // Generate cloud services data
 func generateCloudServices() []map[string]interface{} {
 	services := make([]map[string]interface{}, 0, 360)
@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/models"
+)
+
+const (
+	TokenTypeAPIKey = "api_key"
+
+	apiKeyPrefix         = "atc_"
+	apiKeyLastUsedWindow = time.Minute // Limits last_used writes to one per key per minute
+)
+
+var ErrInvalidAPIKey = errors.New("invalid or expired API key")
+
+// CreateAPIKey generates a new key for the user. The returned plaintext key
+// is never stored and cannot be recovered later.
+func (s *TokenService) CreateAPIKey(userID uint, name string, scopes []string, expiresAt *time.Time, createdWithMFA bool) (string, *models.APIKey, error) {
+	id := make([]byte, 4)
+	secret := make([]byte, 24)
+	if _, err := rand.Read(id); err != nil {
+		return "", nil, fmt.Errorf("failed to generate API key: %w", err)
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, fmt.Errorf("failed to generate API key: %w", err)
+	}
+
+	prefix := apiKeyPrefix + hex.EncodeToString(id)
+	key := prefix + "_" + hex.EncodeToString(secret)
+
+	record := &models.APIKey{
+		UserID:         userID,
+		Name:           name,
+		KeyHash:        HashToken(key),
+		KeyPrefix:      prefix,
+		Permissions:    models.Scopes(scopes),
+		CreatedWithMFA: createdWithMFA,
+		ExpiresAt:      expiresAt,
+		Status:         models.APIKeyStatusActive,
+	}
+	if err := s.db.Create(record).Error; err != nil {
+		return "", nil, fmt.Errorf("failed to store API key: %w", err)
+	}
+
+	return key, record, nil
+}
+
+// AuthenticateAPIKey resolves an API key to claims. The key's scopes are
+// intersected with the owner's current permissions so that a role downgrade
+// also narrows existing keys.
+func (s *TokenService) AuthenticateAPIKey(key string) (*Claims, error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	var record models.APIKey
+	if err := s.db.Where("api_key = ? AND status = ?", HashToken(key), models.APIKeyStatusActive).First(&record).Error; err != nil {
+		return nil, ErrInvalidAPIKey
+	}
+
+	now := time.Now()
+	if record.ExpiresAt != nil && now.After(*record.ExpiresAt) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	var user models.User
+	if err := s.db.First(&user, record.UserID).Error; err != nil || !user.IsActive {
+		return nil, ErrInvalidAPIKey
+	}
+
+	allowed := make(map[string]bool)
+	for _, p := range user.EffectivePermissions() {
+		allowed[p] = true
+	}
+	var permissions []string
+	for _, p := range record.Permissions {
+		if allowed[p] {
+			permissions = append(permissions, p)
+		}
+	}
+
+	if err := s.db.Model(&models.APIKey{}).
+		Where("id = ? AND (last_used IS NULL OR last_used < ?)", record.ID, now.Add(-apiKeyLastUsedWindow)).
+		Update("last_used", now).Error; err != nil {
+		return nil, fmt.Errorf("failed to update API key usage: %w", err)
+	}
+
+	return &Claims{
+		UserID:        user.ID,
+		Role:          user.Role,
+		Permissions:   permissions,
+		TokenType:     TokenTypeAPIKey,
+		MFA:           record.CreatedWithMFA,
+		EmailVerified: user.EmailVerified,
+		APIKeyID:      record.ID,
+	}, nil
+}
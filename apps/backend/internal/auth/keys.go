@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"context"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/gokulupadhyayguragain/addtocloud/libs/security/jwtkeys"
+)
+
+// KeySet signs platform tokens and resolves the keys that verify them. Key
+// loading, rotation and the issuers whose tokens are trusted live in the
+// shared jwtkeys package; see jwtkeys.Load for the environment it reads.
+type KeySet struct {
+	*jwtkeys.KeySet
+}
+
+// LoadKeySet reads the signing keys and trusted issuers from the environment
+func LoadKeySet() (*KeySet, error) {
+	keys, err := jwtkeys.Load()
+	if err != nil {
+		return nil, err
+	}
+	return &KeySet{keys}, nil
+}
+
+// Sign signs the claims with the active key
+func (s *KeySet) Sign(claims jwt.Claims) (string, error) {
+	kid, algorithm, key := s.SigningKey()
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(algorithm), claims)
+	token.Header["kid"] = kid
+	return token.SignedString(key)
+}
+
+// Keyfunc resolves the verification key for a token from its kid header and
+// iss claim, checking that the token's algorithm fits the key
+func (s *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	issuer, err := token.Claims.GetIssuer()
+	if err != nil {
+		return nil, err
+	}
+	return s.VerificationKey(context.Background(), kid, token.Method.Alg(), issuer)
+}
+
+// ValidMethods lists the algorithms accepted by Keyfunc
+func (s *KeySet) ValidMethods() []string {
+	return s.Algorithms()
+}
@@ -0,0 +1,209 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/models"
+	"github.com/gokulupadhyayguragain/addtocloud/libs/security/jwks"
+)
+
+const trustedIssuer = "https://admin.example"
+
+// trustedService stands in for another service that publishes its keys and
+// is listed in JWT_TRUSTED_ISSUERS
+type trustedService struct {
+	key ed25519.PrivateKey
+}
+
+func (s *trustedService) sign(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+	token.Header["kid"] = "admin-1"
+	signed, err := token.SignedString(s.key)
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	return signed
+}
+
+// accessClaims has the shape of the tokens real-api and the OTP admin issue
+// for this service
+func accessClaims(issuer, typ string) jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":     issuer,
+		"aud":     []string{"https://api.example"},
+		"sub":     "42",
+		"jti":     fmt.Sprintf("jti-%d", now.UnixNano()),
+		"typ":     typ,
+		"iat":     now.Unix(),
+		"exp":     now.Add(time.Hour).Unix(),
+		"user_id": 42,
+		"email":   "admin@example.com",
+		"role":    "admin",
+	}
+}
+
+func newTrustingTokenService(t *testing.T) (*TokenService, *trustedService) {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := &trustedService{key: key}
+	jwk, err := jwks.NewJSONWebKey("admin-1", "EdDSA", key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jwks.JSONWebKeySet{Keys: []jwks.JSONWebKey{jwk}})
+	}))
+	t.Cleanup(server.Close)
+
+	t.Setenv("JWT_KEYS_DIR", "")
+	t.Setenv("JWT_ISSUER", "https://api.example")
+	t.Setenv("JWT_AUDIENCE", "")
+	t.Setenv("JWT_TOKEN_AUDIENCES", "")
+	t.Setenv("JWT_TRUSTED_ISSUERS", "admin")
+	t.Setenv("JWT_TRUSTED_ADMIN_ISSUER", trustedIssuer)
+	t.Setenv("JWT_TRUSTED_ADMIN_JWKS_URL", server.URL)
+	keys, err := LoadKeySet()
+	if err != nil {
+		t.Fatalf("load keys: %v", err)
+	}
+
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := db.AutoMigrate(&models.RevokedToken{}, &models.User{}, &models.UserIdentity{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return NewTokenService(db, keys), service
+}
+
+func TestParseAccessTokenFromTrustedIssuer(t *testing.T) {
+	tokens, service := newTrustingTokenService(t)
+	token := service.sign(t, accessClaims(trustedIssuer, TokenTypeAccess))
+
+	// Nothing links the issuer's subject to a local user yet
+	if _, err := tokens.ParseAccessToken(token); err == nil {
+		t.Fatal("token for an unlinked subject accepted")
+	}
+
+	user := models.User{FirstName: "Ada", LastName: "Admin", Email: "ada@example.com", Role: models.RoleUser, IsActive: true}
+	tokens.db.Create(&user)
+	tokens.db.Create(&models.UserIdentity{UserID: user.ID, Provider: trustedIssuer, Subject: "42"})
+
+	claims, err := tokens.ParseAccessToken(token)
+	if err != nil {
+		t.Fatalf("trusted access token rejected: %v", err)
+	}
+	// The issuer's user ID and role are replaced by the linked user's
+	if claims.UserID != user.ID || claims.Role != models.RoleUser || claims.Email != user.Email || claims.Issuer != trustedIssuer {
+		t.Fatalf("got claims %+v", claims)
+	}
+
+	elsewhere := accessClaims(trustedIssuer, TokenTypeAccess)
+	elsewhere["aud"] = []string{"https://other-api.example"}
+	if _, err := tokens.ParseAccessToken(service.sign(t, elsewhere)); err == nil {
+		t.Fatal("token meant for another service accepted")
+	}
+	delete(elsewhere, "aud")
+	if _, err := tokens.ParseAccessToken(service.sign(t, elsewhere)); err == nil {
+		t.Fatal("token without an audience accepted")
+	}
+
+	// The same key cannot vouch for a token naming another issuer
+	if _, err := tokens.ParseAccessToken(service.sign(t, accessClaims("https://api.example", TokenTypeAccess))); err == nil {
+		t.Fatal("token naming this service's issuer accepted with the trusted service's key")
+	}
+	if _, err := tokens.ParseAccessToken(service.sign(t, accessClaims("https://other.example", TokenTypeAccess))); err == nil {
+		t.Fatal("token from an untrusted issuer accepted")
+	}
+}
+
+func TestPurposeTokensOnlyFromOwnIssuer(t *testing.T) {
+	tokens, service := newTrustingTokenService(t)
+
+	if _, err := tokens.ParseMFAChallenge(service.sign(t, accessClaims(trustedIssuer, TokenTypeMFAChallenge))); err == nil {
+		t.Fatal("MFA challenge from a trusted issuer accepted")
+	}
+
+	own, err := tokens.IssueMFAChallenge(&models.User{ID: 7})
+	if err != nil {
+		t.Fatalf("issue: %v", err)
+	}
+	claims, err := tokens.ParseMFAChallenge(own)
+	if err != nil || claims.UserID != 7 || claims.Issuer != "https://api.example" {
+		t.Fatalf("own MFA challenge: claims %+v, err %v", claims, err)
+	}
+}
+
+func TestOwnTokenWithoutIssuerOnlyBeforeCutoff(t *testing.T) {
+	tokens, _ := newTrustingTokenService(t)
+
+	kid, algorithm, key := tokens.keys.SigningKey()
+	legacy := jwt.NewWithClaims(jwt.GetSigningMethod(algorithm), Claims{
+		UserID:    7,
+		TokenType: TokenTypeEmailVerify,
+		Email:     "user@example.com",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "legacy",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	})
+	legacy.Header["kid"] = kid
+	signed, err := legacy.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cutoff := legacyIssuerCutoff
+	t.Cleanup(func() { legacyIssuerCutoff = cutoff })
+
+	legacyIssuerCutoff = time.Now().Add(time.Hour)
+	if _, err := tokens.ParseEmailVerification(signed); err != nil {
+		t.Fatalf("token without iss rejected before the cutoff: %v", err)
+	}
+	legacyIssuerCutoff = time.Now().Add(-time.Hour)
+	if _, err := tokens.ParseEmailVerification(signed); err == nil {
+		t.Fatal("token without iss accepted after the cutoff")
+	}
+}
+
+func TestParseOwnAccessToken(t *testing.T) {
+	tokens, _ := newTrustingTokenService(t)
+
+	signed, _, err := tokens.newAccessToken(&models.User{ID: 7, Role: models.RoleUser}, "family", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := tokens.ParseAccessToken(signed)
+	if err != nil {
+		t.Fatalf("own access token rejected: %v", err)
+	}
+	if claims.UserID != 7 || !claims.MFA || claims.SessionID != "family" {
+		t.Fatalf("got claims %+v", claims)
+	}
+}
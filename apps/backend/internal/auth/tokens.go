@@ -0,0 +1,645 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/models"
+)
+
+const (
+	AccessTokenTTL  = 15 * time.Minute
+	RefreshTokenTTL = 30 * 24 * time.Hour
+	MFAChallengeTTL = 5 * time.Minute
+
+	// Impersonation tokens cannot be refreshed, so an admin has to start a
+	// new impersonation to keep going
+	ImpersonationTokenTTL = 10 * time.Minute
+
+	// Limits session last-seen writes to one per session per minute
+	sessionTouchInterval = time.Minute
+
+	EmailVerificationTTL = 24 * time.Hour
+)
+
+// Tokens signed before the iss claim was added carry none. They are accepted
+// as this service's own until legacyIssuerCutoff, by when every one of them
+// has expired.
+var legacyIssuerCutoff = time.Date(2026, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+// Token types carried in the "typ" claim
+const (
+	TokenTypeAccess       = "access"
+	TokenTypeMFAChallenge = "mfa_challenge"
+	TokenTypeEmailVerify  = "email_verification"
+)
+
+var (
+	ErrInvalidToken        = errors.New("invalid token")
+	ErrTokenRevoked        = errors.New("token has been revoked")
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected")
+	ErrSessionNotFound     = errors.New("session not found")
+)
+
+// Claims are the JWT claims carried by platform access tokens
+type Claims struct {
+	UserID        uint     `json:"user_id"`
+	Role          string   `json:"role"`
+	Permissions   []string `json:"permissions"`
+	TokenType     string   `json:"typ"`
+	MFA           bool     `json:"mfa,omitempty"` // Set when the session was verified with a second factor
+	Email         string   `json:"email,omitempty"`
+	EmailVerified bool     `json:"email_verified"`
+	SessionID     string   `json:"sid,omitempty"` // Refresh token family the access token belongs to
+	APIKeyID      uint     `json:"-"`             // Set when authenticated with an API key rather than a JWT
+	Actor         *Actor   `json:"act,omitempty"` // Set when an admin is acting as the user
+	jwt.RegisteredClaims
+}
+
+// Actor identifies the admin behind an impersonation token, following the
+// "act" claim of RFC 8693
+type Actor struct {
+	Subject string `json:"sub"`
+	UserID  uint   `json:"user_id"`
+	Email   string `json:"email,omitempty"`
+}
+
+// IsImpersonation reports whether the token was issued to an admin acting as
+// the user
+func (c *Claims) IsImpersonation() bool {
+	return c.Actor != nil
+}
+
+// HasRole reports whether the token was issued to a user with one of the roles
+func (c *Claims) HasRole(roles ...string) bool {
+	for _, role := range roles {
+		if c.Role == role {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPermission reports whether the token carries the permission
+func (c *Claims) HasPermission(permission string) bool {
+	for _, p := range c.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// TokenPair is returned to clients after a successful login or refresh
+type TokenPair struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refreshToken"`
+	ExpiresIn    int64  `json:"expiresIn"` // Access token lifetime in seconds
+}
+
+// ClientInfo describes the device a session was started or refreshed from
+type ClientInfo struct {
+	IPAddress string
+	UserAgent string
+}
+
+// TokenService issues short-lived access tokens and rotating refresh tokens,
+// and keeps the server-side state needed to revoke them.
+type TokenService struct {
+	db   *gorm.DB
+	keys *KeySet
+}
+
+func NewTokenService(db *gorm.DB, keys *KeySet) *TokenService {
+	return &TokenService{db: db, keys: keys}
+}
+
+// IssueTokenPair starts a new session and refresh token family for the user.
+// mfaVerified records whether the login completed a second factor.
+func (s *TokenService) IssueTokenPair(user *models.User, mfaVerified bool, client ClientInfo) (*TokenPair, error) {
+	familyID, err := randomID()
+	if err != nil {
+		return nil, err
+	}
+
+	var pair *TokenPair
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		session := models.Session{
+			UserID:      user.ID,
+			FamilyID:    familyID,
+			DeviceName:  DeviceName(client.UserAgent),
+			UserAgent:   truncate(client.UserAgent, 512),
+			IPAddress:   client.IPAddress,
+			MFAVerified: mfaVerified,
+			LastSeenAt:  now,
+			ExpiresAt:   now.Add(RefreshTokenTTL),
+		}
+		if err := tx.Create(&session).Error; err != nil {
+			return fmt.Errorf("failed to store session: %w", err)
+		}
+
+		var err error
+		pair, err = s.issue(tx, user, familyID, mfaVerified)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return pair, nil
+}
+
+// IssueMFAChallenge returns a short-lived token proving the password step of
+// a login succeeded. It is only accepted by ParseMFAChallenge.
+func (s *TokenService) IssueMFAChallenge(user *models.User) (string, error) {
+	return s.signPurposeToken(Claims{UserID: user.ID, TokenType: TokenTypeMFAChallenge}, MFAChallengeTTL)
+}
+
+// ParseMFAChallenge validates a token issued by IssueMFAChallenge
+func (s *TokenService) ParseMFAChallenge(tokenString string) (*Claims, error) {
+	claims, err := s.parseOwnToken(tokenString)
+	if err != nil || claims.TokenType != TokenTypeMFAChallenge {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// IssueEmailVerification returns a signed token for the user's current email
+// address, to be embedded in a verification link
+func (s *TokenService) IssueEmailVerification(user *models.User) (string, error) {
+	return s.signPurposeToken(Claims{UserID: user.ID, Email: user.Email, TokenType: TokenTypeEmailVerify}, EmailVerificationTTL)
+}
+
+// ParseEmailVerification validates a token issued by IssueEmailVerification
+func (s *TokenService) ParseEmailVerification(tokenString string) (*Claims, error) {
+	claims, err := s.parseOwnToken(tokenString)
+	if err != nil || claims.TokenType != TokenTypeEmailVerify || claims.Email == "" {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// Rotate exchanges a refresh token for a new token pair. A refresh token can
+// only be used once; presenting it again revokes the whole family.
+func (s *TokenService) Rotate(refreshToken string, client ClientInfo) (*TokenPair, error) {
+	var record models.RefreshToken
+	if err := s.db.Where("token_hash = ?", HashToken(refreshToken)).First(&record).Error; err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	if record.UsedAt != nil {
+		if err := s.RevokeFamily(record.FamilyID); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenReused
+	}
+
+	if record.RevokedAt != nil || time.Now().After(record.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	// Reload the user so role and permission changes apply on refresh
+	var user models.User
+	if err := s.db.First(&user, record.UserID).Error; err != nil || !user.IsActive {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	var pair *TokenPair
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		// Guard against two concurrent rotations of the same token
+		result := tx.Model(&models.RefreshToken{}).
+			Where("id = ? AND used_at IS NULL AND revoked_at IS NULL", record.ID).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrRefreshTokenReused
+		}
+
+		var err error
+		pair, err = s.issue(tx, &user, record.FamilyID, record.MFAVerified)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		return tx.Model(&models.Session{}).
+			Where("family_id = ?", record.FamilyID).
+			Updates(map[string]interface{}{
+				"last_seen_at": now,
+				"ip_address":   client.IPAddress,
+				"expires_at":   now.Add(RefreshTokenTTL),
+			}).Error
+	})
+	if errors.Is(err, ErrRefreshTokenReused) {
+		if revokeErr := s.RevokeFamily(record.FamilyID); revokeErr != nil {
+			return nil, revokeErr
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return pair, nil
+}
+
+// IssueImpersonationToken returns a short-lived access token for subject that
+// records actor as the one really acting. No refresh token is issued.
+func (s *TokenService) IssueImpersonationToken(subject, actor *models.User) (string, time.Time, error) {
+	jti, err := randomID()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(ImpersonationTokenTTL)
+	claims := Claims{
+		UserID:        subject.ID,
+		Role:          subject.Role,
+		Permissions:   subject.EffectivePermissions(),
+		TokenType:     TokenTypeAccess,
+		EmailVerified: subject.EmailVerified,
+		Actor: &Actor{
+			Subject: fmt.Sprint(actor.ID),
+			UserID:  actor.ID,
+			Email:   actor.Email,
+		},
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Issuer:    s.keys.Issuer(),
+			Subject:   fmt.Sprint(subject.ID),
+			Audience:  s.keys.TokenAudiences(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+
+	signed, err := s.keys.Sign(claims)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return signed, expiresAt, nil
+}
+
+// ParseAccessToken validates an access token meant for this service and
+// rejects revoked token IDs. A token from a trusted issuer stands for the
+// local user its subject is linked to.
+func (s *TokenService) ParseAccessToken(tokenString string) (*Claims, error) {
+	claims, err := s.parseToken(tokenString, jwt.WithAudience(s.keys.Audience()))
+	if err != nil || claims.TokenType != TokenTypeAccess {
+		return nil, ErrInvalidToken
+	}
+	if claims.Issuer != s.keys.Issuer() {
+		if err := s.resolveLinkedUser(claims); err != nil {
+			return nil, err
+		}
+	}
+
+	var count int64
+	if err := s.db.Model(&models.RevokedToken{}).Where("jti = ?", claims.ID).Count(&count).Error; err != nil {
+		return nil, fmt.Errorf("failed to check token revocation: %w", err)
+	}
+	if count > 0 {
+		return nil, ErrTokenRevoked
+	}
+
+	return claims, nil
+}
+
+// RevokeSession ends the session an access token belongs to. If the token was
+// issued together with a refresh token, the whole family is revoked; otherwise
+// only the access token itself is.
+func (s *TokenService) RevokeSession(claims *Claims) error {
+	if claims.SessionID != "" {
+		return s.RevokeFamily(claims.SessionID)
+	}
+
+	var record models.RefreshToken
+	err := s.db.Where("access_token_id = ? AND user_id = ?", claims.ID, claims.UserID).First(&record).Error
+	if err == nil {
+		return s.RevokeFamily(record.FamilyID)
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	expiresAt := time.Now().Add(AccessTokenTTL)
+	if claims.ExpiresAt != nil {
+		expiresAt = claims.ExpiresAt.Time
+	}
+	return s.RevokeAccessToken(claims.ID, claims.UserID, expiresAt)
+}
+
+// RevokeRefreshToken revokes the family of the given refresh token if it
+// belongs to the user
+func (s *TokenService) RevokeRefreshToken(userID uint, refreshToken string) error {
+	var record models.RefreshToken
+	if err := s.db.Where("token_hash = ? AND user_id = ?", HashToken(refreshToken), userID).First(&record).Error; err != nil {
+		return ErrInvalidRefreshToken
+	}
+	return s.RevokeFamily(record.FamilyID)
+}
+
+// RevokeFamily revokes every refresh token in a family together with any
+// access tokens issued from it that have not yet expired
+func (s *TokenService) RevokeFamily(familyID string) error {
+	return s.revokeWhere("family_id = ?", familyID)
+}
+
+// RevokeUserTokens revokes every refresh and access token issued to a user
+func (s *TokenService) RevokeUserTokens(userID uint) error {
+	return s.revokeWhere("user_id = ?", userID)
+}
+
+// ListSessions returns the user's sessions, most recently used first. Ended
+// sessions are only included when includeEnded is set.
+func (s *TokenService) ListSessions(userID uint, includeEnded bool) ([]models.Session, error) {
+	query := s.db.Where("user_id = ?", userID).Order("last_seen_at DESC")
+	if !includeEnded {
+		query = query.Where("revoked_at IS NULL AND expires_at > ?", time.Now())
+	}
+
+	var sessions []models.Session
+	if err := query.Find(&sessions).Error; err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// RevokeUserSession ends one of the user's sessions by ID
+func (s *TokenService) RevokeUserSession(userID, sessionID uint) error {
+	var session models.Session
+	if err := s.db.Where("id = ? AND user_id = ?", sessionID, userID).First(&session).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrSessionNotFound
+		}
+		return err
+	}
+	return s.RevokeFamily(session.FamilyID)
+}
+
+// RevokeOtherSessions ends every session of the user except keepSessionID,
+// which may be empty to end them all
+func (s *TokenService) RevokeOtherSessions(userID uint, keepSessionID string) error {
+	return s.revokeWhere("user_id = ? AND family_id <> ?", userID, keepSessionID)
+}
+
+// TouchSession records activity on a session, at most once a minute
+func (s *TokenService) TouchSession(sessionID string, client ClientInfo) error {
+	now := time.Now()
+	return s.db.Model(&models.Session{}).
+		Where("family_id = ? AND last_seen_at < ?", sessionID, now.Add(-sessionTouchInterval)).
+		Updates(map[string]interface{}{"last_seen_at": now, "ip_address": client.IPAddress}).Error
+}
+
+// RevokeAccessToken adds a single access token ID to the deny-list
+func (s *TokenService) RevokeAccessToken(jti string, userID uint, expiresAt time.Time) error {
+	entry := models.RevokedToken{JTI: jti, UserID: userID, ExpiresAt: expiresAt}
+	return s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&entry).Error
+}
+
+// PurgeExpired deletes refresh tokens, sessions, deny-list entries and
+// abandoned SSO logins that can no longer be presented
+func (s *TokenService) PurgeExpired() error {
+	now := time.Now()
+	if err := s.db.Where("expires_at < ?", now).Delete(&models.RevokedToken{}).Error; err != nil {
+		return err
+	}
+	if err := s.db.Where("expires_at < ?", now).Delete(&models.OIDCLoginState{}).Error; err != nil {
+		return err
+	}
+	if err := s.db.Where("expires_at < ?", now).Delete(&models.Session{}).Error; err != nil {
+		return err
+	}
+	return s.db.Where("expires_at < ?", now).Delete(&models.RefreshToken{}).Error
+}
+
+func (s *TokenService) revokeWhere(query string, args ...interface{}) error {
+	return revokeIn(s.db, query, args...)
+}
+
+// RevokeUserTokensIn revokes every refresh and access token issued to a user
+// as part of the caller's transaction
+func RevokeUserTokensIn(tx *gorm.DB, userID uint) error {
+	return revokeIn(tx, "user_id = ?", userID)
+}
+
+// revokeIn revokes the refresh tokens and sessions matching query, and the
+// live access tokens issued from them, in a transaction on db
+func revokeIn(db *gorm.DB, query string, args ...interface{}) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var records []models.RefreshToken
+		if err := tx.Where(query, args...).Find(&records).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if err := tx.Model(&models.RefreshToken{}).
+			Where(query, args...).
+			Where("revoked_at IS NULL").
+			Update("revoked_at", now).Error; err != nil {
+			return err
+		}
+
+		// Sessions share the user_id and family_id columns, so the same
+		// condition selects the sessions being ended
+		if err := tx.Model(&models.Session{}).
+			Where(query, args...).
+			Where("revoked_at IS NULL").
+			Update("revoked_at", now).Error; err != nil {
+			return err
+		}
+
+		for _, record := range records {
+			accessExpiresAt := record.CreatedAt.Add(AccessTokenTTL)
+			if record.AccessTokenID == "" || now.After(accessExpiresAt) {
+				continue
+			}
+			entry := models.RevokedToken{JTI: record.AccessTokenID, UserID: record.UserID, ExpiresAt: accessExpiresAt}
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&entry).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (s *TokenService) issue(tx *gorm.DB, user *models.User, familyID string, mfaVerified bool) (*TokenPair, error) {
+	accessToken, jti, err := s.newAccessToken(user, familyID, mfaVerified)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := NewOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	record := models.RefreshToken{
+		UserID:        user.ID,
+		FamilyID:      familyID,
+		TokenHash:     HashToken(refreshToken),
+		AccessTokenID: jti,
+		MFAVerified:   mfaVerified,
+		ExpiresAt:     time.Now().Add(RefreshTokenTTL),
+	}
+	if err := tx.Create(&record).Error; err != nil {
+		return nil, fmt.Errorf("failed to store refresh token: %w", err)
+	}
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(AccessTokenTTL / time.Second),
+	}, nil
+}
+
+func (s *TokenService) newAccessToken(user *models.User, sessionID string, mfaVerified bool) (string, string, error) {
+	jti, err := randomID()
+	if err != nil {
+		return "", "", err
+	}
+
+	now := time.Now()
+	claims := Claims{
+		UserID:        user.ID,
+		Role:          user.Role,
+		Permissions:   user.EffectivePermissions(),
+		TokenType:     TokenTypeAccess,
+		MFA:           mfaVerified,
+		EmailVerified: user.EmailVerified,
+		SessionID:     sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Issuer:    s.keys.Issuer(),
+			Subject:   fmt.Sprint(user.ID),
+			Audience:  s.keys.TokenAudiences(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(AccessTokenTTL)),
+		},
+	}
+
+	signed, err := s.keys.Sign(claims)
+	if err != nil {
+		return "", "", err
+	}
+	return signed, jti, nil
+}
+
+// signPurposeToken signs a short-lived, non-access token such as an MFA
+// challenge or email verification link
+func (s *TokenService) signPurposeToken(claims Claims, ttl time.Duration) (string, error) {
+	jti, err := randomID()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ID:        jti,
+		Issuer:    s.keys.Issuer(),
+		Subject:   fmt.Sprint(claims.UserID),
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+	}
+
+	return s.keys.Sign(claims)
+}
+
+// TrustsIssuer reports whether access tokens from issuer are accepted
+func (s *TokenService) TrustsIssuer(issuer string) bool {
+	return s.keys.Trusts(issuer)
+}
+
+// resolveLinkedUser replaces the identity in a trusted issuer's token with the
+// local user an admin linked its subject to. The issuer's own user ID, role,
+// permissions and second factor mean nothing here.
+func (s *TokenService) resolveLinkedUser(claims *Claims) error {
+	if claims.Issuer == "" || claims.Subject == "" {
+		return ErrInvalidToken
+	}
+
+	var identity models.UserIdentity
+	err := s.db.Where("provider = ? AND subject = ?", claims.Issuer, claims.Subject).First(&identity).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrInvalidToken
+	}
+	if err != nil {
+		return fmt.Errorf("failed to look up linked user: %w", err)
+	}
+
+	var user models.User
+	if err := s.db.First(&user, identity.UserID).Error; err != nil || !user.IsActive {
+		return ErrInvalidToken
+	}
+
+	claims.UserID = user.ID
+	claims.Role = user.Role
+	claims.Permissions = user.EffectivePermissions()
+	claims.Email = user.Email
+	claims.EmailVerified = user.EmailVerified
+	claims.MFA = false
+	claims.SessionID = ""
+	claims.Actor = nil
+	return nil
+}
+
+func (s *TokenService) parseToken(tokenString string, options ...jwt.ParserOption) (*Claims, error) {
+	claims := &Claims{}
+	options = append(options, jwt.WithValidMethods(s.keys.ValidMethods()))
+	token, err := jwt.ParseWithClaims(tokenString, claims, s.keys.Keyfunc, options...)
+	if err != nil || !token.Valid || claims.ID == "" {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// parseOwnToken validates a token this service signed. MFA challenges and
+// email verification links are never accepted from a trusted issuer.
+func (s *TokenService) parseOwnToken(tokenString string) (*Claims, error) {
+	claims, err := s.parseToken(tokenString)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	if claims.Issuer != s.keys.Issuer() && (claims.Issuer != "" || time.Now().After(legacyIssuerCutoff)) {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+func randomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// NewOpaqueToken returns a random URL-safe token for refresh, reset and
+// similar single-use links
+func NewOpaqueToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken returns the hex SHA-256 of an opaque token for storage
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
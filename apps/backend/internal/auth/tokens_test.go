@@ -0,0 +1,157 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/models"
+)
+
+// newTestTokenService returns a token service with an ephemeral key on an
+// in-memory database, and a user to issue tokens to
+func newTestTokenService(t *testing.T) (*TokenService, *models.User) {
+	t.Helper()
+
+	t.Setenv("JWT_KEYS_DIR", "")
+	t.Setenv("JWT_ISSUER", "https://api.example")
+	t.Setenv("JWT_AUDIENCE", "")
+	t.Setenv("JWT_TOKEN_AUDIENCES", "")
+	t.Setenv("JWT_TRUSTED_ISSUERS", "")
+	keys, err := LoadKeySet()
+	if err != nil {
+		t.Fatalf("load keys: %v", err)
+	}
+
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := db.AutoMigrate(&models.User{}, &models.Session{}, &models.RefreshToken{}, &models.RevokedToken{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	user := &models.User{FirstName: "Ada", LastName: "Lovelace", Email: "ada@example.com", Role: models.RoleUser, IsActive: true}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return NewTokenService(db, keys), user
+}
+
+func TestRotateReplacesTokenPair(t *testing.T) {
+	tokens, user := newTestTokenService(t)
+
+	first, err := tokens.IssueTokenPair(user, true, ClientInfo{IPAddress: "192.0.2.1", UserAgent: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := tokens.Rotate(first.RefreshToken, ClientInfo{IPAddress: "192.0.2.2"})
+	if err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	if second.RefreshToken == first.RefreshToken || second.AccessToken == first.AccessToken {
+		t.Fatal("rotation returned the same tokens")
+	}
+
+	claims, err := tokens.ParseAccessToken(second.AccessToken)
+	if err != nil {
+		t.Fatalf("rotated access token rejected: %v", err)
+	}
+	if claims.UserID != user.ID || !claims.MFA {
+		t.Fatalf("rotated access token lost the session's claims: %+v", claims)
+	}
+
+	var session models.Session
+	tokens.db.Where("family_id = ?", claims.SessionID).First(&session)
+	if session.IPAddress != "192.0.2.2" {
+		t.Fatalf("session last seen from %q, want the rotating client", session.IPAddress)
+	}
+}
+
+func TestRefreshTokenReuseRevokesFamily(t *testing.T) {
+	tokens, user := newTestTokenService(t)
+
+	stolen, err := tokens.IssueTokenPair(user, false, ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := tokens.IssueTokenPair(user, false, ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := tokens.Rotate(stolen.RefreshToken, ClientInfo{})
+	if err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+
+	// Presenting the used token again gives the theft away
+	if _, err := tokens.Rotate(stolen.RefreshToken, ClientInfo{}); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("reused refresh token: err %v, want ErrRefreshTokenReused", err)
+	}
+
+	// Every token in the family stops working, including the newest
+	if _, err := tokens.Rotate(rotated.RefreshToken, ClientInfo{}); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("latest refresh token of a revoked family: err %v, want ErrInvalidRefreshToken", err)
+	}
+	if _, err := tokens.ParseAccessToken(rotated.AccessToken); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("access token of a revoked family: err %v, want ErrTokenRevoked", err)
+	}
+	claims, _ := tokens.parseToken(rotated.AccessToken)
+	var session models.Session
+	tokens.db.Where("family_id = ?", claims.SessionID).First(&session)
+	if session.RevokedAt == nil {
+		t.Fatal("session of a revoked family still active")
+	}
+
+	// The user's other sessions are left alone
+	if _, err := tokens.ParseAccessToken(other.AccessToken); err != nil {
+		t.Fatalf("access token of another session: %v", err)
+	}
+	if _, err := tokens.Rotate(other.RefreshToken, ClientInfo{}); err != nil {
+		t.Fatalf("refresh token of another session: %v", err)
+	}
+}
+
+func TestRevokeUserTokensEndsEverySession(t *testing.T) {
+	tokens, user := newTestTokenService(t)
+
+	var pairs []*TokenPair
+	for i := 0; i < 2; i++ {
+		pair, err := tokens.IssueTokenPair(user, false, ClientInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pairs = append(pairs, pair)
+	}
+
+	if err := tokens.RevokeUserTokens(user.ID); err != nil {
+		t.Fatal(err)
+	}
+	for i, pair := range pairs {
+		if _, err := tokens.ParseAccessToken(pair.AccessToken); !errors.Is(err, ErrTokenRevoked) {
+			t.Errorf("session %d access token: err %v, want ErrTokenRevoked", i, err)
+		}
+		if _, err := tokens.Rotate(pair.RefreshToken, ClientInfo{}); !errors.Is(err, ErrInvalidRefreshToken) {
+			t.Errorf("session %d refresh token: err %v, want ErrInvalidRefreshToken", i, err)
+		}
+	}
+
+	sessions, err := tokens.ListSessions(user.ID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 0 {
+		t.Fatalf("%d sessions still active", len(sessions))
+	}
+}
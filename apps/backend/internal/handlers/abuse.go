@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/models"
+
+	"github.com/gokulupadhyayguragain/addtocloud/libs/security/abuse"
+)
+
+// NewBlockedSubmissionRecorder returns an abuse callback that stores blocked
+// attempts so admins can review them. The guard hands over one attempt per
+// address and reason per record window, so a flood adds a row, not a row
+// per request.
+func NewBlockedSubmissionRecorder(db *gorm.DB) func(abuse.Block) {
+	return func(block abuse.Block) {
+		log.Printf("Warning: blocked %s submission from %s (%s)", block.Endpoint, block.IPAddress, block.Reason)
+
+		record := models.BlockedSubmission{
+			Endpoint:  block.Endpoint,
+			Reason:    block.Reason,
+			IPAddress: block.IPAddress,
+			Email:     block.Email,
+			UserAgent: block.UserAgent,
+		}
+		if err := db.Create(&record).Error; err != nil {
+			log.Printf("Warning: Failed to record blocked submission: %v", err)
+		}
+	}
+}
+
+// ListBlockedSubmissions returns recent blocked attempts on public forms.
+// Filter with endpoint, reason and ip.
+func (h *AdminHandler) ListBlockedSubmissions(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit < 1 || limit > 500 {
+		limit = 100
+	}
+
+	query := h.db.Order("created_at DESC").Limit(limit)
+	if endpoint := c.Query("endpoint"); endpoint != "" {
+		query = query.Where("endpoint = ?", endpoint)
+	}
+	if reason := c.Query("reason"); reason != "" {
+		query = query.Where("reason = ?", reason)
+	}
+	if ip := c.Query("ip"); ip != "" {
+		query = query.Where("ip_address = ?", ip)
+	}
+
+	var blocked []models.BlockedSubmission
+	if err := query.Find(&blocked).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch blocked submissions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"blockedSubmissions": blocked,
+		"total":              len(blocked),
+	})
+}
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/models"
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/policy"
+)
+
+type AccessPolicyHandler struct {
+	db *gorm.DB
+}
+
+func NewAccessPolicyHandler(db *gorm.DB) *AccessPolicyHandler {
+	return &AccessPolicyHandler{db: db}
+}
+
+type AccessPolicyRuleRequest struct {
+	Name     string   `json:"name" binding:"required,max=200"`
+	Type     string   `json:"type" binding:"required"`
+	Values   []string `json:"values"`
+	Negate   bool     `json:"negate"`
+	Action   string   `json:"action" binding:"required"`
+	Priority *int     `json:"priority"` // Lower runs first, defaults to 100
+	Enabled  *bool    `json:"enabled"`  // Defaults to true
+}
+
+// DryRunRequest is a sample access request to test the rules against
+type DryRunRequest struct {
+	Email          string `json:"email" binding:"required"`
+	Country        string `json:"country"`
+	BusinessReason string `json:"businessReason"`
+}
+
+// ListPolicyRules returns every rule in evaluation order
+func (h *AccessPolicyHandler) ListPolicyRules(c *gin.Context) {
+	var rules []models.AccessPolicyRule
+	if err := h.db.Order("priority, id").Find(&rules).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch policy rules"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"rules": rules,
+		"total": len(rules),
+	})
+}
+
+// CreatePolicyRule adds a rule
+func (h *AccessPolicyHandler) CreatePolicyRule(c *gin.Context) {
+	var req AccessPolicyRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rule := models.AccessPolicyRule{Priority: 100, Enabled: true, CreatedByID: c.GetUint("userID")}
+	req.apply(&rule)
+	if err := policy.Validate(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Select the booleans so a disabled rule is not replaced by the column default
+	if err := h.db.Select("*").Omit("id").Create(&rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create policy rule"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Policy rule created",
+		"rule":    rule,
+	})
+}
+
+// UpdatePolicyRule replaces a rule's settings
+func (h *AccessPolicyHandler) UpdatePolicyRule(c *gin.Context) {
+	var req AccessPolicyRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var rule models.AccessPolicyRule
+	if err := h.db.First(&rule, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Policy rule not found"})
+		return
+	}
+
+	req.apply(&rule)
+	if err := policy.Validate(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.db.Select("name", "type", "values", "negate", "action", "priority", "enabled").Updates(&rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update policy rule"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Policy rule updated",
+		"rule":    rule,
+	})
+}
+
+// DeletePolicyRule removes a rule
+func (h *AccessPolicyHandler) DeletePolicyRule(c *gin.Context) {
+	result := h.db.Delete(&models.AccessPolicyRule{}, c.Param("id"))
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete policy rule"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Policy rule not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Policy rule deleted"})
+}
+
+// DryRunPolicyRules shows what the current rules would do with a sample
+// request without creating anything
+func (h *AccessPolicyHandler) DryRunPolicyRules(c *gin.Context) {
+	var req DryRunRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rules, err := loadPolicyRules(h.db)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch policy rules"})
+		return
+	}
+
+	sample := models.AccessRequest{
+		Email:          req.Email,
+		Country:        req.Country,
+		BusinessReason: req.BusinessReason,
+	}
+	c.JSON(http.StatusOK, policy.Evaluate(rules, &sample))
+}
+
+func (r *AccessPolicyRuleRequest) apply(rule *models.AccessPolicyRule) {
+	rule.Name = r.Name
+	rule.Type = r.Type
+	rule.Values = r.Values
+	rule.Negate = r.Negate
+	rule.Action = r.Action
+	if r.Priority != nil {
+		rule.Priority = *r.Priority
+	}
+	if r.Enabled != nil {
+		rule.Enabled = *r.Enabled
+	}
+}
+
+func loadPolicyRules(db *gorm.DB) ([]models.AccessPolicyRule, error) {
+	var rules []models.AccessPolicyRule
+	err := db.Where("enabled = ?", true).Find(&rules).Error
+	return rules, err
+}
+
+// evaluatePolicies runs the rules against a new request. If the rules cannot
+// be loaded the request is left for manual review.
+func (h *AccessRequestHandler) evaluatePolicies(req *models.AccessRequest) policy.Result {
+	rules, err := loadPolicyRules(h.db)
+	if err != nil {
+		log.Printf("Failed to load access policy rules, leaving %s for review: %v", req.Email, err)
+		return policy.Result{Decision: policy.DecisionReview}
+	}
+	return policy.Evaluate(rules, req)
+}
+
+// applyPolicyDecision carries out an automatic approval or rejection of a
+// request that was just created, returning the account an approval created.
+// It must run in a transaction.
+func applyPolicyDecision(tx *gorm.DB, req *models.AccessRequest, result policy.Result) (*approvedAccount, error) {
+	if result.Rule == nil {
+		return nil, nil
+	}
+	actor := accessRequestActor{Type: models.AccessRequestActorSystem, Name: "policy:" + result.Rule.Name}
+	note := fmt.Sprintf("Matched policy rule %q", result.Rule.Name)
+
+	switch result.Decision {
+	case policy.DecisionApprove:
+		return approveAccessRequest(tx, req, actor, note)
+	case policy.DecisionReject:
+		return nil, transitionAccessRequest(tx, req, models.AccessRequestRejected, actor, note)
+	default:
+		return nil, nil
+	}
+}
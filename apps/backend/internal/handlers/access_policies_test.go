@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/models"
+	"github.com/gokulupadhyayguragain/addtocloud/backend/pkg/email"
+)
+
+func TestApproveRuleOnlyFlagsWhenQuorumRequired(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	for _, tc := range []struct {
+		quorum      string
+		wantStatus  string
+		wantFlagged string
+		wantUser    bool
+	}{
+		{"1", models.AccessRequestApproved, "", true},
+		{"2", models.AccessRequestPending, "trusted partner", false},
+	} {
+		t.Run("quorum "+tc.quorum, func(t *testing.T) {
+			t.Setenv("ACCESS_APPROVAL_QUORUM", tc.quorum)
+			db := newTestDB(t, &models.User{}, &models.AccessRequest{}, &models.AccessRequestTransition{},
+				&models.AccessPolicyRule{}, &models.PasswordResetToken{})
+			db.Create(&models.AccessPolicyRule{
+				Name: "trusted partner", Type: models.PolicyRuleEmailDomain, Values: []string{"example.com"},
+				Action: models.PolicyActionApprove, Enabled: true,
+			})
+
+			// An unconfigured mailer fails every send without side effects
+			handler := NewAccessRequestHandler(db, &email.SMTPConfig{})
+			router := gin.New()
+			router.POST("/access-requests", handler.SubmitAccessRequest)
+
+			body, _ := json.Marshal(map[string]string{
+				"firstName": "Vic", "lastName": "Tim", "email": "vic@example.com", "phone": "555-0100",
+				"company": "Example", "address": "1 Main St", "city": "Springfield", "country": "US",
+				"businessReason": "Staging environments",
+			})
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/access-requests", bytes.NewReader(body)))
+			if w.Code != http.StatusCreated {
+				t.Fatalf("status %d: %s", w.Code, w.Body)
+			}
+
+			var req models.AccessRequest
+			if err := db.First(&req).Error; err != nil {
+				t.Fatal(err)
+			}
+			if req.Status != tc.wantStatus || req.FlaggedReason != tc.wantFlagged {
+				t.Fatalf("request is %s flagged %q, want %s flagged %q", req.Status, req.FlaggedReason, tc.wantStatus, tc.wantFlagged)
+			}
+			var users int64
+			db.Model(&models.User{}).Where("email = ?", "vic@example.com").Count(&users)
+			if (users == 1) != tc.wantUser {
+				t.Fatalf("%d accounts created", users)
+			}
+		})
+	}
+}
@@ -1,21 +1,32 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"net/url"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/auth"
 	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/models"
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/policy"
+	"github.com/gokulupadhyayguragain/addtocloud/backend/pkg/email"
 )
 
+// accountSetupTTL is how long an approved user has to set their password
+const accountSetupTTL = 72 * time.Hour
+
 type AccessRequestHandler struct {
-	db *gorm.DB
+	db     *gorm.DB
+	mailer *email.SMTPConfig
 }
 
-func NewAccessRequestHandler(db *gorm.DB) *AccessRequestHandler {
-	return &AccessRequestHandler{db: db}
+func NewAccessRequestHandler(db *gorm.DB, mailer *email.SMTPConfig) *AccessRequestHandler {
+	return &AccessRequestHandler{db: db, mailer: mailer}
 }
 
 // SubmitAccessRequest handles new access requests
@@ -40,9 +51,10 @@ func (h *AccessRequestHandler) SubmitAccessRequest(c *gin.Context) {
 		return
 	}
 
-	// Check if email already has a pending or approved request
+	// Check if email already has an open or approved request
+	activeStatuses := append([]string{models.AccessRequestApproved}, models.OpenAccessRequestStatuses...)
 	var existingRequest models.AccessRequest
-	if err := h.db.Where("email = ? AND status IN ?", req.Email, []string{"pending", "approved"}).First(&existingRequest).Error; err == nil {
+	if err := h.db.Where("email = ? AND status IN ?", req.Email, activeStatuses).First(&existingRequest).Error; err == nil {
 		c.JSON(http.StatusConflict, gin.H{
 			"error":  "An access request with this email already exists",
 			"status": existingRequest.Status,
@@ -50,42 +62,93 @@ func (h *AccessRequestHandler) SubmitAccessRequest(c *gin.Context) {
 		return
 	}
 
-	// Set default status
-	req.Status = "pending"
-
-	// Create the access request
-	if err := h.db.Create(&req).Error; err != nil {
+	// The tracking token lets the requester answer questions and withdraw
+	// the request without an account
+	trackingToken, err := auth.NewOpaqueToken()
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to submit access request",
 		})
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{
-		"message":   "Access request submitted successfully",
-		"requestId": req.ID,
-		"status":    "pending",
-	})
-}
+	if req.AccessLevel == "" {
+		req.AccessLevel = models.AccessLevelStandard
+	}
+	if !models.IsValidAccessLevel(req.AccessLevel) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Unknown access level",
+		})
+		return
+	}
 
-// GetAccessRequests returns all access requests (admin only)
-func (h *AccessRequestHandler) GetAccessRequests(c *gin.Context) {
-	var requests []models.AccessRequest
+	// Set default status and clear fields only reviewers may set
+	req.ID = 0
+	req.Status = models.AccessRequestPending
+	req.ReviewedAt = nil
+	req.ReviewedBy = ""
+	req.ReviewNotes = ""
+	req.UserID = nil
+	req.FlaggedReason = ""
+	req.ReminderSentAt = nil
+	req.RequiredApprovals = getApprovalQuorum(approvedAccountRole)
+	req.TrackingTokenHash = auth.HashToken(trackingToken)
+
+	// Admin-managed rules may decide the request straight away
+	decision := h.evaluatePolicies(&req)
+	if decision.Decision == policy.DecisionApprove && req.RequiredApprovals > 1 {
+		// A rule cannot stand in for the reviewers a quorum requires
+		decision.Decision = policy.DecisionFlag
+	}
+	if decision.Decision == policy.DecisionFlag {
+		req.FlaggedReason = decision.Rule.Name
+	}
 
-	if err := h.db.Find(&requests).Error; err != nil {
+	// Create the access request together with its first history entry and
+	// apply any policy decision in the same transaction
+	var account *approvedAccount
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&req).Error; err != nil {
+			return err
+		}
+		err := tx.Create(&models.AccessRequestTransition{
+			AccessRequestID: req.ID,
+			ToStatus:        models.AccessRequestPending,
+			ActorType:       models.AccessRequestActorRequester,
+			ActorName:       req.Email,
+		}).Error
+		if err != nil {
+			return err
+		}
+		account, err = applyPolicyDecision(tx, &req, decision)
+		return err
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to fetch access requests",
+			"error": "Failed to submit access request",
 		})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"requests": requests,
-		"total":    len(requests),
+	h.sendReceipt(&req, trackingToken)
+	if account != nil {
+		h.sendAccountSetup(account)
+	}
+	if req.Status == models.AccessRequestRejected {
+		h.notifyRequester(&req, "Unfortunately we are unable to approve your request.")
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":       "Access request submitted successfully",
+		"requestId":     req.ID,
+		"status":        req.Status,
+		"trackingToken": trackingToken, // Also emailed; needed to check status and reply to reviewers
 	})
 }
 
-// ApproveAccessRequest approves an access request and creates user account
+// ApproveAccessRequest records the reviewer's approval. The user account is
+// created once the request has its required number of distinct approvers;
+// until then the response is 202 with the approvals so far.
 func (h *AccessRequestHandler) ApproveAccessRequest(c *gin.Context) {
 	requestID := c.Param("id")
 
@@ -97,70 +160,72 @@ func (h *AccessRequestHandler) ApproveAccessRequest(c *gin.Context) {
 		return
 	}
 
-	if accessReq.Status != "pending" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error":         "Access request is not pending",
+	if !models.CanTransitionAccessRequest(accessReq.Status, models.AccessRequestApproved) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":         "Access request cannot be approved",
 			"currentStatus": accessReq.Status,
 		})
 		return
 	}
 
-	// Generate auto password (you'll need to implement this)
-	autoPassword := generateSecurePassword()
-
-	// Create user account
-	user := models.User{
-		FirstName: accessReq.FirstName,
-		LastName:  accessReq.LastName,
-		Email:     accessReq.Email,
-		Phone:     accessReq.Phone,
-		Company:   accessReq.Company,
-		Address:   accessReq.Address,
-		IsActive:  true,
+	actor, ok := h.adminActor(c)
+	if !ok {
+		return
 	}
 
-	// Set the auto-generated password (you'll need to hash it)
-	if err := user.SetPassword(autoPassword); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to set user password",
+	// Nobody may vouch for a request from their own organization
+	if policy.SameEmailDomain(actor.Name, accessReq.Email) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Reviewers cannot approve requests from their own email domain",
 		})
 		return
 	}
 
-	// Start transaction
-	tx := h.db.Begin()
-
-	// Create user
-	if err := tx.Create(&user).Error; err != nil {
-		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to create user account",
+	var requestBody struct {
+		ReviewNotes string `json:"reviewNotes"`
+	}
+	_ = c.ShouldBindJSON(&requestBody)
+
+	var approvals []models.AccessRequestApproval
+	var account *approvedAccount
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		approvals, account, err = recordApproval(tx, &accessReq, actor, requestBody.ReviewNotes)
+		return err
+	})
+	if errors.Is(err, errAlreadyApproved) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":     "You have already approved this request",
+			"approvals": approvals,
 		})
 		return
 	}
+	if err != nil {
+		respondTransitionError(c, err, &accessReq, "Failed to approve access request")
+		return
+	}
 
-	// Update access request
-	now := time.Now()
-	accessReq.Status = "approved"
-	accessReq.ReviewedAt = &now
-	accessReq.UserID = &user.ID
-	accessReq.ReviewNotes = "Account created and credentials generated"
-
-	if err := tx.Save(&accessReq).Error; err != nil {
-		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to update access request",
+	if account == nil {
+		c.JSON(http.StatusAccepted, gin.H{
+			"message":           "Approval recorded, waiting for more reviewers",
+			"requestId":         accessReq.ID,
+			"approvals":         approvals,
+			"requiredApprovals": accessReq.RequiredApprovals,
 		})
 		return
 	}
 
-	tx.Commit()
+	h.sendAccountSetup(account)
 
+	message := "Access request approved and user account created. The user has been emailed a link to set their password."
+	if account.SetupToken == "" {
+		message = "Access request approved for the existing account with this email."
+	}
 	c.JSON(http.StatusOK, gin.H{
-		"message":           "Access request approved and user account created",
-		"userId":            user.ID,
-		"email":             user.Email,
-		"temporaryPassword": autoPassword, // You should email this instead of returning it
+		"message":   message,
+		"userId":    account.User.ID,
+		"email":     account.User.Email,
+		"approvals": approvals,
 	})
 }
 
@@ -176,30 +241,21 @@ func (h *AccessRequestHandler) RejectAccessRequest(c *gin.Context) {
 		return
 	}
 
-	if accessReq.Status != "pending" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error":         "Access request is not pending",
-			"currentStatus": accessReq.Status,
-		})
+	actor, ok := h.adminActor(c)
+	if !ok {
 		return
 	}
 
 	var requestBody struct {
 		ReviewNotes string `json:"reviewNotes"`
 	}
+	_ = c.ShouldBindJSON(&requestBody)
 
-	if err := c.ShouldBindJSON(&requestBody); err == nil {
-		accessReq.ReviewNotes = requestBody.ReviewNotes
-	}
-
-	now := time.Now()
-	accessReq.Status = "rejected"
-	accessReq.ReviewedAt = &now
-
-	if err := h.db.Save(&accessReq).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to reject access request",
-		})
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		return transitionAccessRequest(tx, &accessReq, models.AccessRequestRejected, actor, requestBody.ReviewNotes)
+	})
+	if err != nil {
+		respondTransitionError(c, err, &accessReq, "Failed to reject access request")
 		return
 	}
 
@@ -209,17 +265,177 @@ func (h *AccessRequestHandler) RejectAccessRequest(c *gin.Context) {
 	})
 }
 
-// Helper function to generate secure password
-func generateSecurePassword() string {
-	// Generate a secure random password
-	// This is a simple implementation - you might want to use a more sophisticated approach
-	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789!@#$%^&*"
-	const length = 16
+// approvedAccount is the user created by an approval and the token for the
+// link that lets them choose a password. SetupToken is empty when the
+// requester already had an account they can sign in to.
+type approvedAccount struct {
+	User       models.User
+	SetupToken string
+	RequestID  uint
+}
+
+// approveAccessRequest creates the user account for a request and marks it
+// approved. The account gets a random password nobody knows; the user sets
+// their own through the single-use link in the returned setup token. A user
+// who signed up on their own already has an account, which is promoted from
+// the pending role instead. It must run in a transaction.
+func approveAccessRequest(tx *gorm.DB, req *models.AccessRequest, actor accessRequestActor, note string) (*approvedAccount, error) {
+	var existing models.User
+	err := tx.Where("LOWER(email) = LOWER(?)", req.Email).First(&existing).Error
+	if err == nil {
+		return approveExistingAccount(tx, req, &existing, actor, note)
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	password, err := auth.NewOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	user := models.User{
+		FirstName: req.FirstName,
+		LastName:  req.LastName,
+		Email:     req.Email,
+		Phone:     req.Phone,
+		Company:   req.Company,
+		Address:   req.Address,
+		Role:      approvedAccountRole,
+		IsActive:  true,
+	}
+	if err := user.SetPassword(password); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Create(&user).Error; err != nil {
+		return nil, err
+	}
+	if err := transitionAccessRequest(tx, req, models.AccessRequestApproved, actor, note); err != nil {
+		return nil, err
+	}
+	req.UserID = &user.ID
+	if err := tx.Model(req).Update("user_id", user.ID).Error; err != nil {
+		return nil, err
+	}
+
+	setupToken, err := createAccountSetupToken(tx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &approvedAccount{User: user, SetupToken: setupToken, RequestID: req.ID}, nil
+}
+
+// approveExistingAccount approves a request for an address that already has
+// an account. Only the pending role is raised, so an approval never changes
+// the role of a user an admin has already set.
+//
+// A pending account whose address was never verified may have been
+// registered by someone else before the applicant applied. It is not handed
+// over as it is: its password, second factor, linked sign-ins, organization
+// memberships, API keys and sessions are cleared, and the applicant gets the
+// same setup link as a new account.
+func approveExistingAccount(tx *gorm.DB, req *models.AccessRequest, user *models.User, actor accessRequestActor, note string) (*approvedAccount, error) {
+	var setupToken string
+	if user.Role == models.RolePending {
+		if !user.EmailVerified {
+			var err error
+			if setupToken, err = reclaimUnverifiedAccount(tx, user); err != nil {
+				return nil, err
+			}
+		}
+		if err := tx.Model(user).Update("role", approvedAccountRole).Error; err != nil {
+			return nil, err
+		}
+	}
+	if err := transitionAccessRequest(tx, req, models.AccessRequestApproved, actor, note); err != nil {
+		return nil, err
+	}
+	req.UserID = &user.ID
+	if err := tx.Model(req).Update("user_id", user.ID).Error; err != nil {
+		return nil, err
+	}
+	return &approvedAccount{User: *user, SetupToken: setupToken, RequestID: req.ID}, nil
+}
+
+// reclaimUnverifiedAccount takes away every way into an account that whoever
+// registered it could have set up, and returns a setup token for the owner
+// of the address
+func reclaimUnverifiedAccount(tx *gorm.DB, user *models.User) (string, error) {
+	password, err := auth.NewOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	if err := user.SetPassword(password); err != nil {
+		return "", err
+	}
+	if err := tx.Model(user).Updates(map[string]interface{}{
+		"password":       user.Password,
+		"mfa_enabled":    false,
+		"mfa_secret":     "",
+		"mfa_last_step":  0,
+		"default_org_id": nil,
+	}).Error; err != nil {
+		return "", err
+	}
+
+	deletions := []interface{}{
+		&models.MFARecoveryCode{}, &models.UserIdentity{}, &models.PasswordResetToken{}, &models.OrganizationMember{},
+	}
+	for _, model := range deletions {
+		if err := tx.Where("user_id = ?", user.ID).Delete(model).Error; err != nil {
+			return "", err
+		}
+	}
+	if err := tx.Model(&models.APIKey{}).Where("user_id = ?", user.ID).
+		Update("status", models.APIKeyStatusRevoked).Error; err != nil {
+		return "", err
+	}
+	if err := auth.RevokeUserTokensIn(tx, user.ID); err != nil {
+		return "", err
+	}
+	return createAccountSetupToken(tx, user.ID)
+}
+
+// createAccountSetupToken issues the single-use link a user sets their
+// password with. The link is redeemed through the password reset endpoint.
+func createAccountSetupToken(tx *gorm.DB, userID uint) (string, error) {
+	setupToken, err := auth.NewOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	record := models.PasswordResetToken{
+		UserID:    userID,
+		TokenHash: auth.HashToken(setupToken),
+		ExpiresAt: time.Now().Add(accountSetupTTL),
+	}
+	if err := tx.Create(&record).Error; err != nil {
+		return "", err
+	}
+	return setupToken, nil
+}
 
-	password := make([]byte, length)
-	for i := range password {
-		password[i] = charset[time.Now().UnixNano()%int64(len(charset))]
+// sendAccountSetup emails a newly approved user the link to set their
+// password, or tells a user who already had an account that it is approved
+func (h *AccessRequestHandler) sendAccountSetup(account *approvedAccount) {
+	if account.SetupToken == "" {
+		requestID := fmt.Sprint(account.RequestID)
+		statusLink := accessRequestStatusLink(requestID, "")
+		user := account.User
+		go func() {
+			message := "Your request was approved. Sign in with your existing account to get started."
+			if err := h.mailer.SendAccessRequestMessage(user.Email, user.FirstName, requestID, models.AccessRequestApproved, message, statusLink); err != nil {
+				log.Printf("Failed to notify user %d of approval: %v", user.ID, err)
+			}
+		}()
+		return
 	}
 
-	return string(password)
+	setupLink := fmt.Sprintf("%s/set-password?token=%s", getAppURL(), url.QueryEscape(account.SetupToken))
+	user := account.User
+	go func() {
+		if err := h.mailer.SendAccountSetupEmail(user.Email, user.FirstName, setupLink, accountSetupTTL); err != nil {
+			log.Printf("Failed to send account setup email to user %d: %v", user.ID, err)
+		}
+	}()
 }
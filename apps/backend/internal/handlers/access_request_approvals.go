@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/models"
+)
+
+var (
+	errAlreadyApproved = errors.New("reviewer has already approved this request")
+	errSameEmailDomain = errors.New("reviewer shares the requester's email domain")
+)
+
+// approvedAccountRole is the role an approved request gives its account.
+// Approvals are counted against it, not against the access level the
+// requester asked for, which they choose themselves.
+const approvedAccountRole = models.RoleUser
+
+// getApprovalQuorum returns how many distinct reviewers must approve a request
+// whose approval grants role. ACCESS_APPROVAL_QUORUM sets it for the user
+// role; roles with administrative permissions always need two.
+func getApprovalQuorum(role string) int {
+	if role != models.RoleUser {
+		return 2
+	}
+	quorum := 1
+	if value := os.Getenv("ACCESS_APPROVAL_QUORUM"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			log.Printf("Warning: ignoring invalid ACCESS_APPROVAL_QUORUM %q", value)
+			return quorum
+		}
+		return n
+	}
+	return quorum
+}
+
+// recordApproval adds the reviewer's sign-off and approves the request once
+// enough distinct reviewers have signed off, returning the created account in
+// that case. The request row is locked so concurrent approvals are counted
+// one at a time. It must run in a transaction.
+func recordApproval(tx *gorm.DB, req *models.AccessRequest, actor accessRequestActor, notes string) ([]models.AccessRequestApproval, *approvedAccount, error) {
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(req, req.ID).Error; err != nil {
+		return nil, nil, err
+	}
+	if !models.CanTransitionAccessRequest(req.Status, models.AccessRequestApproved) {
+		return nil, nil, errInvalidTransition
+	}
+
+	var approvals []models.AccessRequestApproval
+	if err := tx.Where("access_request_id = ?", req.ID).Order("id").Find(&approvals).Error; err != nil {
+		return nil, nil, err
+	}
+	for _, approval := range approvals {
+		if approval.ReviewerID == *actor.ID {
+			return approvals, nil, errAlreadyApproved
+		}
+	}
+
+	approval := models.AccessRequestApproval{
+		AccessRequestID: req.ID,
+		ReviewerID:      *actor.ID,
+		ReviewerEmail:   actor.Name,
+		Notes:           strings.TrimSpace(notes),
+	}
+	if err := tx.Create(&approval).Error; err != nil {
+		return nil, nil, err
+	}
+	approvals = append(approvals, approval)
+
+	if len(approvals) < req.RequiredApprovals {
+		return approvals, nil, nil
+	}
+
+	reviewers := make([]string, len(approvals))
+	for i, a := range approvals {
+		reviewers[i] = a.ReviewerEmail
+	}
+	note := fmt.Sprintf("Approved by %s", strings.Join(reviewers, ", "))
+	if approval.Notes != "" {
+		note += ": " + approval.Notes
+	}
+	account, err := approveAccessRequest(tx, req, actor, note)
+	return approvals, account, err
+}
@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/models"
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/policy"
+)
+
+const maxBulkAccessRequests = 500
+
+// Bulk actions
+const (
+	bulkApprove = "approve"
+	bulkReject  = "reject"
+	bulkAssign  = "assign"
+)
+
+// Outcomes of one item in a bulk action
+const (
+	BulkResultApproved         = "approved"          // Account created
+	BulkResultApprovalRecorded = "approval_recorded" // More reviewers must approve before the account is created
+	BulkResultRejected         = "rejected"
+	BulkResultAssigned         = "assigned"
+	BulkResultSkipped          = "skipped" // Not in a state the action applies to
+	BulkResultFailed           = "failed"
+)
+
+type BulkAccessRequestAction struct {
+	Action     string               `json:"action" binding:"required,oneof=approve reject assign"`
+	IDs        []uint               `json:"ids"`
+	Filter     *AccessRequestFilter `json:"filter"` // Used when no IDs are given
+	Note       string               `json:"note" binding:"max=5000"`
+	AssigneeID *uint                `json:"assigneeId"` // Reviewer to assign; null unassigns
+}
+
+// BulkItemResult reports what happened to one request
+type BulkItemResult struct {
+	ID     uint   `json:"id"`
+	Result string `json:"result"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// BulkAccessRequests approves, rejects or assigns many requests at once,
+// chosen by ID or by the same filter as the list endpoint. Every request is
+// handled in its own transaction so one failure does not undo the rest, and
+// the response reports the outcome of each.
+func (h *AccessRequestHandler) BulkAccessRequests(c *gin.Context) {
+	var req BulkAccessRequestAction
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if (len(req.IDs) == 0) == (req.Filter == nil) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Provide either ids or filter"})
+		return
+	}
+
+	actor, ok := h.adminActor(c)
+	if !ok {
+		return
+	}
+
+	if req.Action == bulkAssign && req.AssigneeID != nil {
+		var assignee models.User
+		if err := h.db.First(&assignee, *req.AssigneeID).Error; err != nil || !assignee.IsActive {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Assignee not found"})
+			return
+		}
+		if !assignee.HasPermission(models.PermAccessRequestsReview) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Assignee cannot review access requests"})
+			return
+		}
+	}
+
+	query := h.db.Model(&models.AccessRequest{})
+	if len(req.IDs) > 0 {
+		if len(req.IDs) > maxBulkAccessRequests {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("At most %d requests can be changed at once", maxBulkAccessRequests)})
+			return
+		}
+		query = query.Where("id IN ?", req.IDs)
+	} else {
+		var err error
+		if query, err = req.Filter.apply(query); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	var targets []models.AccessRequest
+	if err := query.Order("id").Limit(maxBulkAccessRequests + 1).Find(&targets).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch access requests"})
+		return
+	}
+	if len(targets) > maxBulkAccessRequests {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Filter matches more than %d requests, narrow it down", maxBulkAccessRequests)})
+		return
+	}
+
+	// Report IDs that do not exist rather than dropping them silently
+	results := make([]BulkItemResult, 0, len(targets))
+	found := make(map[uint]bool, len(targets))
+	for _, target := range targets {
+		found[target.ID] = true
+	}
+	for _, id := range req.IDs {
+		if !found[id] {
+			results = append(results, BulkItemResult{ID: id, Result: BulkResultFailed, Reason: "not found"})
+			found[id] = true
+		}
+	}
+
+	summary := map[string]int{}
+	for _, result := range results {
+		summary[result.Result]++
+	}
+	for i := range targets {
+		result := h.bulkApply(&targets[i], req, actor)
+		summary[result.Result]++
+		results = append(results, result)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"action":  req.Action,
+		"results": results,
+		"summary": summary,
+		"total":   len(results),
+	})
+}
+
+// bulkApply carries out the action on one request in its own transaction
+func (h *AccessRequestHandler) bulkApply(target *models.AccessRequest, req BulkAccessRequestAction, actor accessRequestActor) BulkItemResult {
+	result := BulkItemResult{ID: target.ID}
+
+	var account *approvedAccount
+	var approvals []models.AccessRequestApproval
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		switch req.Action {
+		case bulkApprove:
+			if policy.SameEmailDomain(actor.Name, target.Email) {
+				return errSameEmailDomain
+			}
+			var err error
+			approvals, account, err = recordApproval(tx, target, actor, req.Note)
+			return err
+		case bulkReject:
+			return transitionAccessRequest(tx, target, models.AccessRequestRejected, actor, req.Note)
+		default:
+			return assignAccessRequest(tx, target, req.AssigneeID)
+		}
+	})
+
+	switch {
+	case err == nil:
+		switch {
+		case req.Action == bulkReject:
+			result.Result = BulkResultRejected
+		case req.Action == bulkAssign:
+			result.Result = BulkResultAssigned
+		case account != nil:
+			result.Result = BulkResultApproved
+			h.sendAccountSetup(account)
+		default:
+			result.Result = BulkResultApprovalRecorded
+			result.Reason = fmt.Sprintf("%d of %d approvals", len(approvals), target.RequiredApprovals)
+		}
+	case errors.Is(err, errInvalidTransition):
+		result.Result = BulkResultSkipped
+		result.Reason = "status is " + target.Status
+	case errors.Is(err, errAlreadyApproved):
+		result.Result = BulkResultSkipped
+		result.Reason = "already approved by you"
+	case errors.Is(err, errSameEmailDomain):
+		result.Result = BulkResultFailed
+		result.Reason = "reviewer shares the requester's email domain"
+	case errors.Is(err, errAccessRequestChanged):
+		result.Result = BulkResultFailed
+		result.Reason = "changed by someone else"
+	default:
+		log.Printf("Bulk %s of access request %d failed: %v", req.Action, target.ID, err)
+		result.Result = BulkResultFailed
+		result.Reason = "internal error"
+	}
+	return result
+}
+
+// assignAccessRequest sets the reviewer responsible for an open request. It
+// must run in a transaction.
+func assignAccessRequest(tx *gorm.DB, req *models.AccessRequest, assigneeID *uint) error {
+	if !req.IsOpen() {
+		return errInvalidTransition
+	}
+	result := tx.Model(&models.AccessRequest{}).
+		Where("id = ? AND status = ?", req.ID, req.Status).
+		UpdateColumn("assignee_id", assigneeID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errAccessRequestChanged
+	}
+	req.AssigneeID = assigneeID
+	return nil
+}
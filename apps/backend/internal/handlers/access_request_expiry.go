@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/models"
+)
+
+// expiryLockKey is the Postgres advisory lock that lets only one replica run
+// a sweep at a time
+const expiryLockKey = 0x61726578 // "arex"
+
+// maxSweepBatch bounds how many requests one sweep reminds about or expires
+const maxSweepBatch = 500
+
+// ExpiryConfig controls the stale access request job. A request is stale when
+// it has stayed open without a status change for the given time.
+type ExpiryConfig struct {
+	Interval    time.Duration // How often the job runs; zero disables it
+	RemindAfter time.Duration // Remind admins about requests open this long, and again after each further period
+	ExpireAfter time.Duration // Expire requests open this long
+}
+
+// ExpiryConfigFromEnv reads ACCESS_REQUEST_SWEEP_INTERVAL (a duration such as
+// 1h, or "off"), ACCESS_REQUEST_REMINDER_DAYS and ACCESS_REQUEST_EXPIRY_DAYS
+func ExpiryConfigFromEnv() ExpiryConfig {
+	config := ExpiryConfig{
+		Interval:    time.Hour,
+		RemindAfter: 3 * 24 * time.Hour,
+		ExpireAfter: 14 * 24 * time.Hour,
+	}
+
+	switch value := os.Getenv("ACCESS_REQUEST_SWEEP_INTERVAL"); value {
+	case "":
+	case "off", "0":
+		config.Interval = 0
+	default:
+		if interval, err := time.ParseDuration(value); err == nil && interval >= time.Minute {
+			config.Interval = interval
+		} else {
+			log.Printf("Warning: ignoring invalid ACCESS_REQUEST_SWEEP_INTERVAL %q", value)
+		}
+	}
+
+	for key, target := range map[string]*time.Duration{
+		"ACCESS_REQUEST_REMINDER_DAYS": &config.RemindAfter,
+		"ACCESS_REQUEST_EXPIRY_DAYS":   &config.ExpireAfter,
+	} {
+		if value := os.Getenv(key); value != "" {
+			if days, err := strconv.Atoi(value); err == nil && days > 0 {
+				*target = time.Duration(days) * 24 * time.Hour
+			} else {
+				log.Printf("Warning: ignoring invalid %s %q", key, value)
+			}
+		}
+	}
+	return config
+}
+
+// RunExpiryJob sweeps stale access requests every config.Interval until ctx
+// is cancelled. Every replica may run it; an advisory lock makes sure only one
+// sweep does the work at a time.
+func (h *AccessRequestHandler) RunExpiryJob(ctx context.Context, config ExpiryConfig) {
+	if config.Interval <= 0 {
+		log.Println("Access request expiry job disabled")
+		return
+	}
+
+	ticker := time.NewTicker(config.Interval)
+	defer ticker.Stop()
+	for {
+		if err := h.SweepStaleRequests(ctx, config); err != nil {
+			log.Printf("Access request sweep failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SweepStaleRequests reminds admins about requests waiting too long and
+// expires those past the deadline, notifying their requesters. Emails go out
+// once the sweep has committed.
+func (h *AccessRequestHandler) SweepStaleRequests(ctx context.Context, config ExpiryConfig) error {
+	now := time.Now()
+	var expired, reminders []models.AccessRequest
+
+	err := h.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Held until the transaction ends; another replica's sweep skips
+		var locked bool
+		if err := tx.Raw("SELECT pg_try_advisory_xact_lock(?)", expiryLockKey).Scan(&locked).Error; err != nil {
+			return err
+		}
+		if !locked {
+			return nil
+		}
+
+		var err error
+		if expired, err = expireStaleRequests(tx, now.Add(-config.ExpireAfter), config.ExpireAfter); err != nil {
+			return err
+		}
+		reminders, err = claimReminders(tx, now, config.RemindAfter)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := range expired {
+		h.notifyRequester(&expired[i], "Your request expired because it was not decided in time. You are welcome to submit a new request.")
+	}
+	if len(reminders) > 0 {
+		h.remindReviewers(reminders)
+	}
+	if len(expired) > 0 || len(reminders) > 0 {
+		log.Printf("Access request sweep: expired %d, reminded about %d", len(expired), len(reminders))
+	}
+	return nil
+}
+
+// expireStaleRequests moves requests with no status change since cutoff to
+// expired
+func expireStaleRequests(tx *gorm.DB, cutoff time.Time, after time.Duration) ([]models.AccessRequest, error) {
+	var stale []models.AccessRequest
+	if err := tx.Where("status IN ? AND updated_at < ?", models.OpenAccessRequestStatuses, cutoff).
+		Order("updated_at").Limit(maxSweepBatch).Find(&stale).Error; err != nil {
+		return nil, err
+	}
+
+	actor := accessRequestActor{Type: models.AccessRequestActorSystem, Name: "expiry"}
+	note := fmt.Sprintf("Expired after %d days without a decision", int(after.Hours()/24))
+
+	expired := make([]models.AccessRequest, 0, len(stale))
+	for i := range stale {
+		req := stale[i]
+		err := tx.Transaction(func(tx *gorm.DB) error {
+			return transitionAccessRequest(tx, &req, models.AccessRequestExpired, actor, note)
+		})
+		if err != nil {
+			// Someone acted on it since it was loaded; leave it alone
+			log.Printf("Skipped expiring access request %d: %v", req.ID, err)
+			continue
+		}
+		expired = append(expired, req)
+	}
+	return expired, nil
+}
+
+// claimReminders stamps and returns the open requests admins should hear
+// about: waiting at least remindAfter and not reminded within that period
+func claimReminders(tx *gorm.DB, now time.Time, remindAfter time.Duration) ([]models.AccessRequest, error) {
+	cutoff := now.Add(-remindAfter)
+	var due []models.AccessRequest
+	if err := tx.Where("status IN ? AND updated_at < ? AND (reminder_sent_at IS NULL OR reminder_sent_at < ?)",
+		models.OpenAccessRequestStatuses, cutoff, cutoff).
+		Order("updated_at").Limit(maxSweepBatch).Find(&due).Error; err != nil {
+		return nil, err
+	}
+	if len(due) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]uint, len(due))
+	for i := range due {
+		ids[i] = due[i].ID
+		due[i].ReminderSentAt = &now
+	}
+	// UpdateColumn leaves updated_at alone so a reminder does not reset staleness
+	if err := tx.Model(&models.AccessRequest{}).Where("id IN ?", ids).UpdateColumn("reminder_sent_at", now).Error; err != nil {
+		return nil, err
+	}
+	return due, nil
+}
+
+// remindReviewers emails every active reviewer a digest of waiting requests
+func (h *AccessRequestHandler) remindReviewers(requests []models.AccessRequest) {
+	var reviewers []models.User
+	if err := h.db.Where("is_active = ? AND (role = ? OR ? = ANY(permissions))",
+		true, models.RoleAdmin, models.PermAccessRequestsReview).Find(&reviewers).Error; err != nil {
+		log.Printf("Failed to load reviewers for access request reminder: %v", err)
+		return
+	}
+	if len(reviewers) == 0 {
+		log.Printf("No reviewers to remind about %d waiting access requests", len(requests))
+		return
+	}
+
+	now := time.Now()
+	lines := make([]string, len(requests))
+	for i, req := range requests {
+		lines[i] = fmt.Sprintf("#%d %s %s <%s>, %s, waiting %d days (%s)",
+			req.ID, req.FirstName, req.LastName, req.Email, req.Company,
+			int(now.Sub(req.UpdatedAt).Hours()/24), req.Status)
+	}
+	reviewLink := getAppURL() + "/admin/access-requests"
+
+	for _, reviewer := range reviewers {
+		address := reviewer.Email
+		go func() {
+			if err := h.mailer.SendAccessRequestReminder(address, lines, reviewLink); err != nil {
+				log.Printf("Failed to send access request reminder to %s: %v", address, err)
+			}
+		}()
+	}
+}
@@ -0,0 +1,396 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/models"
+)
+
+const maxAccessRequestPageSize = 200
+
+// accessRequestSortColumns maps the sort parameter to a column. Every sort is
+// broken by id so the cursor always points at exactly one row.
+var accessRequestSortColumns = map[string]string{
+	"createdAt": "created_at",
+	"updatedAt": "updated_at",
+	"email":     "email",
+	"company":   "company",
+	"country":   "country",
+	"lastName":  "last_name",
+	"status":    "status",
+}
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// accessRequestCursor marks the last row of a page: its sort value and id
+type accessRequestCursor struct {
+	Value string `json:"v"`
+	ID    uint   `json:"id"`
+}
+
+// accessRequestSort is a validated sort column and direction
+type accessRequestSort struct {
+	column string
+	desc   bool
+}
+
+// GetAccessRequests returns a page of access requests (admin only). Filter
+// with status (comma separated), country, company, createdFrom, createdTo,
+// assigneeId and q, a search across name, email, company and reason. Order
+// with sort and order; fetch the next page by passing back nextCursor as
+// cursor.
+func (h *AccessRequestHandler) GetAccessRequests(c *gin.Context) {
+	query, err := filterAccessRequests(c, h.db.Model(&models.AccessRequest{}))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	sort, err := parseAccessRequestSort(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > maxAccessRequestPageSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxAccessRequestPageSize)})
+		return
+	}
+
+	if cursor := c.Query("cursor"); cursor != "" {
+		if query, err = sort.after(query, cursor); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+	}
+
+	// One extra row tells us whether there is another page
+	var requests []models.AccessRequest
+	if err := sort.apply(query).Limit(limit + 1).Find(&requests).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch access requests",
+		})
+		return
+	}
+
+	var nextCursor string
+	if len(requests) > limit {
+		requests = requests[:limit]
+		nextCursor = sort.cursorFor(&requests[limit-1])
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"requests":   requests,
+		"total":      len(requests),
+		"nextCursor": nextCursor,
+	})
+}
+
+// ExportAccessRequests streams every access request matching the same filters
+// as GetAccessRequests as CSV (the default) or JSON lines. Rows are written as
+// they are read so the export never holds the whole result in memory.
+func (h *AccessRequestHandler) ExportAccessRequests(c *gin.Context) {
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "jsonl" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be csv or jsonl"})
+		return
+	}
+
+	query, err := filterAccessRequests(c, h.db.Model(&models.AccessRequest{}))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	sort, err := parseAccessRequestSort(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rows, err := sort.apply(query).Rows()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export access requests"})
+		return
+	}
+	defer rows.Close()
+
+	filename := fmt.Sprintf("access-requests-%s.%s", time.Now().UTC().Format("20060102-150405"), format)
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Header("Cache-Control", "no-store")
+	if format == "csv" {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+	} else {
+		c.Header("Content-Type", "application/x-ndjson")
+	}
+	c.Status(http.StatusOK)
+
+	write := exportAccessRequestCSV(c)
+	if format == "jsonl" {
+		write = exportAccessRequestJSON(c)
+	}
+
+	count := 0
+	for rows.Next() {
+		var req models.AccessRequest
+		if err := h.db.ScanRows(rows, &req); err != nil {
+			log.Printf("Access request export stopped after %d rows: %v", count, err)
+			return
+		}
+		if err := write(&req); err != nil {
+			// Usually the client went away
+			log.Printf("Access request export stopped after %d rows: %v", count, err)
+			return
+		}
+		count++
+		if count%100 == 0 {
+			c.Writer.Flush()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Access request export stopped after %d rows: %v", count, err)
+	}
+	_ = write(nil)
+	c.Writer.Flush()
+}
+
+// exportAccessRequestCSV returns a writer for CSV rows. Calling it with nil
+// flushes buffered output.
+func exportAccessRequestCSV(c *gin.Context) func(*models.AccessRequest) error {
+	w := csv.NewWriter(c.Writer)
+	header := false
+	return func(req *models.AccessRequest) error {
+		if !header {
+			header = true
+			if err := w.Write([]string{
+				"id", "status", "accessLevel", "firstName", "lastName", "email", "phone", "company",
+				"address", "city", "country", "businessReason", "projectDescription", "flaggedReason",
+				"reviewedBy", "reviewedAt", "reviewNotes", "userId", "createdAt", "updatedAt",
+			}); err != nil {
+				return err
+			}
+		}
+		if req == nil {
+			w.Flush()
+			return w.Error()
+		}
+
+		var reviewedAt, userID string
+		if req.ReviewedAt != nil {
+			reviewedAt = req.ReviewedAt.UTC().Format(time.RFC3339)
+		}
+		if req.UserID != nil {
+			userID = strconv.FormatUint(uint64(*req.UserID), 10)
+		}
+		err := w.Write([]string{
+			strconv.FormatUint(uint64(req.ID), 10), req.Status, req.AccessLevel,
+			csvSafe(req.FirstName), csvSafe(req.LastName), csvSafe(req.Email), csvSafe(req.Phone), csvSafe(req.Company),
+			csvSafe(req.Address), csvSafe(req.City), csvSafe(req.Country), csvSafe(req.BusinessReason),
+			csvSafe(req.ProjectDescription), req.FlaggedReason, req.ReviewedBy, reviewedAt, csvSafe(req.ReviewNotes),
+			userID, req.CreatedAt.UTC().Format(time.RFC3339), req.UpdatedAt.UTC().Format(time.RFC3339),
+		})
+		if err != nil {
+			return err
+		}
+		w.Flush()
+		return w.Error()
+	}
+}
+
+// exportAccessRequestJSON returns a writer for JSON lines
+func exportAccessRequestJSON(c *gin.Context) func(*models.AccessRequest) error {
+	encoder := json.NewEncoder(c.Writer)
+	return func(req *models.AccessRequest) error {
+		if req == nil {
+			return nil
+		}
+		return encoder.Encode(req)
+	}
+}
+
+// csvSafe stops spreadsheet programs from treating requester input as a
+// formula
+func csvSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// AccessRequestFilter selects access requests. The list and export endpoints
+// read it from the query string, bulk actions from the body.
+type AccessRequestFilter struct {
+	Status      string `json:"status" form:"status"` // Comma separated
+	Country     string `json:"country" form:"country"`
+	Company     string `json:"company" form:"company"`
+	CreatedFrom string `json:"createdFrom" form:"createdFrom"`
+	CreatedTo   string `json:"createdTo" form:"createdTo"`
+	AssigneeID  *uint  `json:"assigneeId" form:"assigneeId"`
+	Q           string `json:"q" form:"q"` // Search across name, email, company and reason
+}
+
+// filterAccessRequests applies the list filters from the query string
+func filterAccessRequests(c *gin.Context, query *gorm.DB) (*gorm.DB, error) {
+	var filter AccessRequestFilter
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		return nil, err
+	}
+	return filter.apply(query)
+}
+
+func (f *AccessRequestFilter) apply(query *gorm.DB) (*gorm.DB, error) {
+	if status := f.Status; status != "" {
+		statuses := strings.Split(status, ",")
+		for i, s := range statuses {
+			statuses[i] = strings.TrimSpace(s)
+			if !models.IsValidAccessRequestStatus(statuses[i]) {
+				return nil, fmt.Errorf("unknown status %q", statuses[i])
+			}
+		}
+		query = query.Where("status IN ?", statuses)
+	}
+	if country := strings.TrimSpace(f.Country); country != "" {
+		query = query.Where("LOWER(country) = LOWER(?)", country)
+	}
+	if company := strings.TrimSpace(f.Company); company != "" {
+		query = query.Where("company ILIKE ?", likePattern(company))
+	}
+	if f.AssigneeID != nil {
+		query = query.Where("assignee_id = ?", *f.AssigneeID)
+	}
+
+	if value := f.CreatedFrom; value != "" {
+		from, _, err := parseDateParam(value)
+		if err != nil {
+			return nil, errors.New("createdFrom must be a date (2006-01-02) or RFC 3339 time")
+		}
+		query = query.Where("created_at >= ?", from)
+	}
+	if value := f.CreatedTo; value != "" {
+		to, dateOnly, err := parseDateParam(value)
+		if err != nil {
+			return nil, errors.New("createdTo must be a date (2006-01-02) or RFC 3339 time")
+		}
+		// A plain date includes the whole day
+		if dateOnly {
+			query = query.Where("created_at < ?", to.AddDate(0, 0, 1))
+		} else {
+			query = query.Where("created_at <= ?", to)
+		}
+	}
+
+	if q := strings.TrimSpace(f.Q); q != "" {
+		pattern := likePattern(q)
+		query = query.Where(
+			"(first_name || ' ' || last_name ILIKE ? OR email ILIKE ? OR company ILIKE ? OR business_reason ILIKE ?)",
+			pattern, pattern, pattern, pattern,
+		)
+	}
+	return query, nil
+}
+
+func parseDateParam(value string) (time.Time, bool, error) {
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	return t, false, err
+}
+
+// likePattern turns user input into a "contains" pattern, escaping the LIKE
+// wildcards
+func likePattern(value string) string {
+	value = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+	return "%" + value + "%"
+}
+
+func parseAccessRequestSort(c *gin.Context) (accessRequestSort, error) {
+	column, ok := accessRequestSortColumns[c.DefaultQuery("sort", "createdAt")]
+	if !ok {
+		return accessRequestSort{}, errors.New("unknown sort field")
+	}
+	switch c.DefaultQuery("order", "desc") {
+	case "asc":
+		return accessRequestSort{column: column}, nil
+	case "desc":
+		return accessRequestSort{column: column, desc: true}, nil
+	default:
+		return accessRequestSort{}, errors.New("order must be asc or desc")
+	}
+}
+
+func (s accessRequestSort) apply(query *gorm.DB) *gorm.DB {
+	direction := "ASC"
+	if s.desc {
+		direction = "DESC"
+	}
+	return query.Order(s.column + " " + direction).Order("id " + direction)
+}
+
+func (s accessRequestSort) isTime() bool {
+	return s.column == "created_at" || s.column == "updated_at"
+}
+
+// after restricts the query to rows that come after the cursor
+func (s accessRequestSort) after(query *gorm.DB, token string) (*gorm.DB, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	var cursor accessRequestCursor
+	if err := json.Unmarshal(raw, &cursor); err != nil {
+		return nil, errInvalidCursor
+	}
+
+	var value interface{} = cursor.Value
+	if s.isTime() {
+		t, err := time.Parse(time.RFC3339Nano, cursor.Value)
+		if err != nil {
+			return nil, errInvalidCursor
+		}
+		value = t
+	}
+
+	op := ">"
+	if s.desc {
+		op = "<"
+	}
+	return query.Where(
+		fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?))", s.column, op),
+		value, value, cursor.ID,
+	), nil
+}
+
+// cursorFor encodes the position of req in this sort order
+func (s accessRequestSort) cursorFor(req *models.AccessRequest) string {
+	var value string
+	switch s.column {
+	case "created_at":
+		value = req.CreatedAt.Format(time.RFC3339Nano)
+	case "updated_at":
+		value = req.UpdatedAt.Format(time.RFC3339Nano)
+	case "email":
+		value = req.Email
+	case "company":
+		value = req.Company
+	case "country":
+		value = req.Country
+	case "last_name":
+		value = req.LastName
+	case "status":
+		value = req.Status
+	}
+	raw, _ := json.Marshal(accessRequestCursor{Value: value, ID: req.ID})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
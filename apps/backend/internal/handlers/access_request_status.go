@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/models"
+)
+
+// AccessRequestStatus is what a requester sees of their own request. It
+// leaves out reviewer identities and internal notes.
+type AccessRequestStatus struct {
+	ID          uint                   `json:"id"`
+	Status      string                 `json:"status"`
+	SubmittedAt time.Time              `json:"submittedAt"`
+	UpdatedAt   time.Time              `json:"updatedAt"`
+	DecidedAt   *time.Time             `json:"decidedAt"`
+	History     []AccessRequestStep    `json:"history"`
+	Messages    []AccessRequestMessage `json:"messages"`
+}
+
+// AccessRequestStep is one status change of a request
+type AccessRequestStep struct {
+	Status string    `json:"status"`
+	At     time.Time `json:"at"`
+}
+
+// AccessRequestMessage is a comment in the request's thread
+type AccessRequestMessage struct {
+	From string    `json:"from"` // reviewer or requester
+	Body string    `json:"body"`
+	At   time.Time `json:"at"`
+}
+
+// GetAccessRequestStatus lets a requester check their request with its ID
+// and tracking token. Unknown IDs and wrong tokens get the same 404.
+func (h *AccessRequestHandler) GetAccessRequestStatus(c *gin.Context) {
+	accessReq, ok := h.findByTrackingToken(c)
+	if !ok {
+		return
+	}
+
+	history, comments, err := h.loadThread(accessReq.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch access request"})
+		return
+	}
+
+	status := AccessRequestStatus{
+		ID:          accessReq.ID,
+		Status:      accessReq.Status,
+		SubmittedAt: accessReq.CreatedAt,
+		UpdatedAt:   accessReq.UpdatedAt,
+		DecidedAt:   accessReq.ReviewedAt,
+		History:     make([]AccessRequestStep, 0, len(history)),
+		Messages:    make([]AccessRequestMessage, 0, len(comments)),
+	}
+	for _, transition := range history {
+		status.History = append(status.History, AccessRequestStep{Status: transition.ToStatus, At: transition.CreatedAt})
+	}
+	for _, comment := range comments {
+		from := "reviewer"
+		if comment.AuthorType == models.AccessRequestActorRequester {
+			from = "requester"
+		}
+		status.Messages = append(status.Messages, AccessRequestMessage{From: from, Body: comment.Body, At: comment.CreatedAt})
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{"request": status})
+}
+
+// accessRequestStatusLink returns the page where a requester follows their
+// request. The token goes in the fragment so it never reaches server logs.
+func accessRequestStatusLink(requestID, trackingToken string) string {
+	link := fmt.Sprintf("%s/access-request/%s", getAppURL(), url.PathEscape(requestID))
+	if trackingToken != "" {
+		link += "#token=" + url.QueryEscape(trackingToken)
+	}
+	return link
+}
+
+// sendReceipt emails the requester their request ID and tracking token
+func (h *AccessRequestHandler) sendReceipt(req *models.AccessRequest, trackingToken string) {
+	requestID := fmt.Sprint(req.ID)
+	statusLink := accessRequestStatusLink(requestID, trackingToken)
+	address, name := req.Email, req.FirstName
+	go func() {
+		if err := h.mailer.SendAccessRequestReceipt(address, name, requestID, trackingToken, statusLink); err != nil {
+			log.Printf("Failed to send receipt for access request %s: %v", requestID, err)
+		}
+	}()
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"testing"
+
+	"gorm.io/gorm"
+
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/models"
+)
+
+func newApprovalTestDB(t *testing.T) *gorm.DB {
+	return newTestDB(t, &models.User{}, &models.AccessRequest{}, &models.AccessRequestTransition{},
+		&models.PasswordResetToken{}, &models.MFARecoveryCode{}, &models.UserIdentity{}, &models.APIKey{},
+		&models.OrganizationMember{}, &models.RefreshToken{}, &models.Session{}, &models.RevokedToken{})
+}
+
+// signedUpUser is an account registered on its own, waiting for an access
+// request under its address to be approved
+func signedUpUser(t *testing.T, db *gorm.DB, verified bool) *models.User {
+	t.Helper()
+	user := &models.User{
+		FirstName: "Vic", LastName: "Tim", Email: "vic@example.com", IsActive: true,
+		Role: models.RolePending, EmailVerified: verified, MFAEnabled: true, MFASecret: "JBSWY3DPEHPK3PXP",
+	}
+	if err := user.SetPassword("registered-password"); err != nil {
+		t.Fatal(err)
+	}
+	db.Create(user)
+	db.Create(&models.UserIdentity{UserID: user.ID, Provider: "corp", Subject: "someone"})
+	db.Create(&models.APIKey{UserID: user.ID, Name: "cli", KeyHash: "hash", KeyPrefix: "atc_", Status: models.APIKeyStatusActive})
+	db.Create(&models.OrganizationMember{OrganizationID: 9, UserID: user.ID, Role: models.OrgRoleOwner})
+	return user
+}
+
+func approveFor(t *testing.T, db *gorm.DB, email string) *approvedAccount {
+	t.Helper()
+	req := models.AccessRequest{FirstName: "Vic", LastName: "Tim", Email: email, Status: models.AccessRequestPending}
+	db.Create(&req)
+
+	var account *approvedAccount
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		account, err = approveAccessRequest(tx, &req, accessRequestActor{Type: "admin", Name: "reviewer"}, "")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("approve: %v", err)
+	}
+	return account
+}
+
+func TestApproveUnverifiedSignupReclaimsAccount(t *testing.T) {
+	db := newApprovalTestDB(t)
+	user := signedUpUser(t, db, false)
+
+	account := approveFor(t, db, "VIC@example.com")
+	if account.SetupToken == "" {
+		t.Fatal("no setup link issued for an account whose address was never verified")
+	}
+
+	var reloaded models.User
+	db.First(&reloaded, user.ID)
+	if reloaded.Role != models.RoleUser {
+		t.Fatalf("role %q, want %q", reloaded.Role, models.RoleUser)
+	}
+	if reloaded.CheckPassword("registered-password") {
+		t.Fatal("the password set at registration still works")
+	}
+	if reloaded.MFAEnabled || reloaded.MFASecret != "" {
+		t.Fatal("the second factor set up at registration is still enrolled")
+	}
+
+	for model, table := range map[interface{}]string{
+		&models.UserIdentity{}:       "linked sign-ins",
+		&models.OrganizationMember{}: "organization memberships",
+	} {
+		var count int64
+		db.Model(model).Where("user_id = ?", user.ID).Count(&count)
+		if count != 0 {
+			t.Fatalf("%d %s kept", count, table)
+		}
+	}
+	var active int64
+	db.Model(&models.APIKey{}).Where("user_id = ? AND status = ?", user.ID, models.APIKeyStatusActive).Count(&active)
+	if active != 0 {
+		t.Fatal("API key created at registration is still active")
+	}
+}
+
+func TestApproveVerifiedSignupPromotesAccount(t *testing.T) {
+	db := newApprovalTestDB(t)
+	user := signedUpUser(t, db, true)
+
+	account := approveFor(t, db, user.Email)
+	if account.SetupToken != "" {
+		t.Fatal("setup link issued for an account the owner can already sign in to")
+	}
+
+	var reloaded models.User
+	db.First(&reloaded, user.ID)
+	if reloaded.Role != models.RoleUser || !reloaded.CheckPassword("registered-password") || !reloaded.MFAEnabled {
+		t.Fatalf("verified account changed beyond its role: %+v", reloaded)
+	}
+}
+
+func TestApprovalQuorumFollowsGrantedRole(t *testing.T) {
+	t.Setenv("ACCESS_APPROVAL_QUORUM", "")
+	if got := getApprovalQuorum(models.RoleUser); got != 1 {
+		t.Fatalf("default quorum %d, want 1", got)
+	}
+	t.Setenv("ACCESS_APPROVAL_QUORUM", "2")
+	if got := getApprovalQuorum(approvedAccountRole); got != 2 {
+		t.Fatalf("configured quorum %d, want 2", got)
+	}
+	t.Setenv("ACCESS_APPROVAL_QUORUM", "0")
+	if got := getApprovalQuorum(models.RoleUser); got != 1 {
+		t.Fatalf("invalid quorum gave %d, want the default 1", got)
+	}
+	if got := getApprovalQuorum(models.RoleAdmin); got != 2 {
+		t.Fatalf("admin quorum %d, want 2", got)
+	}
+}
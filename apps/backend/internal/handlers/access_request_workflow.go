@@ -0,0 +1,401 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/auth"
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/models"
+)
+
+// TrackingTokenHeader carries the token a requester received on submission
+const TrackingTokenHeader = "X-Tracking-Token"
+
+var (
+	errInvalidTransition    = errors.New("invalid status transition")
+	errAccessRequestChanged = errors.New("access request was changed by someone else")
+)
+
+// accessRequestActor is whoever moves a request to a new status or comments
+// on it
+type accessRequestActor struct {
+	Type string
+	ID   *uint
+	Name string
+}
+
+type TransitionRequest struct {
+	Status string `json:"status" binding:"required"`
+	Note   string `json:"note" binding:"max=5000"`
+}
+
+type CommentRequest struct {
+	Body string `json:"body" binding:"required,max=5000"`
+	// Moves the request to needs_info so the requester knows an answer is expected
+	RequestInfo bool `json:"requestInfo"`
+}
+
+// GetAccessRequest returns a request with its history, comment thread and
+// the approvals collected so far
+func (h *AccessRequestHandler) GetAccessRequest(c *gin.Context) {
+	var accessReq models.AccessRequest
+	if err := h.db.First(&accessReq, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Access request not found"})
+		return
+	}
+
+	history, comments, err := h.loadThread(accessReq.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch access request"})
+		return
+	}
+
+	var approvals []models.AccessRequestApproval
+	if err := h.db.Where("access_request_id = ?", accessReq.ID).Order("id").Find(&approvals).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch access request"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"request":   accessReq,
+		"history":   history,
+		"comments":  comments,
+		"approvals": approvals,
+	})
+}
+
+// TransitionAccessRequest moves a request to another status, e.g. to escalate
+// it or mark it expired. Approval goes through ApproveAccessRequest because it
+// also creates the user account.
+func (h *AccessRequestHandler) TransitionAccessRequest(c *gin.Context) {
+	var req TransitionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !models.IsValidAccessRequestStatus(req.Status) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown status", "status": req.Status})
+		return
+	}
+	if req.Status == models.AccessRequestApproved {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Use the approve endpoint to approve a request"})
+		return
+	}
+	if req.Status == models.AccessRequestWithdrawn {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only the requester can withdraw a request"})
+		return
+	}
+
+	var accessReq models.AccessRequest
+	if err := h.db.First(&accessReq, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Access request not found"})
+		return
+	}
+
+	actor, ok := h.adminActor(c)
+	if !ok {
+		return
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		return transitionAccessRequest(tx, &accessReq, req.Status, actor, req.Note)
+	})
+	if err != nil {
+		respondTransitionError(c, err, &accessReq, "Failed to update access request")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Access request updated",
+		"request": accessReq,
+	})
+}
+
+// AddReviewerComment posts a reviewer's message to the requester. With
+// requestInfo set the request also moves to needs_info.
+func (h *AccessRequestHandler) AddReviewerComment(c *gin.Context) {
+	var req CommentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var accessReq models.AccessRequest
+	if err := h.db.First(&accessReq, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Access request not found"})
+		return
+	}
+
+	actor, ok := h.adminActor(c)
+	if !ok {
+		return
+	}
+
+	var comment models.AccessRequestComment
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if req.RequestInfo && accessReq.Status != models.AccessRequestNeedsInfo {
+			if err := transitionAccessRequest(tx, &accessReq, models.AccessRequestNeedsInfo, actor, "Waiting for the requester to answer"); err != nil {
+				return err
+			}
+		}
+		var err error
+		comment, err = addComment(tx, &accessReq, actor, req.Body)
+		return err
+	})
+	if err != nil {
+		respondTransitionError(c, err, &accessReq, "Failed to add comment")
+		return
+	}
+
+	h.notifyRequester(&accessReq, comment.Body)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Comment added",
+		"comment": comment,
+		"status":  accessReq.Status,
+	})
+}
+
+// ListRequesterComments returns the comment thread to the requester
+func (h *AccessRequestHandler) ListRequesterComments(c *gin.Context) {
+	accessReq, ok := h.findByTrackingToken(c)
+	if !ok {
+		return
+	}
+
+	var comments []models.AccessRequestComment
+	if err := h.db.Where("access_request_id = ?", accessReq.ID).Order("created_at").Find(&comments).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch comments"})
+		return
+	}
+
+	// Reviewers are shown by role only
+	for i := range comments {
+		comments[i].AuthorID = nil
+		if comments[i].AuthorType == models.AccessRequestActorAdmin {
+			comments[i].AuthorName = "AddToCloud reviewer"
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":   accessReq.Status,
+		"comments": comments,
+		"total":    len(comments),
+	})
+}
+
+// AddRequesterComment lets the requester answer reviewers. Answering a
+// request that needs more information sends it back for review.
+func (h *AccessRequestHandler) AddRequesterComment(c *gin.Context) {
+	var req CommentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	accessReq, ok := h.findByTrackingToken(c)
+	if !ok {
+		return
+	}
+	actor := requesterActor(accessReq)
+
+	var comment models.AccessRequestComment
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if comment, err = addComment(tx, accessReq, actor, req.Body); err != nil {
+			return err
+		}
+		if accessReq.Status == models.AccessRequestNeedsInfo {
+			return transitionAccessRequest(tx, accessReq, models.AccessRequestPending, actor, "Requester answered")
+		}
+		return nil
+	})
+	if err != nil {
+		respondTransitionError(c, err, accessReq, "Failed to add comment")
+		return
+	}
+
+	comment.AuthorID = nil
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Comment added",
+		"comment": comment,
+		"status":  accessReq.Status,
+	})
+}
+
+// WithdrawAccessRequest lets the requester cancel a request that has not been
+// decided yet
+func (h *AccessRequestHandler) WithdrawAccessRequest(c *gin.Context) {
+	accessReq, ok := h.findByTrackingToken(c)
+	if !ok {
+		return
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		return transitionAccessRequest(tx, accessReq, models.AccessRequestWithdrawn, requesterActor(accessReq), "Withdrawn by the requester")
+	})
+	if err != nil {
+		respondTransitionError(c, err, accessReq, "Failed to withdraw access request")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "Access request withdrawn",
+		"requestId": accessReq.ID,
+		"status":    accessReq.Status,
+	})
+}
+
+// transitionAccessRequest validates and applies a status change and records
+// it in the history. The update only succeeds if nobody changed the status
+// since req was loaded. It must run in a transaction.
+func transitionAccessRequest(tx *gorm.DB, req *models.AccessRequest, to string, actor accessRequestActor, note string) error {
+	if !models.CanTransitionAccessRequest(req.Status, to) {
+		return errInvalidTransition
+	}
+
+	now := time.Now()
+	updates := map[string]interface{}{"status": to, "updated_at": now}
+	decided := to == models.AccessRequestApproved || to == models.AccessRequestRejected
+	if decided {
+		updates["reviewed_at"] = now
+		updates["reviewed_by"] = actor.Name
+		updates["review_notes"] = note
+	}
+
+	result := tx.Model(&models.AccessRequest{}).
+		Where("id = ? AND status = ?", req.ID, req.Status).
+		Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errAccessRequestChanged
+	}
+
+	transition := models.AccessRequestTransition{
+		AccessRequestID: req.ID,
+		FromStatus:      req.Status,
+		ToStatus:        to,
+		ActorType:       actor.Type,
+		ActorID:         actor.ID,
+		ActorName:       actor.Name,
+		Note:            note,
+	}
+	if err := tx.Create(&transition).Error; err != nil {
+		return err
+	}
+
+	req.Status = to
+	req.UpdatedAt = now
+	if decided {
+		req.ReviewedAt = &now
+		req.ReviewedBy = actor.Name
+		req.ReviewNotes = note
+	}
+	return nil
+}
+
+// addComment appends to a request's thread. Decided requests are closed.
+func addComment(tx *gorm.DB, req *models.AccessRequest, actor accessRequestActor, body string) (models.AccessRequestComment, error) {
+	comment := models.AccessRequestComment{
+		AccessRequestID: req.ID,
+		AuthorType:      actor.Type,
+		AuthorID:        actor.ID,
+		AuthorName:      actor.Name,
+		Body:            strings.TrimSpace(body),
+	}
+	if !req.IsOpen() {
+		return comment, errInvalidTransition
+	}
+	return comment, tx.Create(&comment).Error
+}
+
+func respondTransitionError(c *gin.Context, err error, req *models.AccessRequest, message string) {
+	switch {
+	case errors.Is(err, errInvalidTransition):
+		c.JSON(http.StatusConflict, gin.H{
+			"error":         "Access request cannot be changed in its current status",
+			"currentStatus": req.Status,
+		})
+	case errors.Is(err, errAccessRequestChanged):
+		c.JSON(http.StatusConflict, gin.H{"error": "Access request was updated by someone else, reload and try again"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
+
+// adminActor identifies the reviewer making the request
+func (h *AccessRequestHandler) adminActor(c *gin.Context) (accessRequestActor, bool) {
+	claims, ok := currentClaims(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return accessRequestActor{}, false
+	}
+
+	var user models.User
+	if err := h.db.First(&user, claims.UserID).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return accessRequestActor{}, false
+	}
+
+	return accessRequestActor{Type: models.AccessRequestActorAdmin, ID: &user.ID, Name: user.Email}, true
+}
+
+func requesterActor(req *models.AccessRequest) accessRequestActor {
+	return accessRequestActor{
+		Type: models.AccessRequestActorRequester,
+		Name: strings.TrimSpace(req.FirstName + " " + req.LastName),
+	}
+}
+
+// findByTrackingToken loads the request named by :id if the caller presents
+// its tracking token, writing a 404 otherwise
+func (h *AccessRequestHandler) findByTrackingToken(c *gin.Context) (*models.AccessRequest, bool) {
+	return findAccessRequestByTrackingToken(h.db, c)
+}
+
+func findAccessRequestByTrackingToken(db *gorm.DB, c *gin.Context) (*models.AccessRequest, bool) {
+	token := c.GetHeader(TrackingTokenHeader)
+	var accessReq models.AccessRequest
+	if token == "" || db.Where("id = ? AND tracking_token_hash = ?", c.Param("id"), auth.HashToken(token)).First(&accessReq).Error != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Access request not found"})
+		return nil, false
+	}
+	return &accessReq, true
+}
+
+func (h *AccessRequestHandler) loadThread(requestID uint) ([]models.AccessRequestTransition, []models.AccessRequestComment, error) {
+	return loadAccessRequestThread(h.db, requestID)
+}
+
+func loadAccessRequestThread(db *gorm.DB, requestID uint) ([]models.AccessRequestTransition, []models.AccessRequestComment, error) {
+	var history []models.AccessRequestTransition
+	if err := db.Where("access_request_id = ?", requestID).Order("created_at, id").Find(&history).Error; err != nil {
+		return nil, nil, err
+	}
+	var comments []models.AccessRequestComment
+	if err := db.Where("access_request_id = ?", requestID).Order("created_at, id").Find(&comments).Error; err != nil {
+		return nil, nil, err
+	}
+	return history, comments, nil
+}
+
+// notifyRequester emails the requester a reviewer's message
+func (h *AccessRequestHandler) notifyRequester(req *models.AccessRequest, message string) {
+	requestID := fmt.Sprint(req.ID)
+	statusLink := accessRequestStatusLink(requestID, "")
+	address, name, status := req.Email, req.FirstName, req.Status
+	go func() {
+		if err := h.mailer.SendAccessRequestMessage(address, name, requestID, status, message, statusLink); err != nil {
+			log.Printf("Failed to notify requester of access request %s: %v", requestID, err)
+		}
+	}()
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"errors"
+	"testing"
+
+	"gorm.io/gorm"
+
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/models"
+)
+
+func TestTransitionAccessRequest(t *testing.T) {
+	db := newTestDB(t, &models.AccessRequest{}, &models.AccessRequestTransition{})
+	reviewer := accessRequestActor{Type: models.AccessRequestActorAdmin, Name: "reviewer"}
+
+	transition := func(req *models.AccessRequest, to string) error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			return transitionAccessRequest(tx, req, to, reviewer, "note")
+		})
+	}
+	transitions := func(req *models.AccessRequest) int64 {
+		var count int64
+		db.Model(&models.AccessRequestTransition{}).Where("access_request_id = ?", req.ID).Count(&count)
+		return count
+	}
+
+	for _, tc := range []struct {
+		from    string
+		to      string
+		wantErr error
+	}{
+		{models.AccessRequestPending, models.AccessRequestNeedsInfo, nil},
+		{models.AccessRequestNeedsInfo, models.AccessRequestRejected, nil},
+		{models.AccessRequestPending, models.AccessRequestPending, errInvalidTransition},
+		{models.AccessRequestRejected, models.AccessRequestPending, errInvalidTransition},
+		{models.AccessRequestApproved, models.AccessRequestRejected, errInvalidTransition},
+		{models.AccessRequestWithdrawn, models.AccessRequestApproved, errInvalidTransition},
+		{models.AccessRequestExpired, models.AccessRequestEscalated, errInvalidTransition},
+	} {
+		req := models.AccessRequest{FirstName: "Vic", LastName: "Tim", Email: tc.from + "-" + tc.to + "@example.com", Status: tc.from}
+		db.Create(&req)
+
+		err := transition(&req, tc.to)
+		if !errors.Is(err, tc.wantErr) {
+			t.Errorf("%s -> %s: err %v, want %v", tc.from, tc.to, err, tc.wantErr)
+			continue
+		}
+
+		var stored models.AccessRequest
+		db.First(&stored, req.ID)
+		if tc.wantErr != nil {
+			if stored.Status != tc.from || transitions(&req) != 0 {
+				t.Errorf("%s -> %s rejected but the request changed to %s", tc.from, tc.to, stored.Status)
+			}
+			continue
+		}
+		if stored.Status != tc.to || transitions(&req) != 1 {
+			t.Errorf("%s -> %s: stored status %s with %d transitions", tc.from, tc.to, stored.Status, transitions(&req))
+		}
+		decided := tc.to == models.AccessRequestRejected
+		if decided != (stored.ReviewedAt != nil) {
+			t.Errorf("%s -> %s: reviewed at %v", tc.from, tc.to, stored.ReviewedAt)
+		}
+	}
+}
+
+func TestTransitionAccessRequestDetectsConcurrentChange(t *testing.T) {
+	db := newTestDB(t, &models.AccessRequest{}, &models.AccessRequestTransition{})
+	reviewer := accessRequestActor{Type: models.AccessRequestActorAdmin, Name: "reviewer"}
+
+	req := models.AccessRequest{FirstName: "Vic", LastName: "Tim", Email: "vic@example.com", Status: models.AccessRequestPending}
+	db.Create(&req)
+
+	// Another reviewer escalates the request after this one loaded it
+	stale := req
+	db.Model(&models.AccessRequest{}).Where("id = ?", req.ID).Update("status", models.AccessRequestEscalated)
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		return transitionAccessRequest(tx, &stale, models.AccessRequestRejected, reviewer, "")
+	})
+	if !errors.Is(err, errAccessRequestChanged) {
+		t.Fatalf("transition from a stale status: err %v, want errAccessRequestChanged", err)
+	}
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/auth"
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/models"
+
+	"github.com/gokulupadhyayguragain/addtocloud/libs/security/lockout"
+)
+
+type AdminHandler struct {
+	db     *gorm.DB
+	tokens *auth.TokenService
+	guard  *lockout.Guard
+}
+
+type UpdateRoleRequest struct {
+	Role        string   `json:"role" binding:"required"`
+	Permissions []string `json:"permissions"`
+}
+
+func NewAdminHandler(db *gorm.DB, tokens *auth.TokenService, guard *lockout.Guard) *AdminHandler {
+	return &AdminHandler{db: db, tokens: tokens, guard: guard}
+}
+
+// UpdateUserRole changes a user's role and extra permissions (admin only)
+func (h *AdminHandler) UpdateUserRole(c *gin.Context) {
+	var req UpdateRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !models.IsValidRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role", "role": req.Role})
+		return
+	}
+	for _, permission := range req.Permissions {
+		if !models.IsValidPermission(permission) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown permission", "permission": permission})
+			return
+		}
+	}
+
+	var user models.User
+	if err := h.db.First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	user.Role = req.Role
+	user.Permissions = pq.StringArray(req.Permissions)
+	if err := h.db.Save(&user).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user role"})
+		return
+	}
+
+	// Existing tokens carry the old claims, so force the user to sign in again
+	if err := h.tokens.RevokeUserTokens(user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke existing sessions"})
+		return
+	}
+
+	user.Password = ""
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "User role updated",
+		"user":    user,
+	})
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/auth"
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/models"
+)
+
+const maxAPIKeyLifetimeDays = 365
+
+type APIKeyHandler struct {
+	db     *gorm.DB
+	tokens *auth.TokenService
+}
+
+type CreateAPIKeyRequest struct {
+	Name          string   `json:"name" binding:"required,max=255"`
+	Permissions   []string `json:"permissions" binding:"required,min=1"`
+	ExpiresInDays int      `json:"expiresInDays" binding:"omitempty,min=1"`
+}
+
+func NewAPIKeyHandler(db *gorm.DB, tokens *auth.TokenService) *APIKeyHandler {
+	return &APIKeyHandler{db: db, tokens: tokens}
+}
+
+// CreateAPIKey issues a new personal API key. The full key is only returned
+// in this response.
+func (h *APIKeyHandler) CreateAPIKey(c *gin.Context) {
+	claims, ok := currentClaims(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	// Keys must be created interactively so a leaked key cannot mint more
+	if claims.TokenType == auth.TokenTypeAPIKey {
+		c.JSON(http.StatusForbidden, gin.H{"error": "API keys cannot be used to create API keys"})
+		return
+	}
+
+	var req CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// A key can only carry permissions its owner already holds
+	for _, permission := range req.Permissions {
+		if !claims.HasPermission(permission) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":      "Cannot grant a permission you do not hold",
+				"permission": permission,
+			})
+			return
+		}
+	}
+
+	expiresInDays := req.ExpiresInDays
+	if expiresInDays == 0 || expiresInDays > maxAPIKeyLifetimeDays {
+		expiresInDays = maxAPIKeyLifetimeDays
+	}
+	expiresAt := time.Now().AddDate(0, 0, expiresInDays)
+
+	key, record, err := h.tokens.CreateAPIKey(claims.UserID, req.Name, req.Permissions, &expiresAt, claims.MFA)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "API key created. Copy it now, it will not be shown again.",
+		"key":     key,
+		"apiKey":  record,
+	})
+}
+
+// ListAPIKeys returns the current user's API keys without their secrets
+func (h *APIKeyHandler) ListAPIKeys(c *gin.Context) {
+	claims, ok := currentClaims(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var keys []models.APIKey
+	if err := h.db.Where("user_id = ?", claims.UserID).Order("created_at DESC").Find(&keys).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch API keys"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"apiKeys": keys,
+		"total":   len(keys),
+	})
+}
+
+// RevokeAPIKey permanently disables one of the current user's API keys
+func (h *APIKeyHandler) RevokeAPIKey(c *gin.Context) {
+	claims, ok := currentClaims(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	result := h.db.Model(&models.APIKey{}).
+		Where("id = ? AND user_id = ? AND status = ?", c.Param("id"), claims.UserID, models.APIKeyStatusActive).
+		Update("status", models.APIKeyStatusRevoked)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke API key"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+}
+
+// currentClaims returns the claims set by AuthMiddleware
+func currentClaims(c *gin.Context) (*auth.Claims, bool) {
+	value, exists := c.Get("claims")
+	if !exists {
+		return nil, false
+	}
+	claims, ok := value.(*auth.Claims)
+	return claims, ok
+}
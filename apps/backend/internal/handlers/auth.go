@@ -1,20 +1,26 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
-	"os"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/auth"
 	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/models"
+	"github.com/gokulupadhyayguragain/addtocloud/backend/pkg/email"
+
+	"github.com/gokulupadhyayguragain/addtocloud/libs/security/lockout"
 )
 
 type AuthHandler struct {
-	db *gorm.DB
+	db     *gorm.DB
+	tokens *auth.TokenService
+	mailer *email.SMTPConfig
+	guard  *lockout.Guard
 }
 
 type RegisterRequest struct {
@@ -29,21 +35,21 @@ type LoginRequest struct {
 	Password string `json:"password" binding:"required"`
 }
 
-type LoginResponse struct {
-	Token string      `json:"token"`
-	User  models.User `json:"user"`
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken" binding:"required"`
 }
 
-func getJWTSecret() []byte {
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		secret = "your-super-secret-jwt-key-change-in-production"
-	}
-	return []byte(secret)
+type LogoutRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+type LoginResponse struct {
+	*auth.TokenPair
+	User models.User `json:"user"`
 }
 
-func NewAuthHandler(db *gorm.DB) *AuthHandler {
-	return &AuthHandler{db: db}
+func NewAuthHandler(db *gorm.DB, tokens *auth.TokenService, mailer *email.SMTPConfig, guard *lockout.Guard) *AuthHandler {
+	return &AuthHandler{db: db, tokens: tokens, mailer: mailer, guard: guard}
 }
 
 func (h *AuthHandler) Register(c *gin.Context) {
@@ -67,12 +73,14 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
-	// Create user
+	// Create user. Self-registered accounts get no permissions until an
+	// access request for the address is approved.
 	user := models.User{
 		FirstName: req.FirstName,
 		LastName:  req.LastName,
 		Email:     req.Email,
 		Password:  string(hashedPassword),
+		Role:      models.RolePending,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
@@ -82,19 +90,31 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
-	// Generate JWT token
-	token, err := h.generateToken(user.ID)
+	h.sendVerificationEmail(&user)
+
+	// Remove password from response
+	user.Password = ""
+
+	// Unverified users cannot sign in yet, so there is nothing to issue
+	if EmailVerificationMode() == EmailVerificationLogin {
+		c.JSON(http.StatusCreated, gin.H{
+			"message":                     "Account created. Check your email to verify your address.",
+			"email_verification_required": true,
+			"user":                        user,
+		})
+		return
+	}
+
+	// Generate access and refresh tokens
+	tokens, err := h.tokens.IssueTokenPair(&user, false, clientInfo(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
-	// Remove password from response
-	user.Password = ""
-
 	c.JSON(http.StatusCreated, LoginResponse{
-		Token: token,
-		User:  user,
+		TokenPair: tokens,
+		User:      user,
 	})
 }
 
@@ -105,6 +125,10 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
+	if !beginAttempt(c, h.guard, lockoutRealmLogin, req.Email) {
+		return
+	}
+
 	// Find user
 	var user models.User
 	if err := h.db.Where("email = ?", req.Email).First(&user).Error; err != nil {
@@ -118,8 +142,46 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
-	// Generate JWT token
-	token, err := h.generateToken(user.ID)
+	recordSuccess(c, h.guard, lockoutRealmLogin, req.Email)
+	h.completeLogin(c, &user, false)
+}
+
+// completeLogin finishes a login once the first factor has been checked. It
+// enforces email verification and, for MFA-enabled users who have not yet
+// presented a second factor, returns a challenge instead of tokens.
+func (h *AuthHandler) completeLogin(c *gin.Context, user *models.User, mfaVerified bool) {
+	if !user.IsActive {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is disabled"})
+		return
+	}
+
+	if !user.EmailVerified && EmailVerificationMode() == EmailVerificationLogin {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":                       "Email address not verified",
+			"email_verification_required": true,
+		})
+		return
+	}
+
+	// With MFA enabled the first factor only earns a challenge token, which
+	// must be exchanged together with a TOTP code at /auth/mfa/verify
+	if user.MFAEnabled && !mfaVerified {
+		challenge, err := h.tokens.IssueMFAChallenge(user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"mfa_required": true,
+			"mfa_token":    challenge,
+			"expiresIn":    int64(auth.MFAChallengeTTL / time.Second),
+		})
+		return
+	}
+
+	// Generate access and refresh tokens
+	tokens, err := h.tokens.IssueTokenPair(user, mfaVerified, clientInfo(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
@@ -129,11 +191,66 @@ func (h *AuthHandler) Login(c *gin.Context) {
 	user.Password = ""
 
 	c.JSON(http.StatusOK, LoginResponse{
-		Token: token,
-		User:  user,
+		TokenPair: tokens,
+		User:      *user,
 	})
 }
 
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tokens, err := h.tokens.Rotate(req.RefreshToken, clientInfo(c))
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrRefreshTokenReused):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token reuse detected, session revoked"})
+		case errors.Is(err, auth.ErrInvalidRefreshToken):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired refresh token"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh token"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// Logout revokes the caller's current session. An optional refresh token in
+// the body is revoked as well.
+func (h *AuthHandler) Logout(c *gin.Context) {
+	claims, ok := currentClaims(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	if claims.TokenType == auth.TokenTypeAPIKey {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "API keys cannot be logged out, revoke the key instead"})
+		return
+	}
+
+	var req LogoutRequest
+	_ = c.ShouldBindJSON(&req)
+
+	if err := h.tokens.RevokeSession(claims); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+		return
+	}
+
+	if req.RefreshToken != "" {
+		if err := h.tokens.RevokeRefreshToken(claims.UserID, req.RefreshToken); err != nil && !errors.Is(err, auth.ErrInvalidRefreshToken) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke refresh token"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+}
+
 func (h *AuthHandler) GetProfile(c *gin.Context) {
 	userID, exists := c.Get("userID")
 	if !exists {
@@ -152,13 +269,3 @@ func (h *AuthHandler) GetProfile(c *gin.Context) {
 
 	c.JSON(http.StatusOK, user)
 }
-
-func (h *AuthHandler) generateToken(userID uint) (string, error) {
-	claims := jwt.MapClaims{
-		"user_id": userID,
-		"exp":     time.Now().Add(time.Hour * 24 * 7).Unix(), // 7 days
-	}
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(getJWTSecret())
-}
@@ -1,7 +1,10 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/services"
@@ -18,14 +21,13 @@ func NewCloudHandler(cloudService *services.CloudService) *CloudHandler {
 }
 
 func (h *CloudHandler) ListInstances(c *gin.Context) {
-	// TODO: Extract user ID from JWT token
-	userID := c.GetHeader("X-User-ID") // Temporary for testing
-	if userID == "" {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+	orgID, ok := currentOrganizationID(c)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "No organization selected"})
 		return
 	}
 
-	instances, err := h.cloudService.ListInstances(userID)
+	instances, err := h.cloudService.ListInstances(orgID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -38,12 +40,16 @@ func (h *CloudHandler) ListInstances(c *gin.Context) {
 }
 
 func (h *CloudHandler) CreateInstance(c *gin.Context) {
-	// TODO: Extract user ID from JWT token
-	userID := c.GetHeader("X-User-ID") // Temporary for testing
-	if userID == "" {
+	userID, ok := currentUserID(c)
+	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 		return
 	}
+	orgID, ok := currentOrganizationID(c)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "No organization selected"})
+		return
+	}
 
 	var req services.CreateInstanceRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -52,7 +58,12 @@ func (h *CloudHandler) CreateInstance(c *gin.Context) {
 	}
 
 	req.UserID = userID
+	req.OrganizationID = orgID
 	instance, err := h.cloudService.CreateInstance(req)
+	if errors.Is(err, services.ErrUnknownProvider) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "providers": h.cloudService.Providers()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -65,10 +76,9 @@ func (h *CloudHandler) CreateInstance(c *gin.Context) {
 }
 
 func (h *CloudHandler) DeleteInstance(c *gin.Context) {
-	// TODO: Extract user ID from JWT token
-	userID := c.GetHeader("X-User-ID") // Temporary for testing
-	if userID == "" {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+	orgID, ok := currentOrganizationID(c)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "No organization selected"})
 		return
 	}
 
@@ -78,14 +88,60 @@ func (h *CloudHandler) DeleteInstance(c *gin.Context) {
 		return
 	}
 
-	err := h.cloudService.DeleteInstance(instanceID, userID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := h.cloudService.DeleteInstance(instanceID, orgID); err != nil {
+		respondInstanceError(c, "delete", err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Instance deleted successfully",
+		"message": "Instance is being deleted",
+	})
+}
+
+func (h *CloudHandler) StartInstance(c *gin.Context) {
+	h.changeInstance(c, "start", "Instance is starting", h.cloudService.StartInstance)
+}
+
+func (h *CloudHandler) StopInstance(c *gin.Context) {
+	h.changeInstance(c, "stop", "Instance is stopping", h.cloudService.StopInstance)
+}
+
+func (h *CloudHandler) RebootInstance(c *gin.Context) {
+	h.changeInstance(c, "reboot", "Instance is rebooting", h.cloudService.RebootInstance)
+}
+
+// ResizeInstance changes an instance's CPU, memory and storage. Omitted fields
+// keep their current value.
+func (h *CloudHandler) ResizeInstance(c *gin.Context) {
+	var size services.InstanceSize
+	if err := c.ShouldBindJSON(&size); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	h.changeInstance(c, "resize", "Instance is being resized", func(id string, organizationID uint) (*services.Instance, error) {
+		return h.cloudService.ResizeInstance(id, organizationID, size)
+	})
+}
+
+// changeInstance runs an operation that is carried out in the background and
+// answers 202 with the instance in its new status
+func (h *CloudHandler) changeInstance(c *gin.Context, op, message string, change func(id string, organizationID uint) (*services.Instance, error)) {
+	orgID, ok := currentOrganizationID(c)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "No organization selected"})
+		return
+	}
+
+	instance, err := change(c.Param("id"), orgID)
+	if err != nil {
+		respondInstanceError(c, op, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":  message,
+		"instance": instance,
 	})
 }
 
@@ -101,3 +157,35 @@ func (h *CloudHandler) ListServices(c *gin.Context) {
 		"total":    len(services),
 	})
 }
+
+// respondInstanceError maps an error from an instance operation to a response.
+// An operation the instance's status does not allow is a conflict.
+func respondInstanceError(c *gin.Context, op string, err error) {
+	var transition *services.InstanceTransitionError
+	switch {
+	case errors.Is(err, services.ErrInstanceNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Instance not found"})
+	case errors.As(err, &transition):
+		c.JSON(http.StatusConflict, gin.H{
+			"error":  fmt.Sprintf("Cannot %s an instance that is %s", op, transition.From),
+			"status": transition.From,
+		})
+	case errors.Is(err, services.ErrInvalidSize):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// currentUserID returns the authenticated user's ID as set by AuthMiddleware
+func currentUserID(c *gin.Context) (string, bool) {
+	value, exists := c.Get("userID")
+	if !exists {
+		return "", false
+	}
+	userID, ok := value.(uint)
+	if !ok {
+		return "", false
+	}
+	return strconv.FormatUint(uint64(userID), 10), true
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/auth"
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/models"
+)
+
+// Values for EMAIL_VERIFICATION_REQUIRED
+const (
+	EmailVerificationOff          = "off"          // Never block unverified users
+	EmailVerificationLogin        = "login"        // Block login until verified
+	EmailVerificationProvisioning = "provisioning" // Allow login, block resource creation
+)
+
+const verificationResendCooldown = 2 * time.Minute
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type ResendVerificationRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// EmailVerificationMode returns where unverified email addresses are blocked
+func EmailVerificationMode() string {
+	switch mode := os.Getenv("EMAIL_VERIFICATION_REQUIRED"); mode {
+	case EmailVerificationOff, EmailVerificationLogin, EmailVerificationProvisioning:
+		return mode
+	default:
+		return EmailVerificationProvisioning
+	}
+}
+
+// VerifyEmail marks the address in a signed verification link as verified
+func (h *AuthHandler) VerifyEmail(c *gin.Context) {
+	var req VerifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	claims, err := h.tokens.ParseEmailVerification(req.Token)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification link"})
+		return
+	}
+
+	// The link is only valid for the address it was sent to
+	var user models.User
+	if err := h.db.Where("id = ? AND email = ?", claims.UserID, claims.Email).First(&user).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification link"})
+		return
+	}
+
+	if !user.EmailVerified {
+		now := time.Now()
+		if err := h.db.Model(&user).Updates(map[string]interface{}{
+			"email_verified":    true,
+			"email_verified_at": now,
+		}).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Email address verified",
+		"email":   user.Email,
+	})
+}
+
+// ResendVerification sends a new verification link. The response does not
+// reveal whether the address has an account, and resends within the cooldown
+// are silently dropped.
+func (h *AuthHandler) ResendVerification(c *gin.Context) {
+	var req ResendVerificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Where("email = ?", req.Email).First(&user).Error; err == nil && !user.EmailVerified {
+		h.sendVerificationEmail(&user)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "If this address needs verification, a new link has been sent",
+		"retryAfter": int64(verificationResendCooldown / time.Second),
+	})
+}
+
+// sendVerificationEmail emails a verification link unless one was sent within
+// the cooldown window
+func (h *AuthHandler) sendVerificationEmail(user *models.User) {
+	now := time.Now()
+	result := h.db.Model(&models.User{}).
+		Where("id = ? AND (verification_sent_at IS NULL OR verification_sent_at < ?)", user.ID, now.Add(-verificationResendCooldown)).
+		Update("verification_sent_at", now)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return
+	}
+
+	token, err := h.tokens.IssueEmailVerification(user)
+	if err != nil {
+		log.Printf("Failed to create verification token for user %d: %v", user.ID, err)
+		return
+	}
+
+	verifyLink := fmt.Sprintf("%s/verify-email?token=%s", getAppURL(), url.QueryEscape(token))
+	userID, email, name := user.ID, user.Email, user.FirstName
+	go func() {
+		if err := h.mailer.SendVerificationEmail(email, name, verifyLink, auth.EmailVerificationTTL); err != nil {
+			log.Printf("Failed to send verification email to user %d: %v", userID, err)
+		}
+	}()
+}
@@ -0,0 +1,413 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/auth"
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/models"
+)
+
+// erasureLockKey is the Postgres advisory lock that lets only one replica
+// carry out erasures at a time
+const erasureLockKey = 0x65726173 // "eras"
+
+// maxErasureBatch bounds how many erasures one run carries out
+const maxErasureBatch = 50
+
+// erasedName replaces names and actor names of erased people
+const erasedName = "Deleted user"
+
+var (
+	errErasureScheduled    = errors.New("an erasure is already scheduled")
+	errErasureNotScheduled = errors.New("erasure is no longer scheduled")
+)
+
+// ErasureConfig controls when scheduled erasures are carried out
+type ErasureConfig struct {
+	Interval    time.Duration // How often due erasures are processed; zero disables the job
+	GracePeriod time.Duration // Time from request to erasure, during which it can be cancelled
+}
+
+// ErasureConfigFromEnv reads ERASURE_SWEEP_INTERVAL (a duration such as 1h, or
+// "off") and ERASURE_GRACE_DAYS
+func ErasureConfigFromEnv() ErasureConfig {
+	config := ErasureConfig{
+		Interval:    time.Hour,
+		GracePeriod: 30 * 24 * time.Hour,
+	}
+
+	switch value := os.Getenv("ERASURE_SWEEP_INTERVAL"); value {
+	case "":
+	case "off", "0":
+		config.Interval = 0
+	default:
+		if interval, err := time.ParseDuration(value); err == nil && interval >= time.Minute {
+			config.Interval = interval
+		} else {
+			log.Printf("Warning: ignoring invalid ERASURE_SWEEP_INTERVAL %q", value)
+		}
+	}
+
+	if value := os.Getenv("ERASURE_GRACE_DAYS"); value != "" {
+		if days, err := strconv.Atoi(value); err == nil && days >= 0 {
+			config.GracePeriod = time.Duration(days) * 24 * time.Hour
+		} else {
+			log.Printf("Warning: ignoring invalid ERASURE_GRACE_DAYS %q", value)
+		}
+	}
+	return config
+}
+
+// ErasureSummary counts the rows an erasure removed or anonymized, by table
+type ErasureSummary struct {
+	Deleted    map[string]int64 `json:"deleted"`
+	Anonymized map[string]int64 `json:"anonymized"`
+}
+
+func newErasureSummary() *ErasureSummary {
+	return &ErasureSummary{Deleted: map[string]int64{}, Anonymized: map[string]int64{}}
+}
+
+// scheduleErasure creates a scheduled erasure unless one is already waiting
+// for the same subject. It must run in a transaction that has locked the
+// subject's user or access request row.
+func scheduleErasure(tx *gorm.DB, erasure *models.ErasureRequest, gracePeriod time.Duration) error {
+	query := tx.Model(&models.ErasureRequest{}).Where("status = ?", models.ErasureScheduled)
+	if erasure.UserID != nil {
+		query = query.Where("user_id = ?", *erasure.UserID)
+	} else {
+		query = query.Where("access_request_id = ?", *erasure.AccessRequestID)
+	}
+	var waiting int64
+	if err := query.Count(&waiting).Error; err != nil {
+		return err
+	}
+	if waiting > 0 {
+		return errErasureScheduled
+	}
+
+	erasure.Status = models.ErasureScheduled
+	erasure.ScheduledFor = time.Now().Add(gracePeriod)
+	return tx.Create(erasure).Error
+}
+
+// cancelErasure cancels an erasure still in its grace period
+func cancelErasure(db *gorm.DB, erasure *models.ErasureRequest) error {
+	now := time.Now()
+	result := db.Model(&models.ErasureRequest{}).
+		Where("id = ? AND status = ?", erasure.ID, models.ErasureScheduled).
+		Updates(map[string]interface{}{"status": models.ErasureCancelled, "cancelled_at": now})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errErasureNotScheduled
+	}
+	erasure.Status = models.ErasureCancelled
+	erasure.CancelledAt = &now
+	return nil
+}
+
+// RunErasureJob carries out due erasures every config.Interval until ctx is
+// cancelled. Every replica may run it; an advisory lock makes sure only one
+// run does the work at a time.
+func (h *PrivacyHandler) RunErasureJob(ctx context.Context) {
+	if h.config.Interval <= 0 {
+		log.Println("Erasure job disabled")
+		return
+	}
+
+	ticker := time.NewTicker(h.config.Interval)
+	defer ticker.Stop()
+	for {
+		if err := h.ProcessDueErasures(ctx); err != nil {
+			log.Printf("Erasure run failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessDueErasures carries out every scheduled erasure whose grace period
+// has ended. Each runs in its own savepoint so one failure does not block the
+// rest.
+func (h *PrivacyHandler) ProcessDueErasures(ctx context.Context) error {
+	var completed []models.ErasureRequest
+
+	err := h.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Held until the transaction ends; another replica's run skips
+		var locked bool
+		if err := tx.Raw("SELECT pg_try_advisory_xact_lock(?)", erasureLockKey).Scan(&locked).Error; err != nil {
+			return err
+		}
+		if !locked {
+			return nil
+		}
+
+		var due []models.ErasureRequest
+		if err := tx.Where("status = ? AND scheduled_for <= ?", models.ErasureScheduled, time.Now()).
+			Order("scheduled_for").Limit(maxErasureBatch).Find(&due).Error; err != nil {
+			return err
+		}
+
+		for i := range due {
+			erasure := due[i]
+			err := tx.Transaction(func(tx *gorm.DB) error {
+				return h.carryOutErasure(tx, &erasure)
+			})
+			if err != nil {
+				log.Printf("Erasure %d failed: %v", erasure.ID, err)
+				continue
+			}
+			completed = append(completed, erasure)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(completed) > 0 {
+		log.Printf("Erasure run: completed %d", len(completed))
+	}
+	return nil
+}
+
+// carryOutErasure purges the subject's data and marks the erasure completed.
+// It must run in a transaction.
+func (h *PrivacyHandler) carryOutErasure(tx *gorm.DB, erasure *models.ErasureRequest) error {
+	// Claimed first so a cancellation racing with the run either wins or
+	// waits and finds the erasure completed
+	now := time.Now()
+	result := tx.Model(&models.ErasureRequest{}).
+		Where("id = ? AND status = ?", erasure.ID, models.ErasureScheduled).
+		Updates(map[string]interface{}{"status": models.ErasureCompleted, "completed_at": now})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errErasureNotScheduled
+	}
+
+	summary := newErasureSummary()
+	var err error
+	if erasure.UserID != nil {
+		err = h.eraseUser(tx, *erasure.UserID, summary)
+	} else {
+		err = purgeAccessRequests(tx, []uint{*erasure.AccessRequestID}, summary)
+	}
+	if err != nil {
+		return err
+	}
+
+	encoded, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	if err := tx.Model(&models.ErasureRequest{}).Where("id = ?", erasure.ID).
+		Updates(map[string]interface{}{"email": "", "summary": string(encoded)}).Error; err != nil {
+		return err
+	}
+
+	entry := models.AuditLog{
+		UserID:       erasure.RequestedByID,
+		Action:       models.AuditErasureCompleted,
+		ResourceType: "erasure_request",
+		ResourceID:   fmt.Sprint(erasure.ID),
+		Details:      string(encoded),
+	}
+	if err := tx.Create(&entry).Error; err != nil {
+		return err
+	}
+
+	erasure.Status = models.ErasureCompleted
+	erasure.CompletedAt = &now
+	erasure.Email = ""
+	erasure.Summary = string(encoded)
+	return nil
+}
+
+// eraseUser removes a user's personal data and the instances only they could
+// reach. The user row itself is kept, anonymized, because audit entries,
+// instances and organizations refer to it by ID.
+func (h *PrivacyHandler) eraseUser(tx *gorm.DB, userID uint, summary *ErasureSummary) error {
+	var user models.User
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, userID).Error; err != nil {
+		return err
+	}
+
+	// Roles may have changed during the grace period. The erasure stays
+	// scheduled and is tried again on the next run.
+	if err := ensureAnotherAdmin(tx, &user); err != nil {
+		return err
+	}
+	if err := ensureOtherOwners(tx, userID); err != nil {
+		return err
+	}
+
+	// Ends sessions and deny-lists live access tokens before the rows they
+	// are found through are deleted
+	if err := auth.RevokeUserTokensIn(tx, userID); err != nil {
+		return err
+	}
+	email := strings.ToLower(user.Email)
+
+	// Earlier requests under the same address are only theirs if they proved
+	// they own it
+	var requestIDs []uint
+	query := tx.Unscoped().Model(&models.AccessRequest{}).Where("user_id = ?", userID)
+	if user.EmailVerified {
+		query = query.Or("LOWER(email) = ?", email)
+	}
+	if err := query.Pluck("id", &requestIDs).Error; err != nil {
+		return err
+	}
+	if err := purgeAccessRequests(tx, requestIDs, summary); err != nil {
+		return err
+	}
+
+	deletions := []struct {
+		model     interface{}
+		table     string
+		condition string
+		arg       interface{}
+	}{
+		{&models.RefreshToken{}, "refresh_tokens", "user_id = ?", userID},
+		{&models.Session{}, "user_sessions", "user_id = ?", userID},
+		{&models.APIKey{}, "api_keys", "user_id = ?", userID},
+		{&models.MFARecoveryCode{}, "mfa_recovery_codes", "user_id = ?", userID},
+		{&models.PasswordResetToken{}, "password_reset_tokens", "user_id = ?", userID},
+		{&models.UserIdentity{}, "user_identities", "user_id = ?", userID},
+		{&models.OrganizationMember{}, "organization_members", "user_id = ?", userID},
+		{&models.OrganizationInvitation{}, "organization_invitations", "LOWER(email) = ?", email},
+		{&models.LockoutEvent{}, "lockout_events", "LOWER(subject) = ?", email},
+		{&models.BlockedSubmission{}, "blocked_submissions", "LOWER(email) = ?", email},
+	}
+	for _, deletion := range deletions {
+		result := tx.Unscoped().Where(deletion.condition, deletion.arg).Delete(deletion.model)
+		if result.Error != nil {
+			return result.Error
+		}
+		summary.Deleted[deletion.table] += result.RowsAffected
+	}
+
+	// Nobody could manage or stop them once the account is gone. The rows go
+	// when the delete jobs have removed the resources at the provider.
+	if h.cloud == nil {
+		var live int64
+		if err := tx.Table("instances").Where("user_id = ?", fmt.Sprint(userID)).Count(&live).Error; err != nil {
+			return err
+		}
+		if live > 0 {
+			return errors.New("user has instances and cloud endpoints are disabled")
+		}
+	} else {
+		deleted, err := h.cloud.DeleteUserInstances(tx.Statement.Context, tx.Statement.ConnPool, userID)
+		if err != nil {
+			return err
+		}
+		summary.Deleted["instances"] += deleted
+	}
+
+	// Their name on other people's requests, from when they reviewed them
+	anonymizations := []struct {
+		model     interface{}
+		table     string
+		condition string
+		args      []interface{}
+		values    map[string]interface{}
+	}{
+		{&models.AccessRequestTransition{}, "access_request_history", "actor_id = ?", []interface{}{userID}, map[string]interface{}{"actor_name": erasedName}},
+		{&models.AccessRequestComment{}, "access_request_comments", "author_id = ?", []interface{}{userID}, map[string]interface{}{"author_name": erasedName}},
+		{&models.AccessRequestApproval{}, "access_request_approvals", "reviewer_id = ?", []interface{}{userID}, map[string]interface{}{"reviewer_email": ""}},
+		{&models.AccessRequest{}, "access_requests", "reviewed_by = ?", []interface{}{user.Email}, map[string]interface{}{"reviewed_by": erasedName}},
+		{&models.AuditLog{}, "audit_logs", "user_id = ?", []interface{}{userID}, map[string]interface{}{"ip_address": "", "user_agent": ""}},
+		{&models.Organization{}, "organizations", "personal = ? AND created_by_id = ?", []interface{}{true, userID}, map[string]interface{}{"name": "Personal workspace"}},
+	}
+	for _, anonymization := range anonymizations {
+		// UpdateColumns keeps updated_at, so erasing does not look like activity
+		result := tx.Unscoped().Model(anonymization.model).
+			Where(anonymization.condition, anonymization.args...).
+			UpdateColumns(anonymization.values)
+		if result.Error != nil {
+			return result.Error
+		}
+		summary.Anonymized[anonymization.table] += result.RowsAffected
+	}
+
+	// A random password nobody knows; the account can never be used again
+	password, err := auth.NewOpaqueToken()
+	if err != nil {
+		return err
+	}
+	if err := user.SetPassword(password); err != nil {
+		return err
+	}
+	result := tx.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"first_name":           "Deleted",
+		"last_name":            "User",
+		"email":                fmt.Sprintf("erased-%d@invalid", userID),
+		"phone":                "",
+		"company":              "",
+		"address":              "",
+		"password":             user.Password,
+		"is_active":            false,
+		"email_verified":       false,
+		"email_verified_at":    nil,
+		"verification_sent_at": nil,
+		"mfa_enabled":          false,
+		"mfa_secret":           "",
+		"permissions":          nil,
+		"default_org_id":       nil,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	summary.Anonymized["users"] += result.RowsAffected
+	return nil
+}
+
+// purgeAccessRequests hard-deletes access requests, including soft-deleted
+// ones, with their history, comments and approvals
+func purgeAccessRequests(tx *gorm.DB, ids []uint, summary *ErasureSummary) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	deletions := []struct {
+		model interface{}
+		table string
+	}{
+		{&models.AccessRequestTransition{}, "access_request_history"},
+		{&models.AccessRequestComment{}, "access_request_comments"},
+		{&models.AccessRequestApproval{}, "access_request_approvals"},
+	}
+	for _, deletion := range deletions {
+		result := tx.Where("access_request_id IN ?", ids).Delete(deletion.model)
+		if result.Error != nil {
+			return result.Error
+		}
+		summary.Deleted[deletion.table] += result.RowsAffected
+	}
+
+	result := tx.Unscoped().Where("id IN ?", ids).Delete(&models.AccessRequest{})
+	if result.Error != nil {
+		return result.Error
+	}
+	summary.Deleted["access_requests"] += result.RowsAffected
+	return nil
+}
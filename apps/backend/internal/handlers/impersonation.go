@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/auth"
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/models"
+)
+
+const maxAuditLogPageSize = 200
+
+type ImpersonateRequest struct {
+	Reason string `json:"reason" binding:"required,max=500"` // Recorded in the audit log, e.g. a support ticket
+}
+
+// ImpersonateUser issues a short-lived token that lets an admin see the API
+// as the given user. The token carries the admin as its actor, cannot make
+// changes and every request made with it is audited.
+func (h *AdminHandler) ImpersonateUser(c *gin.Context) {
+	claims, ok := currentClaims(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	// Only an interactive admin session may start an impersonation
+	if claims.TokenType == auth.TokenTypeAPIKey || claims.IsImpersonation() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Impersonation must be started from an admin session"})
+		return
+	}
+
+	var req ImpersonateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	subject, ok := h.findUser(c)
+	if !ok {
+		return
+	}
+	if subject.ID == claims.UserID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot impersonate yourself"})
+		return
+	}
+	// Acting as another admin would hand over their privileges
+	if subject.Role == models.RoleAdmin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Admins cannot be impersonated"})
+		return
+	}
+
+	var actor models.User
+	if err := h.db.First(&actor, claims.UserID).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	token, expiresAt, err := h.tokens.IssueImpersonationToken(subject, &actor)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue impersonation token"})
+		return
+	}
+
+	entry := models.AuditLog{
+		UserID:             &actor.ID,
+		ImpersonatedUserID: &subject.ID,
+		Action:             models.AuditImpersonationStart,
+		ResourceType:       "user",
+		ResourceID:         fmt.Sprint(subject.ID),
+		Method:             c.Request.Method,
+		Path:               c.Request.URL.Path,
+		StatusCode:         http.StatusOK,
+		Details:            req.Reason,
+		IPAddress:          c.ClientIP(),
+		UserAgent:          c.Request.UserAgent(),
+	}
+	if err := h.db.Create(&entry).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record audit log"})
+		return
+	}
+
+	log.Printf("Admin %d started impersonating user %d: %s", actor.ID, subject.ID, req.Reason)
+
+	subject.Password = ""
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "Impersonation started. The token is read-only and expires in " + auth.ImpersonationTokenTTL.String(),
+		"token":         token,
+		"expiresAt":     expiresAt,
+		"expiresIn":     int64(auth.ImpersonationTokenTTL / time.Second),
+		"impersonating": subject,
+		"actorId":       actor.ID,
+	})
+}
+
+// ListAuditLogs returns audit entries, newest first. Filter with userId (the
+// real actor), impersonatedUserId and action; page with limit and beforeId.
+func (h *AdminHandler) ListAuditLogs(c *gin.Context) {
+	query := h.db.Model(&models.AuditLog{}).Order("id DESC")
+
+	filters := []struct{ param, condition string }{
+		{"userId", "user_id = ?"},
+		{"impersonatedUserId", "impersonated_user_id = ?"},
+		{"beforeId", "id < ?"},
+	}
+	for _, filter := range filters {
+		value := c.Query(filter.param)
+		if value == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + filter.param})
+			return
+		}
+		query = query.Where(filter.condition, id)
+	}
+	if action := c.Query("action"); action != "" {
+		query = query.Where("action = ?", action)
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > maxAuditLogPageSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxAuditLogPageSize)})
+		return
+	}
+
+	var entries []models.AuditLog
+	if err := query.Limit(limit).Find(&entries).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch audit logs"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"auditLogs": entries,
+		"total":     len(entries),
+	})
+}
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/jobs"
+)
+
+const maxJobPageSize = 200
+
+// JobHandler lets admins inspect the background job queue and retry or
+// cancel jobs
+type JobHandler struct {
+	queue *jobs.Queue
+}
+
+func NewJobHandler(queue *jobs.Queue) *JobHandler {
+	return &JobHandler{queue: queue}
+}
+
+// ListJobs returns jobs, newest first. Filter with status and kind; page with
+// limit and beforeId.
+func (h *JobHandler) ListJobs(c *gin.Context) {
+	filter := jobs.ListFilter{Status: c.Query("status"), Kind: c.Query("kind")}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > maxJobPageSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxJobPageSize)})
+		return
+	}
+	filter.Limit = limit
+	if value := c.Query("beforeId"); value != "" {
+		if filter.BeforeID, err = strconv.ParseInt(value, 10, 64); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid beforeId"})
+			return
+		}
+	}
+
+	list, err := h.queue.List(c.Request.Context(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch jobs"})
+		return
+	}
+
+	response := gin.H{"jobs": list, "total": len(list)}
+	if len(list) == limit {
+		response["nextBeforeId"] = list[len(list)-1].ID
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// GetJob returns one job
+func (h *JobHandler) GetJob(c *gin.Context) {
+	id, ok := jobID(c)
+	if !ok {
+		return
+	}
+	job, err := h.queue.Get(c.Request.Context(), id)
+	if err != nil {
+		respondJobError(c, err, "Failed to fetch job")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"job": job})
+}
+
+// RetryJob puts a dead-lettered or cancelled job back in the queue with a
+// fresh set of attempts
+func (h *JobHandler) RetryJob(c *gin.Context) {
+	id, ok := jobID(c)
+	if !ok {
+		return
+	}
+	job, err := h.queue.Retry(c.Request.Context(), id)
+	if err != nil {
+		respondJobError(c, err, "Failed to retry job")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Job queued", "job": job})
+}
+
+// CancelJob stops a pending job from running
+func (h *JobHandler) CancelJob(c *gin.Context) {
+	id, ok := jobID(c)
+	if !ok {
+		return
+	}
+	job, err := h.queue.Cancel(c.Request.Context(), id)
+	if err != nil {
+		respondJobError(c, err, "Failed to cancel job")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Job cancelled", "job": job})
+}
+
+func jobID(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job ID"})
+		return 0, false
+	}
+	return id, true
+}
+
+func respondJobError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, jobs.ErrJobNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+	case errors.Is(err, jobs.ErrJobState):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
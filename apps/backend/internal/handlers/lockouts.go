@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/models"
+
+	"github.com/gokulupadhyayguragain/addtocloud/libs/security/lockout"
+)
+
+// Realms with independent failure counters
+const (
+	lockoutRealmLogin = "login"
+	lockoutRealmMFA   = "mfa"
+)
+
+type UnlockRequest struct {
+	Realm   string `json:"realm" binding:"required"`
+	Scope   string `json:"scope" binding:"required,oneof=account ip"`
+	Subject string `json:"subject" binding:"required"`
+}
+
+// NewLockoutRecorder returns a lockout callback that stores each event so
+// admins can review it
+func NewLockoutRecorder(db *gorm.DB) func(lockout.Event) {
+	return func(event lockout.Event) {
+		log.Printf("Warning: %s lockout of %s %s after %d failures (from %s) until %s",
+			event.Realm, event.Scope, event.Subject, event.Failures, event.IPAddress, event.LockedUntil.Format(time.RFC3339))
+
+		record := models.LockoutEvent{
+			Realm:       event.Realm,
+			Scope:       event.Scope,
+			Subject:     event.Subject,
+			IPAddress:   event.IPAddress,
+			Failures:    event.Failures,
+			LockedUntil: event.LockedUntil,
+		}
+		if err := db.Create(&record).Error; err != nil {
+			log.Printf("Warning: Failed to record lockout event: %v", err)
+		}
+	}
+}
+
+// beginAttempt counts an attempt against the account and client IP before the
+// credential is checked. It writes a 429 response and returns false while
+// either is locked out. Counter failures are logged and the attempt allowed so
+// an unavailable store cannot lock everyone out.
+func beginAttempt(c *gin.Context, guard *lockout.Guard, realm, account string) bool {
+	remaining, err := guard.Attempt(c.Request.Context(), realm, account, c.ClientIP())
+	if err != nil {
+		log.Printf("Warning: Failed to count %s attempt: %v", realm, err)
+		return true
+	}
+	if remaining > 0 {
+		respondLockedOut(c, remaining)
+		return false
+	}
+	return true
+}
+
+// recordSuccess clears the account's attempt count and takes the attempt off
+// the client's
+func recordSuccess(c *gin.Context, guard *lockout.Guard, realm, account string) {
+	if err := guard.Succeed(c.Request.Context(), realm, account, c.ClientIP()); err != nil {
+		log.Printf("Warning: Failed to reset %s attempts: %v", realm, err)
+	}
+}
+
+func respondLockedOut(c *gin.Context, remaining time.Duration) {
+	seconds := int(math.Ceil(remaining.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":      "Too many failed attempts. Please try again later.",
+		"retryAfter": seconds,
+	})
+}
+
+// ListLockouts returns recent lockout events. Pass active=true to only show
+// lockouts that have not yet expired.
+func (h *AdminHandler) ListLockouts(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit < 1 || limit > 500 {
+		limit = 100
+	}
+
+	query := h.db.Order("created_at DESC").Limit(limit)
+	if c.Query("active") == "true" {
+		query = query.Where("locked_until > ?", time.Now())
+	}
+	if realm := c.Query("realm"); realm != "" {
+		query = query.Where("realm = ?", realm)
+	}
+
+	var events []models.LockoutEvent
+	if err := query.Find(&events).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch lockout events"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"lockouts": events,
+		"total":    len(events),
+	})
+}
+
+// Unlock lifts a lockout before it expires
+func (h *AdminHandler) Unlock(c *gin.Context) {
+	var req UnlockRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Scope == lockout.ScopeAccount {
+		req.Subject = strings.ToLower(strings.TrimSpace(req.Subject))
+	}
+
+	if err := h.guard.Unlock(c.Request.Context(), req.Realm, req.Scope, req.Subject); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock"})
+		return
+	}
+
+	// Close out the recorded lockouts so they no longer show as active
+	h.db.Model(&models.LockoutEvent{}).
+		Where("realm = ? AND scope = ? AND subject = ? AND locked_until > ?", req.Realm, req.Scope, req.Subject, time.Now()).
+		Update("locked_until", time.Now())
+
+	c.JSON(http.StatusOK, gin.H{"message": "Unlocked"})
+}
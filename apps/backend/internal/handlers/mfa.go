@@ -0,0 +1,319 @@
+package handlers
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/auth"
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/models"
+)
+
+const recoveryCodeCount = 10
+
+type MFACodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type MFAVerifyRequest struct {
+	MFAToken     string `json:"mfaToken" binding:"required"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+type MFADisableRequest struct {
+	Password     string `json:"password" binding:"required"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+func getMFAIssuer() string {
+	issuer := os.Getenv("MFA_ISSUER")
+	if issuer == "" {
+		issuer = "AddToCloud"
+	}
+	return issuer
+}
+
+// EnrollMFA generates a new TOTP secret for the current user. MFA is not
+// enabled until the secret is confirmed with ConfirmMFA.
+func (h *AuthHandler) EnrollMFA(c *gin.Context) {
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+
+	if user.MFAEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "MFA is already enabled"})
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate MFA secret"})
+		return
+	}
+
+	if err := h.db.Model(user).Updates(map[string]interface{}{"mfa_secret": secret, "mfa_last_step": 0}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save MFA secret"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":          secret,
+		"provisioningUri": auth.TOTPProvisioningURI(secret, getMFAIssuer(), user.Email),
+	})
+}
+
+// ConfirmMFA enables MFA once the user proves their authenticator works and
+// returns a fresh set of recovery codes
+func (h *AuthHandler) ConfirmMFA(c *gin.Context) {
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+
+	if user.MFAEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "MFA is already enabled"})
+		return
+	}
+	if user.MFASecret == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "MFA enrollment has not been started"})
+		return
+	}
+
+	step, valid := auth.ValidateTOTP(user.MFASecret, req.Code, time.Now())
+	if !valid {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid MFA code"})
+		return
+	}
+
+	var codes []string
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Updates(map[string]interface{}{"mfa_enabled": true, "mfa_last_step": step}).Error; err != nil {
+			return err
+		}
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable MFA"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "MFA enabled",
+		"recoveryCodes": codes,
+	})
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes. Requires a
+// current TOTP code.
+func (h *AuthHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+
+	if !user.MFAEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "MFA is not enabled"})
+		return
+	}
+
+	if !h.checkSecondFactor(c, user, req.Code, "") {
+		return
+	}
+
+	var codes []string
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate recovery codes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recoveryCodes": codes})
+}
+
+// DisableMFA turns MFA off. Requires the password and a second factor.
+func (h *AuthHandler) DisableMFA(c *gin.Context) {
+	var req MFADisableRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+
+	if !user.MFAEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "MFA is not enabled"})
+		return
+	}
+
+	if !user.CheckPassword(req.Password) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		return
+	}
+
+	if !h.checkSecondFactor(c, user, req.Code, req.RecoveryCode) {
+		return
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Updates(map[string]interface{}{
+			"mfa_enabled":   false,
+			"mfa_secret":    "",
+			"mfa_last_step": 0,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", user.ID).Delete(&models.MFARecoveryCode{}).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable MFA"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "MFA disabled"})
+}
+
+// VerifyMFA completes a two-step login by exchanging the challenge token
+// returned from Login plus a TOTP or recovery code for a token pair
+func (h *AuthHandler) VerifyMFA(c *gin.Context) {
+	var req MFAVerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Code == "" && req.RecoveryCode == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A code or recovery code is required"})
+		return
+	}
+
+	claims, err := h.tokens.ParseMFAChallenge(req.MFAToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired MFA token"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.First(&user, claims.UserID).Error; err != nil || !user.MFAEnabled {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired MFA token"})
+		return
+	}
+
+	if !h.checkSecondFactor(c, &user, req.Code, req.RecoveryCode) {
+		return
+	}
+
+	h.completeLogin(c, &user, true)
+}
+
+// checkSecondFactor verifies a TOTP or recovery code, counting attempts
+// towards the user's MFA lockout. It writes an error response and returns
+// false if the code is not accepted.
+func (h *AuthHandler) checkSecondFactor(c *gin.Context, user *models.User, code, recoveryCode string) bool {
+	account := strconv.FormatUint(uint64(user.ID), 10)
+	if !beginAttempt(c, h.guard, lockoutRealmMFA, account) {
+		return false
+	}
+
+	valid, err := h.verifySecondFactor(user, code, recoveryCode)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify MFA code"})
+		return false
+	}
+	if !valid {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid MFA code"})
+		return false
+	}
+
+	recordSuccess(c, h.guard, lockoutRealmMFA, account)
+	return true
+}
+
+// verifySecondFactor checks a TOTP code or consumes a recovery code. TOTP
+// codes are single-use: a step at or before the last accepted one is refused.
+func (h *AuthHandler) verifySecondFactor(user *models.User, code, recoveryCode string) (bool, error) {
+	if code != "" {
+		step, valid := auth.ValidateTOTP(user.MFASecret, code, time.Now())
+		if !valid {
+			return false, nil
+		}
+		result := h.db.Model(&models.User{}).
+			Where("id = ? AND mfa_last_step < ?", user.ID, step).
+			Update("mfa_last_step", step)
+		return result.RowsAffected == 1, result.Error
+	}
+
+	if recoveryCode != "" {
+		result := h.db.Model(&models.MFARecoveryCode{}).
+			Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, auth.HashRecoveryCode(recoveryCode)).
+			Update("used_at", time.Now())
+		return result.RowsAffected == 1, result.Error
+	}
+
+	return false, nil
+}
+
+// currentUser loads the authenticated user, writing an error response and
+// returning false if that is not possible
+func (h *AuthHandler) currentUser(c *gin.Context) (*models.User, bool) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return nil, false
+	}
+
+	var user models.User
+	if err := h.db.Where("id = ?", userID).First(&user).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return nil, false
+	}
+
+	return &user, true
+}
+
+func replaceRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.MFARecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, code := range codes {
+		record := models.MFARecoveryCode{UserID: userID, CodeHash: auth.HashRecoveryCode(code)}
+		if err := tx.Create(&record).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return codes, nil
+}
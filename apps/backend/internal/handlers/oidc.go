@@ -0,0 +1,400 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/auth"
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/models"
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/oidc"
+)
+
+const oidcLoginStateTTL = 10 * time.Minute
+
+// oidcLoginCookie binds a login to the browser that started it. It holds the
+// state and PKCE verifier, so a code and state captured elsewhere cannot be
+// redeemed without it.
+const (
+	oidcLoginCookie     = "oidc_login"
+	oidcLoginCookiePath = "/api/v1/auth/oidc"
+)
+
+var (
+	errOIDCEmailNotVerified = errors.New("identity provider did not verify the email address")
+	errOIDCLinkRequired     = errors.New("existing account must link the identity while signed in")
+	errOIDCIdentityInUse    = errors.New("identity is linked to another user")
+)
+
+// OIDCHandler signs users in through external OpenID Connect providers and
+// hands off to AuthHandler to issue platform tokens
+type OIDCHandler struct {
+	db        *gorm.DB
+	auth      *AuthHandler
+	providers map[string]*oidc.Provider
+}
+
+type OIDCCallbackRequest struct {
+	Code  string `json:"code" binding:"required"`
+	State string `json:"state" binding:"required"`
+}
+
+func NewOIDCHandler(db *gorm.DB, authHandler *AuthHandler, providers map[string]*oidc.Provider) *OIDCHandler {
+	return &OIDCHandler{db: db, auth: authHandler, providers: providers}
+}
+
+// ListProviders returns the names of the configured identity providers
+func (h *OIDCHandler) ListProviders(c *gin.Context) {
+	names := make([]string, 0, len(h.providers))
+	for name := range h.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	c.JSON(http.StatusOK, gin.H{"providers": names})
+}
+
+// StartLogin begins the authorization code flow and returns the URL the
+// browser should be sent to. The request must be made with credentials so the
+// browser keeps the login cookie for the callback.
+func (h *OIDCHandler) StartLogin(c *gin.Context) {
+	h.startFlow(c, nil)
+}
+
+// StartLink begins the authorization code flow for linking an identity at the
+// provider to the signed-in user. The callback then links rather than signs
+// in.
+func (h *OIDCHandler) StartLink(c *gin.Context) {
+	claims, ok := currentClaims(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+	userID := claims.UserID
+	h.startFlow(c, &userID)
+}
+
+// ListIdentities returns the provider identities linked to the current user
+func (h *OIDCHandler) ListIdentities(c *gin.Context) {
+	claims, ok := currentClaims(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var identities []models.UserIdentity
+	if err := h.db.Where("user_id = ?", claims.UserID).Order("created_at").Find(&identities).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list identities"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"identities": identities})
+}
+
+// UnlinkIdentity removes one of the current user's provider identities
+func (h *OIDCHandler) UnlinkIdentity(c *gin.Context) {
+	claims, ok := currentClaims(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	result := h.db.Where("id = ? AND user_id = ?", c.Param("id"), claims.UserID).Delete(&models.UserIdentity{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlink identity"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Identity not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Identity unlinked"})
+}
+
+// startFlow stores the login state, sets the login cookie and returns the
+// provider's authorization URL. linkUserID is set when the flow links an
+// identity instead of signing in.
+func (h *OIDCHandler) startFlow(c *gin.Context, linkUserID *uint) {
+	provider, ok := h.providers[c.Param("provider")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown identity provider"})
+		return
+	}
+
+	state, errState := oidc.NewRandomString()
+	nonce, errNonce := oidc.NewRandomString()
+	verifier, errVerifier := oidc.NewRandomString()
+	if errState != nil || errNonce != nil || errVerifier != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+
+	authorizationURL, err := provider.AuthCodeURL(c.Request.Context(), state, nonce, verifier)
+	if err != nil {
+		log.Printf("OIDC provider %s unavailable: %v", provider.Name(), err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Identity provider unavailable"})
+		return
+	}
+
+	record := models.OIDCLoginState{
+		StateHash:  auth.HashToken(state),
+		Provider:   provider.Name(),
+		Nonce:      nonce,
+		LinkUserID: linkUserID,
+		ExpiresAt:  time.Now().Add(oidcLoginStateTTL),
+	}
+	if err := h.db.Create(&record).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+
+	setOIDCLoginCookie(c, state+"."+verifier, int(oidcLoginStateTTL/time.Second))
+	c.JSON(http.StatusOK, gin.H{
+		"authorizationUrl": authorizationURL,
+	})
+}
+
+// Callback completes the flow: it redeems the code, validates the ID token,
+// links or creates the local user and issues platform tokens. A flow started
+// with StartLink only links the identity to that user.
+func (h *OIDCHandler) Callback(c *gin.Context) {
+	provider, ok := h.providers[c.Param("provider")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown identity provider"})
+		return
+	}
+
+	var req OIDCCallbackRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The state must match the one this browser was given, and the cookie is
+	// spent whether or not the rest of the callback succeeds
+	cookie, err := c.Cookie(oidcLoginCookie)
+	setOIDCLoginCookie(c, "", -1)
+	state, verifier, found := strings.Cut(cookie, ".")
+	if err != nil || !found || subtle.ConstantTimeCompare([]byte(state), []byte(req.State)) != 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Sign-in was not started in this browser"})
+		return
+	}
+
+	// Each state can only be redeemed once
+	var loginState models.OIDCLoginState
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("state_hash = ? AND provider = ? AND expires_at > ?", auth.HashToken(req.State), provider.Name(), time.Now()).
+			First(&loginState).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&loginState).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired login state"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	rawIDToken, err := provider.Exchange(ctx, req.Code, verifier)
+	if err != nil {
+		log.Printf("OIDC code exchange with %s failed: %v", provider.Name(), err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Failed to complete sign-in with identity provider"})
+		return
+	}
+
+	claims, err := provider.VerifyIDToken(ctx, rawIDToken, loginState.Nonce)
+	if err != nil {
+		log.Printf("OIDC ID token from %s rejected: %v", provider.Name(), err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid ID token"})
+		return
+	}
+
+	if loginState.LinkUserID != nil {
+		identity, err := h.linkIdentity(provider.Name(), *loginState.LinkUserID, claims)
+		if errors.Is(err, errOIDCIdentityInUse) {
+			c.JSON(http.StatusConflict, gin.H{"error": "This identity is already linked to another account"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to link identity"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Identity linked", "identity": identity})
+		return
+	}
+
+	user, err := h.resolveUser(provider, claims)
+	switch {
+	case errors.Is(err, errOIDCEmailNotVerified):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Your identity provider has not verified your email address"})
+		return
+	case errors.Is(err, errOIDCLinkRequired):
+		c.JSON(http.StatusConflict, gin.H{
+			"error":         "An account with this email already exists. Sign in to it and link this identity from your account settings.",
+			"link_required": true,
+		})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign in"})
+		return
+	}
+
+	// The provider's MFA, reported in the amr claim, stands in for ours only
+	// for unprivileged users; admins still need their own second factor
+	h.auth.completeLogin(c, user, containsString(claims.AMR, "mfa") && !user.IsPrivileged())
+}
+
+// resolveUser finds the user linked to the provider subject. An unlinked
+// identity is linked to an existing user with the same verified email only if
+// the provider may auto-link the email's domain and the user is not
+// privileged; otherwise the owner has to link it while signed in. Without an
+// existing user, one is created just in time.
+func (h *OIDCHandler) resolveUser(provider *oidc.Provider, claims *oidc.IDTokenClaims) (*models.User, error) {
+	var user models.User
+	now := time.Now()
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		var identity models.UserIdentity
+		err := tx.Where("provider = ? AND subject = ?", provider.Name(), claims.Subject).First(&identity).Error
+		if err == nil {
+			if err := tx.Model(&identity).Update("last_login_at", now).Error; err != nil {
+				return err
+			}
+			return tx.First(&user, identity.UserID).Error
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		if claims.Email == "" || !claims.EmailVerified {
+			return errOIDCEmailNotVerified
+		}
+		email := strings.ToLower(claims.Email)
+
+		err = tx.Where("LOWER(email) = ?", email).First(&user).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			user, err = newOIDCUser(email, claims)
+			if err != nil {
+				return err
+			}
+			if err := tx.Create(&user).Error; err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		case !provider.AutoLinks(email) || user.IsPrivileged():
+			return errOIDCLinkRequired
+		}
+
+		// The provider vouches for the address, so it counts as verified here too
+		if !user.EmailVerified {
+			user.EmailVerified = true
+			user.EmailVerifiedAt = &now
+			if err := tx.Model(&user).Updates(map[string]interface{}{
+				"email_verified":    true,
+				"email_verified_at": now,
+			}).Error; err != nil {
+				return err
+			}
+		}
+
+		identity = models.UserIdentity{
+			UserID:      user.ID,
+			Provider:    provider.Name(),
+			Subject:     claims.Subject,
+			Email:       email,
+			LastLoginAt: &now,
+		}
+		return tx.Create(&identity).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// linkIdentity links the provider subject to a user who started the flow
+// while signed in. Linking an identity the user already has is a no-op.
+func (h *OIDCHandler) linkIdentity(provider string, userID uint, claims *oidc.IDTokenClaims) (*models.UserIdentity, error) {
+	var identity models.UserIdentity
+	err := h.db.Where("provider = ? AND subject = ?", provider, claims.Subject).First(&identity).Error
+	if err == nil {
+		if identity.UserID != userID {
+			return nil, errOIDCIdentityInUse
+		}
+		return &identity, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	identity = models.UserIdentity{
+		UserID:   userID,
+		Provider: provider,
+		Subject:  claims.Subject,
+		Email:    strings.ToLower(claims.Email),
+	}
+	if err := h.db.Create(&identity).Error; err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+func newOIDCUser(email string, claims *oidc.IDTokenClaims) (models.User, error) {
+	firstName, lastName := claims.GivenName, claims.FamilyName
+	if firstName == "" {
+		firstName, lastName, _ = strings.Cut(claims.Name, " ")
+	}
+	if firstName == "" {
+		firstName, _, _ = strings.Cut(email, "@")
+	}
+
+	now := time.Now()
+	user := models.User{
+		FirstName:       firstName,
+		LastName:        lastName,
+		Email:           email,
+		IsActive:        true,
+		EmailVerified:   true,
+		EmailVerifiedAt: &now,
+		Role:            models.RolePending, // Promoted once an access request is approved
+	}
+
+	// SSO users sign in through their provider; the local password is random
+	// and only becomes usable through a password reset
+	password, err := auth.NewOpaqueToken()
+	if err != nil {
+		return user, err
+	}
+	if err := user.SetPassword(password); err != nil {
+		return user, err
+	}
+
+	return user, nil
+}
+
+// setOIDCLoginCookie sets or, with a negative maxAge, clears the login
+// cookie. It is only sent back to the OIDC endpoints and never to scripts.
+func setOIDCLoginCookie(c *gin.Context, value string, maxAge int) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcLoginCookie, value, maxAge, oidcLoginCookiePath, "", strings.HasPrefix(getAppURL(), "https://"), true)
+}
+
+func containsString(values []string, target string) bool {
+	for _, v := range values {
+		if v == target {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/auth"
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/models"
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/oidc"
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/oidc/oidctest"
+)
+
+const testClientID = "addtocloud-test"
+
+// oidcTestEnv runs the OIDC handler against the mock provider and an
+// in-memory database
+type oidcTestEnv struct {
+	t       *testing.T
+	db      *gorm.DB
+	tokens  *auth.TokenService
+	idp     *oidctest.Server
+	router  *gin.Engine
+	session *auth.Claims // Claims the link routes are called with
+}
+
+func newOIDCTestEnv(t *testing.T, autoLinkDomains ...string) *oidcTestEnv {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	db := newTestDB(t, &models.User{}, &models.UserIdentity{}, &models.OIDCLoginState{}, &models.RefreshToken{}, &models.RevokedToken{}, &models.Session{})
+
+	t.Setenv("JWT_KEYS_DIR", "")
+	keys, err := auth.LoadKeySet()
+	if err != nil {
+		t.Fatalf("load keys: %v", err)
+	}
+	tokens := auth.NewTokenService(db, keys)
+
+	idp := oidctest.NewServer(testClientID)
+	t.Cleanup(idp.Close)
+
+	provider := oidc.NewProvider(oidc.Config{
+		Name:            "corp",
+		Issuer:          idp.Issuer(),
+		ClientID:        testClientID,
+		RedirectURL:     "https://app.example.com/sso/callback",
+		AutoLinkDomains: autoLinkDomains,
+	}, idp.Client())
+
+	env := &oidcTestEnv{t: t, db: db, tokens: tokens, idp: idp}
+	handler := NewOIDCHandler(db, NewAuthHandler(db, tokens, nil, nil), map[string]*oidc.Provider{"corp": provider})
+
+	env.router = gin.New()
+	env.router.GET("/api/v1/auth/oidc/:provider/login", handler.StartLogin)
+	env.router.POST("/api/v1/auth/oidc/:provider/callback", handler.Callback)
+	env.router.POST("/api/v1/user/identities/:provider/link", func(c *gin.Context) {
+		c.Set("claims", env.session)
+	}, handler.StartLink)
+	return env
+}
+
+// start begins a flow at path and returns the login cookie and the code and
+// state the provider redirects back with
+func (e *oidcTestEnv) start(method, path string) (*http.Cookie, string, string) {
+	e.t.Helper()
+
+	recorder := e.serve(httptest.NewRequest(method, path, nil))
+	if recorder.Code != http.StatusOK {
+		e.t.Fatalf("start: status %d: %s", recorder.Code, recorder.Body)
+	}
+	var body struct {
+		AuthorizationURL string `json:"authorizationUrl"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		e.t.Fatalf("start: decode: %v", err)
+	}
+
+	var cookie *http.Cookie
+	for _, c := range recorder.Result().Cookies() {
+		if c.Name == oidcLoginCookie {
+			cookie = c
+		}
+	}
+	if cookie == nil || !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode {
+		e.t.Fatalf("start: want an HttpOnly, SameSite=Lax login cookie, got %+v", cookie)
+	}
+
+	// The mock provider approves at once and redirects back
+	client := e.idp.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	resp, err := client.Get(body.AuthorizationURL)
+	if err != nil {
+		e.t.Fatalf("authorize: %v", err)
+	}
+	resp.Body.Close()
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.StatusCode != http.StatusFound {
+		e.t.Fatalf("authorize: status %d, location %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	return cookie, location.Query().Get("code"), location.Query().Get("state")
+}
+
+// callback posts the code and state back, with the login cookie if set
+func (e *oidcTestEnv) callback(cookie *http.Cookie, code, state string) *httptest.ResponseRecorder {
+	e.t.Helper()
+
+	payload, _ := json.Marshal(OIDCCallbackRequest{Code: code, State: state})
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/oidc/corp/callback", bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	return e.serve(req)
+}
+
+func (e *oidcTestEnv) login() *httptest.ResponseRecorder {
+	e.t.Helper()
+	cookie, code, state := e.start(http.MethodGet, "/api/v1/auth/oidc/corp/login")
+	return e.callback(cookie, code, state)
+}
+
+func (e *oidcTestEnv) serve(req *http.Request) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	e.router.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func (e *oidcTestEnv) createUser(email, role string) models.User {
+	e.t.Helper()
+	user := models.User{FirstName: "Local", LastName: "User", Email: email, Password: "x", IsActive: true, Role: role}
+	if err := e.db.Create(&user).Error; err != nil {
+		e.t.Fatalf("create user: %v", err)
+	}
+	return user
+}
+
+func decodeLogin(t *testing.T, recorder *httptest.ResponseRecorder) LoginResponse {
+	t.Helper()
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("callback: status %d: %s", recorder.Code, recorder.Body)
+	}
+	var response LoginResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("callback: decode: %v", err)
+	}
+	if response.TokenPair == nil || response.AccessToken == "" {
+		t.Fatalf("callback: no tokens in %s", recorder.Body)
+	}
+	return response
+}
+
+func TestOIDCLoginCreatesPendingUser(t *testing.T) {
+	env := newOIDCTestEnv(t)
+
+	response := decodeLogin(t, env.login())
+	if response.User.Email != "sso.user@example.com" || response.User.Role != models.RolePending {
+		t.Fatalf("got user %s with role %q, want a pending sso.user@example.com", response.User.Email, response.User.Role)
+	}
+
+	// Signing in again finds the same user through the linked identity
+	again := decodeLogin(t, env.login())
+	if again.User.ID != response.User.ID {
+		t.Fatalf("second login got user %d, want %d", again.User.ID, response.User.ID)
+	}
+}
+
+func TestOIDCCallbackRequiresLoginCookie(t *testing.T) {
+	env := newOIDCTestEnv(t)
+
+	_, code, state := env.start(http.MethodGet, "/api/v1/auth/oidc/corp/login")
+	if recorder := env.callback(nil, code, state); recorder.Code != http.StatusBadRequest {
+		t.Fatalf("without cookie: status %d, want 400", recorder.Code)
+	}
+
+	// A cookie from another login does not fit this state
+	otherCookie, _, _ := env.start(http.MethodGet, "/api/v1/auth/oidc/corp/login")
+	_, code, state = env.start(http.MethodGet, "/api/v1/auth/oidc/corp/login")
+	if recorder := env.callback(otherCookie, code, state); recorder.Code != http.StatusBadRequest {
+		t.Fatalf("with another login's cookie: status %d, want 400", recorder.Code)
+	}
+}
+
+func TestOIDCCallbackStateIsSingleUse(t *testing.T) {
+	env := newOIDCTestEnv(t)
+
+	cookie, code, state := env.start(http.MethodGet, "/api/v1/auth/oidc/corp/login")
+	decodeLogin(t, env.callback(cookie, code, state))
+	if recorder := env.callback(cookie, code, state); recorder.Code != http.StatusBadRequest {
+		t.Fatalf("replayed callback: status %d, want 400", recorder.Code)
+	}
+}
+
+func TestOIDCExistingAccountRequiresLink(t *testing.T) {
+	env := newOIDCTestEnv(t)
+	env.createUser("sso.user@example.com", models.RoleUser)
+
+	recorder := env.login()
+	if recorder.Code != http.StatusConflict {
+		t.Fatalf("status %d, want 409: %s", recorder.Code, recorder.Body)
+	}
+	var count int64
+	env.db.Model(&models.UserIdentity{}).Count(&count)
+	if count != 0 {
+		t.Fatalf("%d identities linked, want none", count)
+	}
+}
+
+func TestOIDCAutoLinkDomains(t *testing.T) {
+	env := newOIDCTestEnv(t, "example.com")
+	user := env.createUser("sso.user@example.com", models.RoleUser)
+	admin := env.createUser("sso.admin@example.com", models.RoleAdmin)
+
+	response := decodeLogin(t, env.login())
+	if response.User.ID != user.ID {
+		t.Fatalf("linked to user %d, want %d", response.User.ID, user.ID)
+	}
+
+	// Privileged accounts are never linked automatically
+	env.idp.SetUser(oidctest.User{Subject: "admin-subject", Email: admin.Email, EmailVerified: true, GivenName: "SSO"})
+	if recorder := env.login(); recorder.Code != http.StatusConflict {
+		t.Fatalf("admin: status %d, want 409: %s", recorder.Code, recorder.Body)
+	}
+}
+
+func TestOIDCLinkWhileSignedIn(t *testing.T) {
+	env := newOIDCTestEnv(t)
+	admin := env.createUser("admin@corp.example", models.RoleAdmin)
+	env.session = &auth.Claims{UserID: admin.ID}
+
+	cookie, code, state := env.start(http.MethodPost, "/api/v1/user/identities/corp/link")
+	if recorder := env.callback(cookie, code, state); recorder.Code != http.StatusOK {
+		t.Fatalf("link: status %d: %s", recorder.Code, recorder.Body)
+	}
+
+	// The linked identity now signs in as the admin, but the provider's MFA
+	// does not stand in for the admin's own
+	env.idp.SetUser(oidctest.User{Subject: "oidctest-user", Email: "sso.user@example.com", EmailVerified: true, AMR: []string{"pwd", "mfa"}})
+	response := decodeLogin(t, env.login())
+	if response.User.ID != admin.ID {
+		t.Fatalf("signed in as user %d, want %d", response.User.ID, admin.ID)
+	}
+	claims, err := env.tokens.ParseAccessToken(response.AccessToken)
+	if err != nil {
+		t.Fatalf("parse access token: %v", err)
+	}
+	if claims.MFA {
+		t.Fatal("admin session marked MFA-verified by the provider's amr claim")
+	}
+
+	// Another account cannot take over the identity
+	other := env.createUser("other@corp.example", models.RoleUser)
+	env.session = &auth.Claims{UserID: other.ID}
+	cookie, code, state = env.start(http.MethodPost, "/api/v1/user/identities/corp/link")
+	if recorder := env.callback(cookie, code, state); recorder.Code != http.StatusConflict {
+		t.Fatalf("link taken identity: status %d, want 409", recorder.Code)
+	}
+}
+
+func TestOIDCProviderMFATrustedForUsers(t *testing.T) {
+	env := newOIDCTestEnv(t)
+	env.idp.SetUser(oidctest.User{Subject: "mfa-user", Email: "mfa.user@example.com", EmailVerified: true, GivenName: "MFA", AMR: []string{"mfa"}})
+
+	response := decodeLogin(t, env.login())
+	claims, err := env.tokens.ParseAccessToken(response.AccessToken)
+	if err != nil {
+		t.Fatalf("parse access token: %v", err)
+	}
+	if !claims.MFA {
+		t.Fatal("unprivileged session not marked MFA-verified by the provider's amr claim")
+	}
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/models"
+)
+
+func TestListMembersReturnsOnlyMemberFields(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := newTestDB(t, &models.User{}, &models.Organization{}, &models.OrganizationMember{})
+
+	org := models.Organization{Name: "Acme"}
+	db.Create(&org)
+	owner := models.User{FirstName: "Ada", LastName: "Lovelace", Email: "ada@example.com", Phone: "555-0100", MFAEnabled: true}
+	member := models.User{FirstName: "Alan", LastName: "Turing", Email: "alan@example.com", Address: "Bletchley"}
+	db.Create(&owner)
+	db.Create(&member)
+	db.Create(&models.OrganizationMember{OrganizationID: org.ID, UserID: owner.ID, Role: models.OrgRoleOwner})
+	db.Create(&models.OrganizationMember{OrganizationID: org.ID, UserID: member.ID, Role: models.OrgRoleMember})
+
+	handler := NewOrganizationHandler(db, nil)
+	router := gin.New()
+	router.GET("/members", func(c *gin.Context) {
+		c.Set("organizationID", org.ID)
+		handler.ListMembers(c)
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/members", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+
+	var body struct {
+		Members []map[string]interface{} `json:"members"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Members) != 2 {
+		t.Fatalf("got %d members, want 2", len(body.Members))
+	}
+	want := map[string]interface{}{"id": float64(owner.ID), "name": "Ada Lovelace", "email": "ada@example.com", "role": models.OrgRoleOwner}
+	if len(body.Members[0]) != len(want) {
+		t.Fatalf("member has fields %v, want only %v", body.Members[0], want)
+	}
+	for key, value := range want {
+		if body.Members[0][key] != value {
+			t.Fatalf("%s = %v, want %v", key, body.Members[0][key], value)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/auth"
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/models"
+)
+
+const passwordResetTTL = time.Hour
+
+var errResetTokenInvalid = errors.New("invalid or expired reset token")
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,min=8"`
+}
+
+func getAppURL() string {
+	appURL := os.Getenv("APP_URL")
+	if appURL == "" {
+		appURL = "https://addtocloud.tech"
+	}
+	return strings.TrimRight(appURL, "/")
+}
+
+// ForgotPassword emails a single-use reset link. The response is the same
+// whether or not the email belongs to an account.
+func (h *AuthHandler) ForgotPassword(c *gin.Context) {
+	var req ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := gin.H{"message": "If an account exists for this email, a password reset link has been sent"}
+
+	var user models.User
+	if err := h.db.Where("email = ?", req.Email).First(&user).Error; err != nil || !user.IsActive {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	token, err := auth.NewOpaqueToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create reset token"})
+		return
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		// Only the most recent link stays valid
+		if err := tx.Model(&models.PasswordResetToken{}).
+			Where("user_id = ? AND used_at IS NULL", user.ID).
+			Update("used_at", time.Now()).Error; err != nil {
+			return err
+		}
+
+		record := models.PasswordResetToken{
+			UserID:    user.ID,
+			TokenHash: auth.HashToken(token),
+			ExpiresAt: time.Now().Add(passwordResetTTL),
+		}
+		return tx.Create(&record).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create reset token"})
+		return
+	}
+
+	resetLink := fmt.Sprintf("%s/reset-password?token=%s", getAppURL(), url.QueryEscape(token))
+	go func() {
+		if err := h.mailer.SendPasswordResetEmail(user.Email, user.FirstName, resetLink, passwordResetTTL); err != nil {
+			log.Printf("Failed to send password reset email to user %d: %v", user.ID, err)
+		}
+	}()
+
+	c.JSON(http.StatusOK, response)
+}
+
+// ResetPassword sets a new password using a reset token and signs the user
+// out of every existing session
+func (h *AuthHandler) ResetPassword(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		var record models.PasswordResetToken
+		if err := tx.Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", auth.HashToken(req.Token), time.Now()).
+			First(&record).Error; err != nil {
+			return errResetTokenInvalid
+		}
+
+		// Consume the token; a concurrent reset with the same token loses here
+		result := tx.Model(&models.PasswordResetToken{}).
+			Where("id = ? AND used_at IS NULL", record.ID).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errResetTokenInvalid
+		}
+
+		if err := tx.First(&user, record.UserID).Error; err != nil {
+			return errResetTokenInvalid
+		}
+		if err := user.SetPassword(req.Password); err != nil {
+			return err
+		}
+		updates := map[string]interface{}{"password": user.Password}
+		// The link only reached the user through their address, which also
+		// covers setup links sent to approved applicants
+		if !user.EmailVerified {
+			updates["email_verified"] = true
+			updates["email_verified_at"] = time.Now()
+		}
+		return tx.Model(&user).Updates(updates).Error
+	})
+	if err != nil {
+		if errors.Is(err, errResetTokenInvalid) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset token"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+		}
+		return
+	}
+
+	if err := h.tokens.RevokeUserTokens(user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Password updated but failed to revoke existing sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password has been reset. Please sign in again."})
+}
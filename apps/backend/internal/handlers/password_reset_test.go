@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/auth"
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/models"
+)
+
+func TestSetupLinkVerifiesApprovedApplicant(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := newApprovalTestDB(t)
+
+	account := approveFor(t, db, "new@example.com")
+	if account.SetupToken == "" {
+		t.Fatal("no setup link issued for a new account")
+	}
+
+	handler := NewAuthHandler(db, auth.NewTokenService(db, nil), nil, nil)
+	router := gin.New()
+	router.POST("/reset", handler.ResetPassword)
+
+	w := httptest.NewRecorder()
+	body := `{"token":"` + account.SetupToken + `","password":"a-new-password"}`
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/reset", strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+
+	var user models.User
+	db.Where("email = ?", "new@example.com").First(&user)
+	if !user.EmailVerified || user.EmailVerifiedAt == nil {
+		t.Fatal("address still unverified after the setup link sent to it was used")
+	}
+	if !user.CheckPassword("a-new-password") {
+		t.Fatal("password not set")
+	}
+}
@@ -0,0 +1,521 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/models"
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/services"
+	"github.com/gokulupadhyayguragain/addtocloud/backend/pkg/email"
+)
+
+// PrivacyHandler lets people download the personal data held about them and
+// have it erased. Users act on their account; applicants without an account
+// act on their access request with its tracking token.
+type PrivacyHandler struct {
+	db     *gorm.DB
+	mailer *email.SMTPConfig
+	cloud  *services.CloudService // Deletes erased users' instances; nil when cloud endpoints are disabled
+	config ErasureConfig
+}
+
+type RequestErasureRequest struct {
+	ConfirmEmail string `json:"confirmEmail" binding:"required"` // Must match the account email
+}
+
+func NewPrivacyHandler(db *gorm.DB, mailer *email.SMTPConfig, cloud *services.CloudService, config ErasureConfig) *PrivacyHandler {
+	return &PrivacyHandler{db: db, mailer: mailer, cloud: cloud, config: config}
+}
+
+// UserDataExport is everything held about a user
+type UserDataExport struct {
+	ExportedAt      time.Time                      `json:"exportedAt"`
+	Profile         models.User                    `json:"profile"`
+	Organizations   []ExportedMembership           `json:"organizations"`
+	AccessRequests  []ExportedAccessRequest        `json:"accessRequests"`
+	Instances       []services.Instance            `json:"instances"`
+	Sessions        []models.Session               `json:"sessions"`
+	APIKeys         []models.APIKey                `json:"apiKeys"`
+	Identities      []models.UserIdentity          `json:"identities"`
+	Approvals       []models.AccessRequestApproval `json:"approvals"` // Given as a reviewer
+	AuditLogs       []models.AuditLog              `json:"auditLogs"`
+	ErasureRequests []models.ErasureRequest        `json:"erasureRequests"`
+}
+
+type ExportedMembership struct {
+	OrganizationID uint      `json:"organizationId"`
+	Name           string    `json:"name"`
+	Role           string    `json:"role"`
+	JoinedAt       time.Time `json:"joinedAt"`
+}
+
+// ExportedAccessRequest is an access request with its history and messages
+type ExportedAccessRequest struct {
+	models.AccessRequest
+	History  []models.AccessRequestTransition `json:"history"`
+	Comments []models.AccessRequestComment    `json:"comments"`
+}
+
+// ExportMyData returns the caller's personal data as a JSON download
+func (h *PrivacyHandler) ExportMyData(c *gin.Context) {
+	user, ok := h.selfServiceUser(c)
+	if !ok {
+		return
+	}
+
+	export, err := h.exportUser(user)
+	if err != nil {
+		log.Printf("Failed to export data of user %d: %v", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export data"})
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="addtocloud-data-%d.json"`, user.ID))
+	c.JSON(http.StatusOK, export)
+}
+
+// GetMyErasure returns the caller's latest erasure request
+func (h *PrivacyHandler) GetMyErasure(c *gin.Context) {
+	user, ok := h.selfServiceUser(c)
+	if !ok {
+		return
+	}
+
+	var erasure models.ErasureRequest
+	if err := h.db.Where("user_id = ?", user.ID).Order("id DESC").First(&erasure).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No erasure requested"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"erasure": erasure})
+}
+
+// RequestMyErasure schedules the erasure of the caller's account and personal
+// data once the grace period ends. The account keeps working until then so
+// the user can change their mind.
+func (h *PrivacyHandler) RequestMyErasure(c *gin.Context) {
+	user, ok := h.selfServiceUser(c)
+	if !ok {
+		return
+	}
+
+	var req RequestErasureRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !strings.EqualFold(strings.TrimSpace(req.ConfirmEmail), user.Email) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Confirmation email does not match your account"})
+		return
+	}
+
+	h.scheduleUserErasure(c, user, nil)
+}
+
+// CancelMyErasure cancels the caller's erasure during the grace period
+func (h *PrivacyHandler) CancelMyErasure(c *gin.Context) {
+	user, ok := h.selfServiceUser(c)
+	if !ok {
+		return
+	}
+
+	var erasure models.ErasureRequest
+	if err := h.db.Where("user_id = ? AND status = ?", user.ID, models.ErasureScheduled).First(&erasure).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No erasure scheduled"})
+		return
+	}
+	h.cancel(c, &erasure, &user.ID)
+}
+
+// ExportRequesterData returns an applicant's access request with its history
+// and messages, for the holder of its tracking token
+func (h *PrivacyHandler) ExportRequesterData(c *gin.Context) {
+	accessReq, ok := findAccessRequestByTrackingToken(h.db, c)
+	if !ok {
+		return
+	}
+
+	exported, err := exportAccessRequest(h.db, *accessReq)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export data"})
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="addtocloud-access-request-%d.json"`, accessReq.ID))
+	c.JSON(http.StatusOK, gin.H{
+		"exportedAt":    time.Now().UTC(),
+		"accessRequest": exported,
+	})
+}
+
+// RequestRequesterErasure schedules the erasure of an applicant's access
+// request. An open request is withdrawn straight away. Requests that led to
+// an account are erased with the account instead.
+func (h *PrivacyHandler) RequestRequesterErasure(c *gin.Context) {
+	accessReq, ok := findAccessRequestByTrackingToken(h.db, c)
+	if !ok {
+		return
+	}
+	if accessReq.UserID != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "This request belongs to an account. Sign in and request erasure from your account settings."})
+		return
+	}
+
+	erasure := models.ErasureRequest{AccessRequestID: &accessReq.ID, Email: accessReq.Email}
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(accessReq, accessReq.ID).Error; err != nil {
+			return err
+		}
+		if accessReq.IsOpen() {
+			if err := transitionAccessRequest(tx, accessReq, models.AccessRequestWithdrawn, requesterActor(accessReq), "Withdrawn because the requester asked for their data to be erased"); err != nil {
+				return err
+			}
+		}
+		if err := scheduleErasure(tx, &erasure, h.config.GracePeriod); err != nil {
+			return err
+		}
+		return recordErasureAudit(tx, c, models.AuditErasureScheduled, &erasure, nil)
+	})
+	if errors.Is(err, errErasureScheduled) {
+		c.JSON(http.StatusConflict, gin.H{"error": "An erasure is already scheduled for this request"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to schedule erasure"})
+		return
+	}
+
+	h.sendErasureScheduled(accessReq.Email, accessReq.FirstName, &erasure)
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "Erasure scheduled. You can cancel it until " + erasure.ScheduledFor.UTC().Format(time.RFC1123),
+		"erasure": erasure,
+	})
+}
+
+// CancelRequesterErasure cancels an applicant's erasure during the grace
+// period. A withdrawn request stays withdrawn.
+func (h *PrivacyHandler) CancelRequesterErasure(c *gin.Context) {
+	accessReq, ok := findAccessRequestByTrackingToken(h.db, c)
+	if !ok {
+		return
+	}
+
+	var erasure models.ErasureRequest
+	if err := h.db.Where("access_request_id = ? AND status = ?", accessReq.ID, models.ErasureScheduled).First(&erasure).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No erasure scheduled"})
+		return
+	}
+	h.cancel(c, &erasure, nil)
+}
+
+// ListErasures returns erasure requests, newest first, optionally filtered
+// by status
+func (h *PrivacyHandler) ListErasures(c *gin.Context) {
+	query := h.db.Order("id DESC").Limit(200)
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var erasures []models.ErasureRequest
+	if err := query.Find(&erasures).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch erasure requests"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"erasures": erasures, "total": len(erasures)})
+}
+
+// ScheduleUserErasure schedules the erasure of a user on their behalf, for
+// requests that reach the platform by other channels
+func (h *PrivacyHandler) ScheduleUserErasure(c *gin.Context) {
+	claims, ok := currentClaims(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.ID == claims.UserID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Use your own account settings to erase your account"})
+		return
+	}
+
+	h.scheduleUserErasure(c, &user, &claims.UserID)
+}
+
+// CancelErasure cancels any scheduled erasure
+func (h *PrivacyHandler) CancelErasure(c *gin.Context) {
+	claims, ok := currentClaims(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var erasure models.ErasureRequest
+	if err := h.db.First(&erasure, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Erasure request not found"})
+		return
+	}
+	h.cancel(c, &erasure, &claims.UserID)
+}
+
+// selfServiceUser loads the caller's account. Impersonating admins are
+// refused: exporting or erasing is for the data subject alone.
+func (h *PrivacyHandler) selfServiceUser(c *gin.Context) (*models.User, bool) {
+	claims, ok := currentClaims(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return nil, false
+	}
+	if claims.IsImpersonation() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not available while impersonating"})
+		return nil, false
+	}
+
+	var user models.User
+	if err := h.db.First(&user, claims.UserID).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return nil, false
+	}
+	return &user, true
+}
+
+// scheduleUserErasure schedules a user's erasure, requested by the user
+// themselves when requestedByID is nil
+func (h *PrivacyHandler) scheduleUserErasure(c *gin.Context, user *models.User, requestedByID *uint) {
+	erasure := models.ErasureRequest{UserID: &user.ID, Email: user.Email, RequestedByID: requestedByID}
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(user, user.ID).Error; err != nil {
+			return err
+		}
+		if err := ensureAnotherAdmin(tx, user); err != nil {
+			return err
+		}
+		if err := ensureOtherOwners(tx, user.ID); err != nil {
+			return err
+		}
+		if err := scheduleErasure(tx, &erasure, h.config.GracePeriod); err != nil {
+			return err
+		}
+		return recordErasureAudit(tx, c, models.AuditErasureScheduled, &erasure, requestedByID)
+	})
+	switch {
+	case errors.Is(err, errErasureScheduled):
+		c.JSON(http.StatusConflict, gin.H{"error": "An erasure is already scheduled for this account"})
+		return
+	case errors.Is(err, errLastAdmin):
+		c.JSON(http.StatusConflict, gin.H{"error": "The last administrator cannot be erased. Make another user an administrator first."})
+		return
+	case errors.Is(err, errSoleOwner):
+		c.JSON(http.StatusConflict, gin.H{"error": "The only owner of an organization with other members cannot be erased. Make another member an owner first."})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to schedule erasure"})
+		return
+	}
+
+	h.sendErasureScheduled(user.Email, user.FirstName, &erasure)
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "Erasure scheduled. It can be cancelled until " + erasure.ScheduledFor.UTC().Format(time.RFC1123),
+		"erasure": erasure,
+	})
+}
+
+var errLastAdmin = errors.New("last active administrator")
+
+// ensureAnotherAdmin refuses to erase the only active administrator, which
+// would leave nobody able to manage the platform
+func ensureAnotherAdmin(tx *gorm.DB, user *models.User) error {
+	if user.Role != models.RoleAdmin {
+		return nil
+	}
+	var others int64
+	if err := tx.Model(&models.User{}).
+		Where("role = ? AND is_active = ? AND id <> ?", models.RoleAdmin, true, user.ID).
+		Count(&others).Error; err != nil {
+		return err
+	}
+	if others == 0 {
+		return errLastAdmin
+	}
+	return nil
+}
+
+var errSoleOwner = errors.New("only owner of an organization with other members")
+
+// ensureOtherOwners refuses to erase the only owner of an organization that
+// has other members, which would leave nobody able to make a new owner. The
+// members are locked so two owners cannot both be erased at the same time.
+func ensureOtherOwners(tx *gorm.DB, userID uint) error {
+	var owned []uint
+	if err := tx.Model(&models.OrganizationMember{}).
+		Where("user_id = ? AND role = ?", userID, models.OrgRoleOwner).
+		Pluck("organization_id", &owned).Error; err != nil {
+		return err
+	}
+
+	for _, orgID := range owned {
+		var others []models.OrganizationMember
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("organization_id = ? AND user_id <> ?", orgID, userID).
+			Find(&others).Error; err != nil {
+			return err
+		}
+		if len(others) == 0 {
+			continue
+		}
+		otherOwner := false
+		for _, member := range others {
+			if member.Role == models.OrgRoleOwner {
+				otherOwner = true
+				break
+			}
+		}
+		if !otherOwner {
+			return fmt.Errorf("%w: organization %d", errSoleOwner, orgID)
+		}
+	}
+	return nil
+}
+
+// cancel cancels a scheduled erasure and records who did it
+func (h *PrivacyHandler) cancel(c *gin.Context, erasure *models.ErasureRequest, actorID *uint) {
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := cancelErasure(tx, erasure); err != nil {
+			return err
+		}
+		return recordErasureAudit(tx, c, models.AuditErasureCancelled, erasure, actorID)
+	})
+	if errors.Is(err, errErasureNotScheduled) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Erasure is no longer scheduled"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel erasure"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Erasure cancelled", "erasure": erasure})
+}
+
+// recordErasureAudit writes an audit entry for an erasure. actorID defaults
+// to the user being erased.
+func recordErasureAudit(tx *gorm.DB, c *gin.Context, action string, erasure *models.ErasureRequest, actorID *uint) error {
+	if actorID == nil {
+		actorID = erasure.UserID
+	}
+	entry := models.AuditLog{
+		UserID:       actorID,
+		Action:       action,
+		ResourceType: "erasure_request",
+		ResourceID:   fmt.Sprint(erasure.ID),
+		Method:       c.Request.Method,
+		Path:         c.Request.URL.Path,
+		StatusCode:   http.StatusOK,
+	}
+	// Applicants have no user ID the erasure could later find this entry by,
+	// so their address is not kept
+	if actorID != nil {
+		entry.IPAddress = c.ClientIP()
+		entry.UserAgent = c.Request.UserAgent()
+	}
+	return tx.Create(&entry).Error
+}
+
+func (h *PrivacyHandler) sendErasureScheduled(address, name string, erasure *models.ErasureRequest) {
+	scheduledFor := erasure.ScheduledFor
+	go func() {
+		if err := h.mailer.SendErasureScheduled(address, name, scheduledFor); err != nil {
+			log.Printf("Failed to send erasure notice for erasure %d: %v", erasure.ID, err)
+		}
+	}()
+}
+
+// exportUser collects everything held about a user. Audit entries of admins
+// impersonating the user are included without the admin's IP address and
+// user agent.
+func (h *PrivacyHandler) exportUser(user *models.User) (*UserDataExport, error) {
+	export := &UserDataExport{ExportedAt: time.Now().UTC(), Profile: *user}
+
+	var memberships []models.OrganizationMember
+	if err := h.db.Where("user_id = ?", user.ID).Order("id").Find(&memberships).Error; err != nil {
+		return nil, err
+	}
+	export.Organizations = make([]ExportedMembership, 0, len(memberships))
+	for _, membership := range memberships {
+		var org models.Organization
+		if err := h.db.First(&org, membership.OrganizationID).Error; err != nil {
+			return nil, err
+		}
+		export.Organizations = append(export.Organizations, ExportedMembership{
+			OrganizationID: org.ID,
+			Name:           org.Name,
+			Role:           membership.Role,
+			JoinedAt:       membership.CreatedAt,
+		})
+	}
+
+	// Soft-deleted requests are still held, so they are included
+	var requests []models.AccessRequest
+	query := h.db.Unscoped().Where("user_id = ?", user.ID)
+	if user.EmailVerified {
+		query = query.Or("LOWER(email) = ?", strings.ToLower(user.Email))
+	}
+	if err := query.Order("id").Find(&requests).Error; err != nil {
+		return nil, err
+	}
+	export.AccessRequests = make([]ExportedAccessRequest, 0, len(requests))
+	for _, req := range requests {
+		exported, err := exportAccessRequest(h.db, req)
+		if err != nil {
+			return nil, err
+		}
+		export.AccessRequests = append(export.AccessRequests, exported)
+	}
+
+	lists := []struct {
+		dest  interface{}
+		query *gorm.DB
+	}{
+		{&export.Instances, h.db.Table("instances").Where("user_id = ?", fmt.Sprint(user.ID))},
+		{&export.Sessions, h.db.Where("user_id = ?", user.ID)},
+		{&export.APIKeys, h.db.Where("user_id = ?", user.ID)},
+		{&export.Identities, h.db.Where("user_id = ?", user.ID)},
+		{&export.Approvals, h.db.Where("reviewer_id = ?", user.ID)},
+		{&export.AuditLogs, h.db.Where("user_id = ? OR impersonated_user_id = ?", user.ID, user.ID)},
+		{&export.ErasureRequests, h.db.Where("user_id = ?", user.ID)},
+	}
+	for _, list := range lists {
+		if err := list.query.Order("id").Find(list.dest).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range export.AuditLogs {
+		entry := &export.AuditLogs[i]
+		if entry.UserID == nil || *entry.UserID != user.ID {
+			entry.IPAddress = ""
+			entry.UserAgent = ""
+		}
+	}
+	return export, nil
+}
+
+func exportAccessRequest(db *gorm.DB, req models.AccessRequest) (ExportedAccessRequest, error) {
+	history, comments, err := loadAccessRequestThread(db, req.ID)
+	if err != nil {
+		return ExportedAccessRequest{}, err
+	}
+	return ExportedAccessRequest{AccessRequest: req, History: history, Comments: comments}, nil
+}
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/models"
+)
+
+func TestEnsureOtherOwners(t *testing.T) {
+	db := newTestDB(t, &models.OrganizationMember{})
+	const erased = 1
+	members := []models.OrganizationMember{
+		// Alone in their personal organization
+		{OrganizationID: 10, UserID: erased, Role: models.OrgRoleOwner},
+		// Shares ownership
+		{OrganizationID: 20, UserID: erased, Role: models.OrgRoleOwner},
+		{OrganizationID: 20, UserID: 2, Role: models.OrgRoleOwner},
+		// A plain member elsewhere
+		{OrganizationID: 30, UserID: erased, Role: models.OrgRoleMember},
+		{OrganizationID: 30, UserID: 3, Role: models.OrgRoleOwner},
+	}
+	if err := db.Create(&members).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := ensureOtherOwners(db, erased); err != nil {
+		t.Fatalf("erasure refused although every organization keeps an owner: %v", err)
+	}
+
+	// The only owner of an organization with other members
+	db.Create(&models.OrganizationMember{OrganizationID: 40, UserID: erased, Role: models.OrgRoleOwner})
+	db.Create(&models.OrganizationMember{OrganizationID: 40, UserID: 4, Role: models.OrgRoleAdmin})
+	if err := ensureOtherOwners(db, erased); !errors.Is(err, errSoleOwner) {
+		t.Fatalf("got %v, want errSoleOwner", err)
+	}
+}
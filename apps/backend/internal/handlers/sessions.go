@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/auth"
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/models"
+)
+
+// SessionResponse is a session as shown to its owner or an admin
+type SessionResponse struct {
+	models.Session
+	Current bool `json:"current"` // Set on the session the request was made with
+}
+
+// clientInfo describes the device making the request
+func clientInfo(c *gin.Context) auth.ClientInfo {
+	return auth.ClientInfo{IPAddress: c.ClientIP(), UserAgent: c.Request.UserAgent()}
+}
+
+func sessionResponses(sessions []models.Session, currentSessionID string) []SessionResponse {
+	responses := make([]SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		responses = append(responses, SessionResponse{
+			Session: session,
+			Current: currentSessionID != "" && session.FamilyID == currentSessionID,
+		})
+	}
+	return responses
+}
+
+// ListSessions returns the caller's active sessions
+func (h *AuthHandler) ListSessions(c *gin.Context) {
+	claims, ok := currentClaims(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	sessions, err := h.tokens.ListSessions(claims.UserID, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"sessions": sessionResponses(sessions, claims.SessionID),
+		"total":    len(sessions),
+	})
+}
+
+// RevokeSession signs the caller out of one of their sessions
+func (h *AuthHandler) RevokeSession(c *gin.Context) {
+	claims, ok := currentClaims(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	sessionID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	if err := h.tokens.RevokeUserSession(claims.UserID, uint(sessionID)); err != nil {
+		if errors.Is(err, auth.ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked"})
+}
+
+// RevokeAllSessions signs the caller out everywhere. With keepCurrent=true
+// the session making the request stays signed in.
+func (h *AuthHandler) RevokeAllSessions(c *gin.Context) {
+	claims, ok := currentClaims(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	keep := ""
+	if c.Query("keepCurrent") == "true" {
+		keep = claims.SessionID
+	}
+
+	if err := h.tokens.RevokeOtherSessions(claims.UserID, keep); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Sessions revoked"})
+}
+
+// ListUserSessions returns a user's sessions for admins. Pass all=true to
+// include ended sessions.
+func (h *AdminHandler) ListUserSessions(c *gin.Context) {
+	user, ok := h.findUser(c)
+	if !ok {
+		return
+	}
+
+	sessions, err := h.tokens.ListSessions(user.ID, c.Query("all") == "true")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"sessions": sessionResponses(sessions, ""),
+		"total":    len(sessions),
+	})
+}
+
+// RevokeUserSession ends one of a user's sessions
+func (h *AdminHandler) RevokeUserSession(c *gin.Context) {
+	user, ok := h.findUser(c)
+	if !ok {
+		return
+	}
+
+	sessionID, err := strconv.ParseUint(c.Param("sessionId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	if err := h.tokens.RevokeUserSession(user.ID, uint(sessionID)); err != nil {
+		if errors.Is(err, auth.ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+		}
+		return
+	}
+
+	log.Printf("Admin %v revoked session %d of user %d", c.MustGet("userID"), sessionID, user.ID)
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked"})
+}
+
+// RevokeUserSessions ends every session of a user
+func (h *AdminHandler) RevokeUserSessions(c *gin.Context) {
+	user, ok := h.findUser(c)
+	if !ok {
+		return
+	}
+
+	if err := h.tokens.RevokeUserTokens(user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+		return
+	}
+
+	log.Printf("Admin %v revoked all sessions of user %d", c.MustGet("userID"), user.ID)
+	c.JSON(http.StatusOK, gin.H{"message": "Sessions revoked"})
+}
+
+// findUser loads the user named by the :id parameter, writing a 404 if there
+// is none
+func (h *AdminHandler) findUser(c *gin.Context) (*models.User, bool) {
+	var user models.User
+	if err := h.db.First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return nil, false
+	}
+	return &user, true
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/models"
+)
+
+type LinkTrustedIdentityRequest struct {
+	Issuer  string `json:"issuer" binding:"required"`
+	Subject string `json:"subject" binding:"required"`
+}
+
+// LinkTrustedIdentity lets access tokens a trusted issuer signs for subject
+// act as the user. Tokens from a trusted issuer are only accepted for
+// subjects linked this way (admin only).
+func (h *AdminHandler) LinkTrustedIdentity(c *gin.Context) {
+	var req LinkTrustedIdentityRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !h.tokens.TrustsIssuer(req.Issuer) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Issuer is not trusted", "issuer": req.Issuer})
+		return
+	}
+
+	user, ok := h.findUser(c)
+	if !ok {
+		return
+	}
+
+	var existing models.UserIdentity
+	err := h.db.Where("provider = ? AND subject = ?", req.Issuer, req.Subject).First(&existing).Error
+	if err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Subject is already linked to a user", "userId": existing.UserID})
+		return
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to link identity"})
+		return
+	}
+
+	identity := models.UserIdentity{UserID: user.ID, Provider: req.Issuer, Subject: req.Subject}
+	if err := h.db.Create(&identity).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to link identity"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"identity": identity})
+}
+
+// UnlinkTrustedIdentity removes a trusted issuer link from the user, so the
+// issuer's tokens for that subject stop being accepted (admin only)
+func (h *AdminHandler) UnlinkTrustedIdentity(c *gin.Context) {
+	user, ok := h.findUser(c)
+	if !ok {
+		return
+	}
+
+	var identity models.UserIdentity
+	if err := h.db.Where("id = ? AND user_id = ?", c.Param("identityId"), user.ID).First(&identity).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Identity not found"})
+		return
+	}
+	if !h.tokens.TrustsIssuer(identity.Provider) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Identity is not linked to a trusted issuer"})
+		return
+	}
+
+	if err := h.db.Delete(&identity).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlink identity"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Identity unlinked"})
+}
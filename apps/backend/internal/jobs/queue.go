@@ -0,0 +1,471 @@
+// Package jobs is a durable job queue kept in Postgres. Workers claim jobs
+// with SELECT ... FOR UPDATE SKIP LOCKED, so any number of them, in any
+// number of replicas, share the queue without handing out a job twice. Failed
+// jobs are retried with exponential backoff until they run out of attempts
+// and are dead-lettered.
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Job statuses
+const (
+	StatusPending   = "pending" // Waiting for run_at, including between retries
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusDead      = "dead" // Out of attempts or failed permanently
+	StatusCancelled = "cancelled"
+)
+
+var (
+	ErrJobNotFound = errors.New("job not found")
+	// ErrJobState is returned when a job cannot be retried or cancelled in
+	// its current status
+	ErrJobState = errors.New("job cannot be changed in its current status")
+)
+
+// Job is one unit of background work
+type Job struct {
+	ID          int64           `json:"id"`
+	Kind        string          `json:"kind"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"maxAttempts"`
+	RunAt       time.Time       `json:"runAt"` // Earliest time the next attempt may start
+	LastError   string          `json:"lastError"`
+	LockedUntil *time.Time      `json:"lockedUntil"` // While running; another worker reclaims the job after it
+	CreatedAt   time.Time       `json:"createdAt"`
+	UpdatedAt   time.Time       `json:"updatedAt"`
+	FinishedAt  *time.Time      `json:"finishedAt"`
+}
+
+// Decode unmarshals the job's payload into v
+func (j *Job) Decode(v interface{}) error {
+	return json.Unmarshal(j.Payload, v)
+}
+
+// Kind is how the queue runs one kind of job
+type Kind struct {
+	// Handle does the work. Returning an error schedules a retry unless the
+	// error is wrapped with Permanent. A job may be run again after a crash,
+	// so Handle must be safe to repeat.
+	Handle func(ctx context.Context, job *Job) error
+	// DeadLettered, if set, is called once the job has given up
+	DeadLettered func(ctx context.Context, job *Job)
+	// Cancelled, if set, runs in the transaction that cancels a pending
+	// job, to settle what was waiting for it the way DeadLettered does
+	Cancelled func(ctx context.Context, tx *sql.Tx, job *Job) error
+	// Requeued, if set, runs in the transaction that puts a dead or
+	// cancelled job back in the queue, to undo what DeadLettered or
+	// Cancelled did
+	Requeued func(ctx context.Context, tx *sql.Tx, job *Job) error
+}
+
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks an error that retrying cannot fix. The job is
+// dead-lettered straight away.
+func Permanent(err error) error {
+	return permanentError{err}
+}
+
+// Config tunes the queue
+type Config struct {
+	Workers     int           // Jobs run at the same time by this process
+	PollEvery   time.Duration // How often idle workers look for due jobs
+	Timeout     time.Duration // How long one attempt may run
+	MaxAttempts int           // Attempts a job gets before it is dead-lettered
+	BackoffBase time.Duration // Delay before the first retry, doubled for each further one
+	BackoffMax  time.Duration
+}
+
+// ConfigFromEnv reads JOB_WORKERS and JOB_MAX_ATTEMPTS over the defaults
+func ConfigFromEnv() Config {
+	config := Config{
+		Workers:     4,
+		PollEvery:   2 * time.Second,
+		Timeout:     10 * time.Minute,
+		MaxAttempts: 5,
+		BackoffBase: 15 * time.Second,
+		BackoffMax:  30 * time.Minute,
+	}
+	for key, target := range map[string]*int{
+		"JOB_WORKERS":      &config.Workers,
+		"JOB_MAX_ATTEMPTS": &config.MaxAttempts,
+	} {
+		if value := os.Getenv(key); value != "" {
+			if n, err := strconv.Atoi(value); err == nil && n > 0 {
+				*target = n
+			} else {
+				log.Printf("Warning: ignoring invalid %s %q", key, value)
+			}
+		}
+	}
+	return config
+}
+
+// Querier runs a query on a *sql.DB or inside a *sql.Tx
+type Querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// Queue stores jobs in the jobs table and runs them
+type Queue struct {
+	db     *sql.DB
+	config Config
+
+	mu    sync.RWMutex
+	kinds map[string]Kind
+}
+
+func NewQueue(db *sql.DB, config Config) *Queue {
+	return &Queue{db: db, config: config, kinds: make(map[string]Kind)}
+}
+
+// Migrate creates the jobs table
+func (q *Queue) Migrate() error {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS jobs (
+			id BIGSERIAL PRIMARY KEY,
+			kind VARCHAR(100) NOT NULL,
+			payload JSONB NOT NULL DEFAULT '{}',
+			status VARCHAR(20) NOT NULL DEFAULT 'pending',
+			attempts INTEGER NOT NULL DEFAULT 0,
+			max_attempts INTEGER NOT NULL,
+			run_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			last_error TEXT NOT NULL DEFAULT '',
+			locked_until TIMESTAMP WITH TIME ZONE,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			finished_at TIMESTAMP WITH TIME ZONE
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_jobs_due ON jobs(run_at, id) WHERE status = 'pending'`,
+		`CREATE INDEX IF NOT EXISTS idx_jobs_lease ON jobs(locked_until) WHERE status = 'running'`,
+		`CREATE INDEX IF NOT EXISTS idx_jobs_status_kind ON jobs(status, kind)`,
+	}
+	for _, statement := range statements {
+		if _, err := q.db.Exec(statement); err != nil {
+			return fmt.Errorf("failed to migrate jobs: %w", err)
+		}
+	}
+	return nil
+}
+
+// Register sets how jobs of kind are run. Register every kind before Run.
+func (q *Queue) Register(kind string, k Kind) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.kinds[kind] = k
+}
+
+func (q *Queue) kind(name string) (Kind, bool) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	k, ok := q.kinds[name]
+	return k, ok
+}
+
+const jobColumns = `id, kind, payload, status, attempts, max_attempts, run_at, last_error, locked_until, created_at, updated_at, finished_at`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanJob(row scanner) (*Job, error) {
+	job := &Job{}
+	var payload []byte
+	err := row.Scan(&job.ID, &job.Kind, &payload, &job.Status, &job.Attempts, &job.MaxAttempts,
+		&job.RunAt, &job.LastError, &job.LockedUntil, &job.CreatedAt, &job.UpdatedAt, &job.FinishedAt)
+	if err != nil {
+		return nil, err
+	}
+	job.Payload = payload
+	return job, nil
+}
+
+// Enqueue adds a job that runs as soon as a worker is free. Pass a *sql.Tx
+// to enqueue together with the change that needs the job.
+func (q *Queue) Enqueue(ctx context.Context, db Querier, kind string, payload interface{}) (*Job, error) {
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s job: %w", kind, err)
+	}
+	row := db.QueryRowContext(ctx,
+		`INSERT INTO jobs (kind, payload, max_attempts) VALUES ($1, $2, $3) RETURNING `+jobColumns,
+		kind, string(encoded), q.config.MaxAttempts)
+	job, err := scanJob(row)
+	if err != nil {
+		return nil, fmt.Errorf("failed to enqueue %s job: %w", kind, err)
+	}
+	return job, nil
+}
+
+// ListFilter narrows List. Zero values match everything.
+type ListFilter struct {
+	Status   string
+	Kind     string
+	BeforeID int64
+	Limit    int
+}
+
+// List returns jobs, newest first
+func (q *Queue) List(ctx context.Context, filter ListFilter) ([]*Job, error) {
+	conditions := []string{"TRUE"}
+	var args []interface{}
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if filter.Status != "" {
+		add("status = $%d", filter.Status)
+	}
+	if filter.Kind != "" {
+		add("kind = $%d", filter.Kind)
+	}
+	if filter.BeforeID > 0 {
+		add("id < $%d", filter.BeforeID)
+	}
+	args = append(args, filter.Limit)
+
+	rows, err := q.db.QueryContext(ctx, fmt.Sprintf(`SELECT %s FROM jobs WHERE %s ORDER BY id DESC LIMIT $%d`,
+		jobColumns, strings.Join(conditions, " AND "), len(args)), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list jobs: %w", err)
+	}
+	defer rows.Close()
+
+	jobs := []*Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan job: %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// Get returns one job
+func (q *Queue) Get(ctx context.Context, id int64) (*Job, error) {
+	job, err := scanJob(q.db.QueryRowContext(ctx, `SELECT `+jobColumns+` FROM jobs WHERE id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrJobNotFound
+	}
+	return job, err
+}
+
+// Retry puts a dead or cancelled job back in the queue with fresh attempts
+func (q *Queue) Retry(ctx context.Context, id int64) (*Job, error) {
+	tx, err := q.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	job, err := scanJob(tx.QueryRowContext(ctx, `
+		UPDATE jobs SET status = $1, attempts = 0, run_at = NOW(), locked_until = NULL, finished_at = NULL, updated_at = NOW()
+		WHERE id = $2 AND status IN ($3, $4)
+		RETURNING `+jobColumns,
+		StatusPending, id, StatusDead, StatusCancelled))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, q.missingOrState(ctx, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if k, ok := q.kind(job.Kind); ok && k.Requeued != nil {
+		if err := k.Requeued(ctx, tx, job); err != nil {
+			return nil, err
+		}
+	}
+	return job, tx.Commit()
+}
+
+// Cancel stops a pending job from running. Running jobs cannot be cancelled.
+func (q *Queue) Cancel(ctx context.Context, id int64) (*Job, error) {
+	tx, err := q.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	job, err := scanJob(tx.QueryRowContext(ctx, `
+		UPDATE jobs SET status = $1, finished_at = NOW(), updated_at = NOW()
+		WHERE id = $2 AND status = $3
+		RETURNING `+jobColumns,
+		StatusCancelled, id, StatusPending))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, q.missingOrState(ctx, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if k, ok := q.kind(job.Kind); ok && k.Cancelled != nil {
+		if err := k.Cancelled(ctx, tx, job); err != nil {
+			return nil, err
+		}
+	}
+	return job, tx.Commit()
+}
+
+func (q *Queue) missingOrState(ctx context.Context, id int64) error {
+	if _, err := q.Get(ctx, id); err != nil {
+		return err
+	}
+	return ErrJobState
+}
+
+// Run starts the workers and blocks until ctx is cancelled and they finish
+// their current jobs
+func (q *Queue) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < q.config.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (q *Queue) work(ctx context.Context) {
+	for {
+		job, err := q.claim(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Failed to claim job: %v", err)
+		}
+		if job != nil {
+			q.execute(ctx, job)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(q.config.PollEvery):
+		}
+	}
+}
+
+// claim takes the next due job, or a running one whose lease ran out because
+// the process running it died
+func (q *Queue) claim(ctx context.Context) (*Job, error) {
+	lease := q.config.Timeout + time.Minute
+	job, err := scanJob(q.db.QueryRowContext(ctx, `
+		UPDATE jobs SET status = $1, attempts = attempts + 1, locked_until = NOW() + $2::float8 * INTERVAL '1 second', updated_at = NOW()
+		WHERE id = (
+			SELECT id FROM jobs
+			WHERE (status = $3 AND run_at <= NOW()) OR (status = $1 AND locked_until < NOW())
+			ORDER BY run_at, id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+jobColumns,
+		StatusRunning, int64(lease/time.Second), StatusPending))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return job, err
+}
+
+// execute runs a claimed job and records the outcome. Updates only apply
+// while the job is still this attempt, so a job reclaimed after its lease
+// ran out is not overwritten by the worker that lost it.
+func (q *Queue) execute(ctx context.Context, job *Job) {
+	k, ok := q.kind(job.Kind)
+	var err error
+	switch {
+	case !ok:
+		err = Permanent(fmt.Errorf("no handler registered for job kind %q", job.Kind))
+	case job.Attempts > job.MaxAttempts:
+		// Reclaimed after its last attempt never reported back
+		err = Permanent(errors.New("worker stopped during the last attempt"))
+	default:
+		err = q.handle(ctx, k, job)
+	}
+
+	if err == nil {
+		if _, err := q.db.Exec(`
+			UPDATE jobs SET status = $1, locked_until = NULL, finished_at = NOW(), updated_at = NOW()
+			WHERE id = $2 AND status = $3 AND attempts = $4`,
+			StatusSucceeded, job.ID, StatusRunning, job.Attempts); err != nil {
+			log.Printf("Failed to complete job %d: %v", job.ID, err)
+		}
+		return
+	}
+
+	var permanent permanentError
+	if errors.As(err, &permanent) || job.Attempts >= job.MaxAttempts {
+		result, updateErr := q.db.Exec(`
+			UPDATE jobs SET status = $1, last_error = $2, locked_until = NULL, finished_at = NOW(), updated_at = NOW()
+			WHERE id = $3 AND status = $4 AND attempts = $5`,
+			StatusDead, err.Error(), job.ID, StatusRunning, job.Attempts)
+		if updateErr != nil {
+			log.Printf("Failed to dead-letter job %d: %v", job.ID, updateErr)
+			return
+		}
+		log.Printf("Job %d (%s) dead-lettered after %d attempts: %v", job.ID, job.Kind, job.Attempts, err)
+		if rows, _ := result.RowsAffected(); rows == 1 && ok && k.DeadLettered != nil {
+			job.Status = StatusDead
+			job.LastError = err.Error()
+			k.DeadLettered(context.Background(), job)
+		}
+		return
+	}
+
+	delay := q.backoff(job.Attempts)
+	if _, updateErr := q.db.Exec(`
+		UPDATE jobs SET status = $1, last_error = $2, run_at = NOW() + $3::float8 * INTERVAL '1 millisecond', locked_until = NULL, updated_at = NOW()
+		WHERE id = $4 AND status = $5 AND attempts = $6`,
+		StatusPending, err.Error(), delay.Milliseconds(), job.ID, StatusRunning, job.Attempts); updateErr != nil {
+		log.Printf("Failed to reschedule job %d: %v", job.ID, updateErr)
+		return
+	}
+	log.Printf("Job %d (%s) attempt %d failed, retrying in %s: %v", job.ID, job.Kind, job.Attempts, delay, err)
+}
+
+// handle runs the job's handler within the attempt timeout, turning a panic
+// into an error so one bad job cannot stop the worker
+func (q *Queue) handle(ctx context.Context, k Kind, job *Job) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, q.config.Timeout)
+	defer cancel()
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return k.Handle(ctx, job)
+}
+
+// backoff returns the delay before the retry following the given attempt:
+// the base doubled for each earlier attempt, capped, with up to 20% jitter so
+// failed jobs do not all retry at once
+func (q *Queue) backoff(attempt int) time.Duration {
+	delay := q.config.BackoffBase
+	for i := 1; i < attempt && delay < q.config.BackoffMax; i++ {
+		delay *= 2
+	}
+	if delay > q.config.BackoffMax {
+		delay = q.config.BackoffMax
+	}
+	return delay + time.Duration(rand.Int63n(int64(delay)/5+1))
+}
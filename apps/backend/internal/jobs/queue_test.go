@@ -0,0 +1,282 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+const testKind = "test.job"
+
+func TestBackoffDoublesUpToMax(t *testing.T) {
+	q := NewQueue(nil, Config{BackoffBase: time.Second, BackoffMax: 10 * time.Second})
+
+	for _, tc := range []struct {
+		attempt int
+		base    time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{30, 10 * time.Second},
+	} {
+		for i := 0; i < 50; i++ {
+			delay := q.backoff(tc.attempt)
+			if delay < tc.base || delay > tc.base+tc.base/5 {
+				t.Fatalf("attempt %d: delay %s outside [%s, %s]", tc.attempt, delay, tc.base, tc.base+tc.base/5)
+			}
+		}
+	}
+}
+
+// newTestQueue returns a queue on an empty jobs table in the database at
+// TEST_DATABASE_URL. The queue relies on Postgres locking, so these tests do
+// not run against anything else.
+func newTestQueue(t *testing.T, config Config) *Queue {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if config.MaxAttempts == 0 {
+		config.MaxAttempts = 3
+	}
+	if config.Timeout == 0 {
+		config.Timeout = time.Minute
+	}
+	q := NewQueue(db, config)
+	if err := q.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`DELETE FROM jobs`); err != nil {
+		t.Fatalf("clear jobs: %v", err)
+	}
+	return q
+}
+
+func enqueue(t *testing.T, q *Queue) *Job {
+	t.Helper()
+	job, err := q.Enqueue(context.Background(), q.db, testKind, map[string]string{"key": "value"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+func get(t *testing.T, q *Queue, id int64) *Job {
+	t.Helper()
+	job, err := q.Get(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+func TestClaimHandsOutEachJobOnce(t *testing.T) {
+	q := newTestQueue(t, Config{})
+	const total = 20
+	for i := 0; i < total; i++ {
+		enqueue(t, q)
+	}
+
+	var mu sync.Mutex
+	claimed := map[int64]int{}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				job, err := q.claim(context.Background())
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if job == nil {
+					return
+				}
+				mu.Lock()
+				claimed[job.ID]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(claimed) != total {
+		t.Fatalf("claimed %d jobs, want %d", len(claimed), total)
+	}
+	for id, n := range claimed {
+		if n != 1 {
+			t.Fatalf("job %d claimed %d times", id, n)
+		}
+	}
+}
+
+func TestClaimReclaimsExpiredLease(t *testing.T) {
+	q := newTestQueue(t, Config{})
+	q.Register(testKind, Kind{Handle: func(ctx context.Context, job *Job) error { return nil }})
+	ctx := context.Background()
+	enqueue(t, q)
+
+	first, err := q.claim(ctx)
+	if err != nil || first == nil {
+		t.Fatalf("claim: %v, %v", first, err)
+	}
+	if again, err := q.claim(ctx); err != nil || again != nil {
+		t.Fatalf("running job claimed again while leased: %v, %v", again, err)
+	}
+
+	// The worker holding the lease died
+	if _, err := q.db.Exec(`UPDATE jobs SET locked_until = NOW() - INTERVAL '1 second' WHERE id = $1`, first.ID); err != nil {
+		t.Fatal(err)
+	}
+	second, err := q.claim(ctx)
+	if err != nil || second == nil || second.ID != first.ID || second.Attempts != 2 {
+		t.Fatalf("reclaim: got %+v, %v", second, err)
+	}
+
+	// The worker that lost the lease reports back late and changes nothing
+	q.execute(ctx, first)
+	if job := get(t, q, first.ID); job.Status != StatusRunning || job.Attempts != 2 {
+		t.Fatalf("stale worker overwrote job: %+v", job)
+	}
+
+	q.execute(ctx, second)
+	if job := get(t, q, first.ID); job.Status != StatusSucceeded {
+		t.Fatalf("status %q, want %q", job.Status, StatusSucceeded)
+	}
+}
+
+func TestExecuteRetriesWithBackoffThenDeadLetters(t *testing.T) {
+	q := newTestQueue(t, Config{MaxAttempts: 2, BackoffBase: time.Hour, BackoffMax: time.Hour})
+	var deadLettered []int64
+	q.Register(testKind, Kind{
+		Handle:       func(ctx context.Context, job *Job) error { return errors.New("provider unavailable") },
+		DeadLettered: func(ctx context.Context, job *Job) { deadLettered = append(deadLettered, job.ID) },
+	})
+	ctx := context.Background()
+	enqueued := enqueue(t, q)
+
+	job, err := q.claim(ctx)
+	if err != nil || job == nil {
+		t.Fatalf("claim: %v, %v", job, err)
+	}
+	q.execute(ctx, job)
+
+	retry := get(t, q, enqueued.ID)
+	if retry.Status != StatusPending || retry.LastError != "provider unavailable" || retry.LockedUntil != nil {
+		t.Fatalf("after a failed attempt: %+v", retry)
+	}
+	if wait := time.Until(retry.RunAt); wait < 50*time.Minute {
+		t.Fatalf("retry due in %s, want about an hour", wait)
+	}
+	if next, err := q.claim(ctx); err != nil || next != nil {
+		t.Fatalf("job claimed before its backoff ran out: %v, %v", next, err)
+	}
+
+	if _, err := q.db.Exec(`UPDATE jobs SET run_at = NOW() WHERE id = $1`, enqueued.ID); err != nil {
+		t.Fatal(err)
+	}
+	job, err = q.claim(ctx)
+	if err != nil || job == nil {
+		t.Fatalf("claim retry: %v, %v", job, err)
+	}
+	q.execute(ctx, job)
+
+	if dead := get(t, q, enqueued.ID); dead.Status != StatusDead || dead.FinishedAt == nil {
+		t.Fatalf("after the last attempt: %+v", dead)
+	}
+	if len(deadLettered) != 1 || deadLettered[0] != enqueued.ID {
+		t.Fatalf("DeadLettered called for %v", deadLettered)
+	}
+}
+
+func TestPermanentErrorDeadLettersAtOnce(t *testing.T) {
+	q := newTestQueue(t, Config{MaxAttempts: 5})
+	q.Register(testKind, Kind{
+		Handle: func(ctx context.Context, job *Job) error { return Permanent(errors.New("bad payload")) },
+	})
+	ctx := context.Background()
+	enqueued := enqueue(t, q)
+
+	job, err := q.claim(ctx)
+	if err != nil || job == nil {
+		t.Fatalf("claim: %v, %v", job, err)
+	}
+	q.execute(ctx, job)
+
+	if dead := get(t, q, enqueued.ID); dead.Status != StatusDead || dead.Attempts != 1 {
+		t.Fatalf("after a permanent error: %+v", dead)
+	}
+}
+
+func TestCancelAndRetryRunHooks(t *testing.T) {
+	q := newTestQueue(t, Config{})
+	var calls []string
+	q.Register(testKind, Kind{
+		Handle: func(ctx context.Context, job *Job) error { return nil },
+		Cancelled: func(ctx context.Context, tx *sql.Tx, job *Job) error {
+			calls = append(calls, "cancelled")
+			return nil
+		},
+		Requeued: func(ctx context.Context, tx *sql.Tx, job *Job) error {
+			calls = append(calls, "requeued")
+			return nil
+		},
+	})
+	ctx := context.Background()
+	enqueued := enqueue(t, q)
+
+	cancelled, err := q.Cancel(ctx, enqueued.ID)
+	if err != nil || cancelled.Status != StatusCancelled {
+		t.Fatalf("cancel: %+v, %v", cancelled, err)
+	}
+	if _, err := q.Cancel(ctx, enqueued.ID); !errors.Is(err, ErrJobState) {
+		t.Fatalf("cancelling twice: got %v, want ErrJobState", err)
+	}
+	if job, err := q.claim(ctx); err != nil || job != nil {
+		t.Fatalf("cancelled job claimed: %v, %v", job, err)
+	}
+
+	retried, err := q.Retry(ctx, enqueued.ID)
+	if err != nil || retried.Status != StatusPending || retried.Attempts != 0 {
+		t.Fatalf("retry: %+v, %v", retried, err)
+	}
+	if len(calls) != 2 || calls[0] != "cancelled" || calls[1] != "requeued" {
+		t.Fatalf("hooks called: %v", calls)
+	}
+}
+
+func TestCancelRollsBackWhenHookFails(t *testing.T) {
+	q := newTestQueue(t, Config{})
+	q.Register(testKind, Kind{
+		Handle: func(ctx context.Context, job *Job) error { return nil },
+		Cancelled: func(ctx context.Context, tx *sql.Tx, job *Job) error {
+			return errors.New("instance update failed")
+		},
+	})
+	enqueued := enqueue(t, q)
+
+	if _, err := q.Cancel(context.Background(), enqueued.ID); err == nil {
+		t.Fatal("cancel succeeded although its hook failed")
+	}
+	if job := get(t, q, enqueued.ID); job.Status != StatusPending {
+		t.Fatalf("status %q, want %q", job.Status, StatusPending)
+	}
+}
@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/gokulupadhyayguragain/addtocloud/libs/security/abuse"
+)
+
+// ChallengeTokenHeader carries the token from a solved challenge
+const ChallengeTokenHeader = "X-Challenge-Token"
+
+// maxSubmissionBytes bounds the body read to look for the email and honeypot
+const maxSubmissionBytes = 64 << 10
+
+// AbuseProtection guards a public JSON submission endpoint. The body is read
+// for its email and honeypot fields and restored for the handler. Honeypot
+// hits get a normal-looking response so bots do not learn they were caught.
+func AbuseProtection(guard *abuse.Guard, endpoint string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxSubmissionBytes+1))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			c.Abort()
+			return
+		}
+		if len(body) > maxSubmissionBytes {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request body too large"})
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		// Malformed JSON is left for the handler to reject
+		var fields struct {
+			Email    string `json:"email"`
+			Honeypot string `json:"website"`
+		}
+		_ = json.Unmarshal(body, &fields)
+
+		block := guard.Check(c.Request.Context(), abuse.Attempt{
+			Endpoint:       endpoint,
+			IPAddress:      c.ClientIP(),
+			Email:          fields.Email,
+			UserAgent:      c.Request.UserAgent(),
+			Honeypot:       fields.Honeypot,
+			ChallengeToken: c.GetHeader(ChallengeTokenHeader),
+		})
+		if block == nil {
+			c.Next()
+			return
+		}
+
+		switch block.Reason {
+		case abuse.ReasonHoneypot:
+			c.JSON(http.StatusAccepted, gin.H{"message": "Submission received"})
+		case abuse.ReasonChallenge:
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":             "Challenge verification failed",
+				"challengeRequired": true,
+			})
+		default:
+			seconds := int(math.Ceil(block.RetryAfter.Seconds()))
+			c.Header("Retry-After", strconv.Itoa(seconds))
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error":      "Too many submissions. Please try again later.",
+				"retryAfter": seconds,
+			})
+		}
+		c.Abort()
+	}
+}
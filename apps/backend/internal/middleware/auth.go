@@ -1,16 +1,36 @@
 package middleware
 
 import (
+	"errors"
 	"net/http"
-	"os"
 	"strings"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/auth"
 )
 
-func AuthMiddleware() gin.HandlerFunc {
+// AuthMiddleware authenticates the request with an API key or bearer token.
+// Requests made with an impersonation token are recorded in the audit log
+// kept in db and limited to reads.
+func AuthMiddleware(tokens *auth.TokenService, db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		// Personal API keys for non-interactive clients
+		if apiKey := c.GetHeader("X-API-Key"); apiKey != "" {
+			claims, err := tokens.AuthenticateAPIKey(apiKey)
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+				c.Abort()
+				return
+			}
+
+			c.Set("userID", claims.UserID)
+			c.Set("claims", claims)
+			c.Next()
+			return
+		}
+
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
@@ -26,33 +46,52 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		tokenString := bearerToken[1]
+		// Parse and validate token, rejecting revoked token IDs
+		claims, err := tokens.ParseAccessToken(bearerToken[1])
+		if err != nil {
+			if errors.Is(err, auth.ErrTokenRevoked) {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Token has been revoked"})
+			} else {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			}
+			c.Abort()
+			return
+		}
+
+		c.Set("userID", claims.UserID)
+		c.Set("claims", claims)
 
-		// Get JWT secret from environment or use default
-		jwtSecret := []byte(os.Getenv("JWT_SECRET"))
-		if len(jwtSecret) == 0 {
-			jwtSecret = []byte("your-super-secret-jwt-key-change-in-production")
+		if claims.IsImpersonation() {
+			serveImpersonated(c, db, claims)
+			return
 		}
 
-		// Parse and validate token
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, jwt.ErrSignatureInvalid
-			}
-			return jwtSecret, nil
-		})
+		// Last-seen tracking is best effort and never fails the request
+		if claims.SessionID != "" {
+			_ = tokens.TouchSession(claims.SessionID, auth.ClientInfo{IPAddress: c.ClientIP(), UserAgent: c.Request.UserAgent()})
+		}
 
-		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		c.Next()
+	}
+}
+
+// RequireInteractiveSession rejects API keys. Routes that manage the account
+// itself (MFA, sessions, keys, erasure, organizations) are only open to a
+// signed-in user, whatever scopes a key holds. It must run after
+// AuthMiddleware.
+func RequireInteractiveSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := claimsFromContext(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
 			c.Abort()
 			return
 		}
 
-		// Extract user ID from claims
-		if claims, ok := token.Claims.(jwt.MapClaims); ok {
-			if userID, exists := claims["user_id"]; exists {
-				c.Set("userID", userID)
-			}
+		if claims.TokenType == auth.TokenTypeAPIKey {
+			c.JSON(http.StatusForbidden, gin.H{"error": "API keys cannot be used for account management"})
+			c.Abort()
+			return
 		}
 
 		c.Next()
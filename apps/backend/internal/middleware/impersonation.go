@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/auth"
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/models"
+)
+
+// ImpersonatedByHeader is set on every response to an impersonation token so
+// clients can show that an admin is acting as the user
+const ImpersonatedByHeader = "X-Impersonated-By"
+
+// Writes an impersonation token may make. Everything else that is not a read
+// is blocked; ending the impersonation early is the only exception.
+var impersonationWritesAllowed = map[string]bool{
+	http.MethodPost + " /api/v1/auth/logout": true,
+}
+
+// serveImpersonated runs the rest of a request made with an impersonation
+// token, blocking it unless it is read-only. The audit row is written before
+// the request runs so nothing happens unrecorded, and completed with the
+// response status afterwards.
+func serveImpersonated(c *gin.Context, db *gorm.DB, claims *auth.Claims) {
+	c.Header(ImpersonatedByHeader, claims.Actor.Subject)
+
+	actorID, subjectID := claims.Actor.UserID, claims.UserID
+	entry := models.AuditLog{
+		UserID:             &actorID,
+		ImpersonatedUserID: &subjectID,
+		Action:             models.AuditImpersonationRequest,
+		ResourceType:       "http_request",
+		ResourceID:         claims.ID,
+		Method:             c.Request.Method,
+		Path:               c.Request.URL.Path,
+		IPAddress:          c.ClientIP(),
+		UserAgent:          c.Request.UserAgent(),
+	}
+
+	allowed := isReadOnly(c.Request.Method) || impersonationWritesAllowed[c.Request.Method+" "+c.FullPath()]
+	if !allowed {
+		entry.Action = models.AuditImpersonationBlocked
+		entry.StatusCode = http.StatusForbidden
+	}
+
+	if err := db.Create(&entry).Error; err != nil {
+		log.Printf("Failed to audit impersonated request by user %d: %v", actorID, err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Unable to record audit log"})
+		c.Abort()
+		return
+	}
+
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":         "This action is not allowed while impersonating a user",
+			"impersonating": true,
+		})
+		c.Abort()
+		return
+	}
+
+	c.Next()
+
+	if err := db.Model(&entry).Update("status_code", c.Writer.Status()).Error; err != nil {
+		log.Printf("Failed to record status of audited request %d: %v", entry.ID, err)
+	}
+}
+
+func isReadOnly(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,187 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/models"
+)
+
+// OrganizationHeader names the organization a request acts in when the route
+// does not include one
+const OrganizationHeader = "X-Organization-ID"
+
+// OrganizationContext resolves the organization the request acts in and the
+// caller's role there, and stores them as "organizationID" and "orgRole". The
+// organization comes from the :orgId route parameter, then the
+// X-Organization-ID header, then the user's default organization. Users
+// without any organization get a personal one. It must run after
+// AuthMiddleware.
+func OrganizationContext(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := claimsFromContext(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+			c.Abort()
+			return
+		}
+
+		requested := c.Param("orgId")
+		if requested == "" {
+			requested = c.GetHeader(OrganizationHeader)
+		}
+
+		var membership *models.OrganizationMember
+		var err error
+		if requested != "" {
+			orgID, parseErr := strconv.ParseUint(requested, 10, 64)
+			if parseErr != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+				c.Abort()
+				return
+			}
+			membership, err = findMembership(db, uint(orgID), claims.UserID)
+		} else {
+			membership, err = defaultMembership(db, claims.UserID)
+		}
+
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// Indistinguishable from a missing organization so IDs cannot be probed
+			c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+			c.Abort()
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve organization"})
+			c.Abort()
+			return
+		}
+
+		c.Set("organizationID", membership.OrganizationID)
+		c.Set("orgRole", membership.Role)
+		c.Next()
+	}
+}
+
+// RequireOrgRole allows the request through only if the caller holds at least
+// the given role in the current organization. It must run after
+// OrganizationContext.
+func RequireOrgRole(minimum string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := c.GetString("orgRole")
+		if role == "" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "No organization selected"})
+			c.Abort()
+			return
+		}
+
+		if !models.OrgRoleAtLeast(role, minimum) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":        "Insufficient organization role",
+				"requiredRole": minimum,
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func findMembership(db *gorm.DB, orgID, userID uint) (*models.OrganizationMember, error) {
+	var membership models.OrganizationMember
+	if err := db.Where("organization_id = ? AND user_id = ?", orgID, userID).First(&membership).Error; err != nil {
+		return nil, err
+	}
+	return &membership, nil
+}
+
+// defaultMembership returns the membership of the user's default
+// organization, falling back to their oldest membership and finally to a new
+// personal organization
+func defaultMembership(db *gorm.DB, userID uint) (*models.OrganizationMember, error) {
+	var user models.User
+	if err := db.First(&user, userID).Error; err != nil {
+		return nil, err
+	}
+
+	if user.DefaultOrgID != nil {
+		membership, err := findMembership(db, *user.DefaultOrgID, userID)
+		if err == nil {
+			return membership, nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+	}
+
+	var membership models.OrganizationMember
+	err := db.Where("user_id = ?", userID).Order("created_at").First(&membership).Error
+	if err == nil {
+		return &membership, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	return createPersonalOrganization(db, &user)
+}
+
+// createPersonalOrganization gives the user an organization of their own and
+// moves any instances they created before organizations existed into it
+func createPersonalOrganization(db *gorm.DB, user *models.User) (*models.OrganizationMember, error) {
+	var membership models.OrganizationMember
+	created := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Lock the user so concurrent first requests create only one organization
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(user, user.ID).Error; err != nil {
+			return err
+		}
+		err := tx.Where("user_id = ?", user.ID).Order("created_at").First(&membership).Error
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		org := models.Organization{
+			Name:        fmt.Sprintf("%s's workspace", user.FirstName),
+			Personal:    true,
+			CreatedByID: user.ID,
+		}
+		if err := tx.Create(&org).Error; err != nil {
+			return err
+		}
+
+		membership = models.OrganizationMember{OrganizationID: org.ID, UserID: user.ID, Role: models.OrgRoleOwner}
+		if err := tx.Create(&membership).Error; err != nil {
+			return err
+		}
+
+		created = true
+		return tx.Model(user).Update("default_org_id", org.ID).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !created {
+		return &membership, nil
+	}
+
+	if err := db.Exec(
+		"UPDATE instances SET organization_id = ? WHERE user_id = ? AND organization_id IS NULL",
+		membership.OrganizationID, strconv.FormatUint(uint64(user.ID), 10),
+	).Error; err != nil {
+		log.Printf("Warning: Failed to move instances of user %d into their organization: %v", user.ID, err)
+	}
+
+	return &membership, nil
+}
@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireMFA rejects sessions that did not complete a second factor at
+// login. It must run after AuthMiddleware.
+func RequireMFA() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := claimsFromContext(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+			c.Abort()
+			return
+		}
+
+		if !claims.MFA {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":        "Multi-factor authentication required",
+				"mfa_required": true,
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireVerifiedEmail rejects users who have not verified their email
+// address. It must run after AuthMiddleware.
+func RequireVerifiedEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := claimsFromContext(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+			c.Abort()
+			return
+		}
+
+		if !claims.EmailVerified {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":                       "Email address not verified",
+				"email_verification_required": true,
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
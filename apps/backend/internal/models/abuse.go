@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+)
+
+// BlockedSubmission records an attempt on a public form that the abuse
+// protection stopped, for review by admins
+type BlockedSubmission struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	Endpoint  string    `json:"endpoint" gorm:"index;not null"`
+	Reason    string    `json:"reason" gorm:"index;not null"` // honeypot, ip_rate_limit, email_rate_limit or challenge_failed
+	IPAddress string    `json:"ipAddress" gorm:"index"`
+	Email     string    `json:"email"`
+	UserAgent string    `json:"userAgent"`
+	CreatedAt time.Time `json:"createdAt" gorm:"index"`
+}
+
+// TableName specifies the table name for BlockedSubmission
+func (BlockedSubmission) TableName() string {
+	return "blocked_submissions"
+}
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Conditions an access policy rule can test
+const (
+	PolicyRuleEmailDomain     = "email_domain"     // Email domain is, or is a subdomain of, one of Values
+	PolicyRuleDisposableEmail = "disposable_email" // Email domain is a known throwaway provider or one of Values
+	PolicyRuleCountry         = "country"          // Country is one of Values
+	PolicyRuleReasonKeyword   = "reason_keyword"   // Business reason contains one of Values
+)
+
+// What happens to a request when a rule fires
+const (
+	PolicyActionApprove = "approve"
+	PolicyActionReject  = "reject"
+	PolicyActionFlag    = "flag" // Leave pending and mark it for a closer look
+)
+
+// AccessPolicyRule is an admin-managed rule evaluated when an access request
+// is submitted. Enabled rules are checked in ascending priority and the first
+// one that matches decides the request; requests no rule matches wait for
+// manual review.
+type AccessPolicyRule struct {
+	ID          uint           `json:"id" gorm:"primaryKey"`
+	Name        string         `json:"name" gorm:"not null"`
+	Type        string         `json:"type" gorm:"not null"`
+	Values      pq.StringArray `json:"values" gorm:"type:text[]"`
+	Negate      bool           `json:"negate" gorm:"default:false"` // Fire when the condition does not hold, e.g. for country allow lists
+	Action      string         `json:"action" gorm:"not null"`
+	Priority    int            `json:"priority" gorm:"index;default:100"`
+	Enabled     bool           `json:"enabled" gorm:"default:true"`
+	CreatedByID uint           `json:"createdById"`
+	CreatedAt   time.Time      `json:"createdAt"`
+	UpdatedAt   time.Time      `json:"updatedAt"`
+}
+
+// TableName specifies the table name for AccessPolicyRule
+func (AccessPolicyRule) TableName() string {
+	return "access_policy_rules"
+}
@@ -1,17 +1,94 @@
 package models
 
 import (
+	"fmt"
 	"time"
 
 	"gorm.io/gorm"
 )
 
+// Access request statuses. Approved, rejected, withdrawn and expired are final.
+const (
+	AccessRequestPending   = "pending"
+	AccessRequestNeedsInfo = "needs_info" // Waiting for the requester to answer a question
+	AccessRequestEscalated = "escalated"  // Handed to a senior reviewer
+	AccessRequestApproved  = "approved"
+	AccessRequestRejected  = "rejected"
+	AccessRequestWithdrawn = "withdrawn" // Cancelled by the requester
+	AccessRequestExpired   = "expired"
+)
+
+// Who moved an access request to a new status or wrote a comment
+const (
+	AccessRequestActorAdmin     = "admin"
+	AccessRequestActorRequester = "requester"
+	AccessRequestActorSystem    = "system"
+)
+
+// Levels of access a requester can ask for. The level is shown to reviewers
+// and policy rules; it does not change what an approval grants.
+const (
+	AccessLevelStandard   = "standard"
+	AccessLevelEnterprise = "enterprise"
+)
+
+// IsValidAccessLevel reports whether level is a known access level
+func IsValidAccessLevel(level string) bool {
+	return level == AccessLevelStandard || level == AccessLevelEnterprise
+}
+
+// accessRequestTransitions lists the statuses each status may move to
+var accessRequestTransitions = map[string][]string{
+	AccessRequestPending: {
+		AccessRequestNeedsInfo, AccessRequestEscalated, AccessRequestApproved,
+		AccessRequestRejected, AccessRequestWithdrawn, AccessRequestExpired,
+	},
+	AccessRequestNeedsInfo: {
+		AccessRequestPending, AccessRequestEscalated, AccessRequestApproved,
+		AccessRequestRejected, AccessRequestWithdrawn, AccessRequestExpired,
+	},
+	AccessRequestEscalated: {
+		AccessRequestPending, AccessRequestNeedsInfo, AccessRequestApproved,
+		AccessRequestRejected, AccessRequestWithdrawn, AccessRequestExpired,
+	},
+}
+
+// OpenAccessRequestStatuses are the statuses of requests still awaiting a decision
+var OpenAccessRequestStatuses = []string{AccessRequestPending, AccessRequestNeedsInfo, AccessRequestEscalated}
+
+// IsValidAccessRequestStatus reports whether status is a known status
+func IsValidAccessRequestStatus(status string) bool {
+	switch status {
+	case AccessRequestApproved, AccessRequestRejected, AccessRequestWithdrawn, AccessRequestExpired:
+		return true
+	}
+	_, ok := accessRequestTransitions[status]
+	return ok
+}
+
+// CanTransitionAccessRequest reports whether a request may move from one
+// status to another
+func CanTransitionAccessRequest(from, to string) bool {
+	for _, next := range accessRequestTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// IsOpen reports whether the request is still awaiting a decision
+func (r *AccessRequest) IsOpen() bool {
+	_, ok := accessRequestTransitions[r.Status]
+	return ok
+}
+
 // AccessRequest represents a user's request for platform access
 type AccessRequest struct {
 	ID                 uint           `json:"id" gorm:"primaryKey"`
 	FirstName          string         `json:"firstName" gorm:"not null"`
 	LastName           string         `json:"lastName" gorm:"not null"`
-	Email              string         `json:"email" gorm:"uniqueIndex;not null"`
+	Email              string         `json:"email" gorm:"index:idx_access_requests_email_lookup;not null"` // Unique among active requests, see MigrateAccessRequests
 	Phone              string         `json:"phone" gorm:"not null"`
 	Company            string         `json:"company" gorm:"not null"`
 	Address            string         `json:"address" gorm:"not null"`
@@ -19,11 +96,17 @@ type AccessRequest struct {
 	Country            string         `json:"country" gorm:"not null"`
 	BusinessReason     string         `json:"businessReason" gorm:"type:text;not null"`
 	ProjectDescription string         `json:"projectDescription" gorm:"type:text"`
-	Status             string         `json:"status" gorm:"default:'pending'"` // pending, approved, rejected
+	AccessLevel        string         `json:"accessLevel" gorm:"not null;default:'standard'"`
+	RequiredApprovals  int            `json:"requiredApprovals" gorm:"not null;default:1"` // Distinct reviewers needed, fixed at submission
+	Status             string         `json:"status" gorm:"index;default:'pending'"`       // See AccessRequestPending and friends
 	ReviewedAt         *time.Time     `json:"reviewedAt"`
 	ReviewedBy         string         `json:"reviewedBy"`
 	ReviewNotes        string         `json:"reviewNotes" gorm:"type:text"`
-	UserID             *uint          `json:"userId"` // Set when user account is created
+	UserID             *uint          `json:"userId"`                  // Set when user account is created
+	TrackingTokenHash  string         `json:"-" gorm:"index"`          // Lets the requester follow up without an account
+	FlaggedReason      string         `json:"flaggedReason"`           // Set when a policy rule flagged the request for review
+	ReminderSentAt     *time.Time     `json:"reminderSentAt"`          // Last time admins were reminded the request is waiting
+	AssigneeID         *uint          `json:"assigneeId" gorm:"index"` // Reviewer responsible for the request
 	CreatedAt          time.Time      `json:"createdAt"`
 	UpdatedAt          time.Time      `json:"updatedAt"`
 	DeletedAt          gorm.DeletedAt `json:"-" gorm:"index"`
@@ -33,3 +116,73 @@ type AccessRequest struct {
 func (AccessRequest) TableName() string {
 	return "access_requests"
 }
+
+// MigrateAccessRequests replaces the unique index on email with one that only
+// covers requests still open or approved, so a person whose request expired,
+// was rejected or was withdrawn can apply again. Run it after AutoMigrate. The
+// statuses must match OpenAccessRequestStatuses plus approved.
+func MigrateAccessRequests(db *gorm.DB) error {
+	statements := []string{
+		`DROP INDEX IF EXISTS idx_access_requests_email`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_access_requests_active_email ON access_requests (email)
+			WHERE deleted_at IS NULL AND status IN ('pending', 'needs_info', 'escalated', 'approved')`,
+	}
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			return fmt.Errorf("failed to migrate access requests: %w", err)
+		}
+	}
+	return nil
+}
+
+// AccessRequestTransition records one status change of an access request and
+// who made it
+type AccessRequestTransition struct {
+	ID              uint      `json:"id" gorm:"primaryKey"`
+	AccessRequestID uint      `json:"accessRequestId" gorm:"index;not null"`
+	FromStatus      string    `json:"fromStatus"` // Empty for the submission itself
+	ToStatus        string    `json:"toStatus" gorm:"not null"`
+	ActorType       string    `json:"actorType" gorm:"not null"`
+	ActorID         *uint     `json:"actorId"` // The admin's user ID
+	ActorName       string    `json:"actorName"`
+	Note            string    `json:"note" gorm:"type:text"`
+	CreatedAt       time.Time `json:"createdAt"`
+}
+
+// TableName specifies the table name for AccessRequestTransition
+func (AccessRequestTransition) TableName() string {
+	return "access_request_history"
+}
+
+// AccessRequestComment is a message in the thread between reviewers and the
+// requester
+type AccessRequestComment struct {
+	ID              uint      `json:"id" gorm:"primaryKey"`
+	AccessRequestID uint      `json:"accessRequestId" gorm:"index;not null"`
+	AuthorType      string    `json:"authorType" gorm:"not null"`
+	AuthorID        *uint     `json:"authorId"` // The admin's user ID
+	AuthorName      string    `json:"authorName"`
+	Body            string    `json:"body" gorm:"type:text;not null"`
+	CreatedAt       time.Time `json:"createdAt"`
+}
+
+// TableName specifies the table name for AccessRequestComment
+func (AccessRequestComment) TableName() string {
+	return "access_request_comments"
+}
+
+// AccessRequestApproval is one reviewer's sign-off on an access request. The
+// account is created once a request has RequiredApprovals of them.
+type AccessRequestApproval struct {
+	ID              uint      `json:"id" gorm:"primaryKey"`
+	AccessRequestID uint      `json:"accessRequestId" gorm:"uniqueIndex:idx_access_request_approver;not null"`
+	ReviewerID      uint      `json:"reviewerId" gorm:"uniqueIndex:idx_access_request_approver;not null"`
+	ReviewerEmail   string    `json:"reviewerEmail" gorm:"not null"`
+	Notes           string    `json:"notes" gorm:"type:text"`
+	CreatedAt       time.Time `json:"createdAt"`
+}
+
+// TableName specifies the table name for AccessRequestApproval
+func (AccessRequestApproval) TableName() string {
+	return "access_request_approvals"
+}
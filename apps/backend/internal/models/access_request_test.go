@@ -0,0 +1,63 @@
+package models
+
+import "testing"
+
+func TestCanTransitionAccessRequest(t *testing.T) {
+	for _, tc := range []struct {
+		from string
+		to   string
+		want bool
+	}{
+		{AccessRequestPending, AccessRequestNeedsInfo, true},
+		{AccessRequestPending, AccessRequestEscalated, true},
+		{AccessRequestPending, AccessRequestApproved, true},
+		{AccessRequestPending, AccessRequestRejected, true},
+		{AccessRequestPending, AccessRequestWithdrawn, true},
+		{AccessRequestPending, AccessRequestExpired, true},
+		{AccessRequestNeedsInfo, AccessRequestPending, true},
+		{AccessRequestNeedsInfo, AccessRequestApproved, true},
+		{AccessRequestEscalated, AccessRequestNeedsInfo, true},
+		{AccessRequestEscalated, AccessRequestRejected, true},
+
+		// A status does not move to itself
+		{AccessRequestPending, AccessRequestPending, false},
+		{AccessRequestNeedsInfo, AccessRequestNeedsInfo, false},
+		{AccessRequestEscalated, AccessRequestEscalated, false},
+
+		// Final statuses stay final
+		{AccessRequestApproved, AccessRequestPending, false},
+		{AccessRequestApproved, AccessRequestRejected, false},
+		{AccessRequestRejected, AccessRequestApproved, false},
+		{AccessRequestRejected, AccessRequestPending, false},
+		{AccessRequestWithdrawn, AccessRequestPending, false},
+		{AccessRequestExpired, AccessRequestPending, false},
+		{AccessRequestExpired, AccessRequestApproved, false},
+
+		// Unknown statuses go nowhere
+		{"archived", AccessRequestPending, false},
+		{AccessRequestPending, "archived", false},
+		{"", AccessRequestApproved, false},
+	} {
+		if got := CanTransitionAccessRequest(tc.from, tc.to); got != tc.want {
+			t.Errorf("CanTransitionAccessRequest(%q, %q) = %v, want %v", tc.from, tc.to, got, tc.want)
+		}
+	}
+}
+
+func TestOpenAccessRequestStatuses(t *testing.T) {
+	for _, status := range []string{
+		AccessRequestPending, AccessRequestNeedsInfo, AccessRequestEscalated,
+		AccessRequestApproved, AccessRequestRejected, AccessRequestWithdrawn, AccessRequestExpired,
+	} {
+		if !IsValidAccessRequestStatus(status) {
+			t.Errorf("%s is not a valid status", status)
+		}
+		open := status == AccessRequestPending || status == AccessRequestNeedsInfo || status == AccessRequestEscalated
+		if got := (&AccessRequest{Status: status}).IsOpen(); got != open {
+			t.Errorf("IsOpen() for %s = %v, want %v", status, got, open)
+		}
+	}
+	if IsValidAccessRequestStatus("archived") {
+		t.Error("unknown status accepted")
+	}
+}
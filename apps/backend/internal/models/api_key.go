@@ -0,0 +1,64 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// API key statuses
+const (
+	APIKeyStatusActive  = "active"
+	APIKeyStatusRevoked = "revoked"
+)
+
+// Scopes is a list of permissions stored as a JSONB array
+type Scopes []string
+
+// Value implements driver.Valuer
+func (s Scopes) Value() (driver.Value, error) {
+	if s == nil {
+		return "[]", nil
+	}
+	b, err := json.Marshal([]string(s))
+	return string(b), err
+}
+
+// Scan implements sql.Scanner
+func (s *Scopes) Scan(value interface{}) error {
+	var b []byte
+	switch v := value.(type) {
+	case nil:
+		*s = nil
+		return nil
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	default:
+		return errors.New("unsupported type for Scopes")
+	}
+	return json.Unmarshal(b, (*[]string)(s))
+}
+
+// APIKey is a long-lived personal credential for non-interactive clients.
+// Only a SHA-256 hash of the key is stored; the full key is shown once.
+type APIKey struct {
+	ID             uint       `json:"id" gorm:"primaryKey"`
+	UserID         uint       `json:"userId" gorm:"index;not null"`
+	Name           string     `json:"name" gorm:"column:key_name;not null"`
+	KeyHash        string     `json:"-" gorm:"column:api_key;uniqueIndex;not null"`
+	KeyPrefix      string     `json:"keyPrefix" gorm:"not null"`
+	Permissions    Scopes     `json:"permissions" gorm:"type:jsonb"`
+	CreatedWithMFA bool       `json:"createdWithMfa" gorm:"default:false"`
+	LastUsed       *time.Time `json:"lastUsed"`
+	ExpiresAt      *time.Time `json:"expiresAt"`
+	Status         string     `json:"status" gorm:"default:'active'"`
+	CreatedAt      time.Time  `json:"createdAt"`
+}
+
+// TableName specifies the table name for APIKey
+func (APIKey) TableName() string {
+	return "api_keys"
+}
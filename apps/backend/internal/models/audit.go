@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+)
+
+// Audit actions
+const (
+	AuditImpersonationStart   = "impersonation.start"
+	AuditImpersonationRequest = "impersonation.request"
+	AuditImpersonationBlocked = "impersonation.blocked"
+	AuditErasureScheduled     = "erasure.scheduled"
+	AuditErasureCancelled     = "erasure.cancelled"
+	AuditErasureCompleted     = "erasure.completed"
+)
+
+// AuditLog records a security relevant action. UserID is always the person
+// who really acted; when an admin impersonates a user, ImpersonatedUserID is
+// the user they acted as.
+type AuditLog struct {
+	ID                 uint      `json:"id" gorm:"primaryKey"`
+	UserID             *uint     `json:"userId" gorm:"index"`
+	ImpersonatedUserID *uint     `json:"impersonatedUserId" gorm:"index"`
+	Action             string    `json:"action" gorm:"index;not null"`
+	ResourceType       string    `json:"resourceType" gorm:"not null"`
+	ResourceID         string    `json:"resourceId"`
+	Method             string    `json:"method"`
+	Path               string    `json:"path"`
+	StatusCode         int       `json:"statusCode"`
+	Details            string    `json:"details"`
+	IPAddress          string    `json:"ipAddress"`
+	UserAgent          string    `json:"userAgent"`
+	Timestamp          time.Time `json:"timestamp" gorm:"index;autoCreateTime"`
+}
+
+// TableName specifies the table name for AuditLog
+func (AuditLog) TableName() string {
+	return "audit_logs"
+}
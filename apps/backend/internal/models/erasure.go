@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+)
+
+// Erasure request statuses
+const (
+	ErasureScheduled = "scheduled" // Waiting out the grace period, can still be cancelled
+	ErasureCancelled = "cancelled"
+	ErasureCompleted = "completed"
+)
+
+// ErasureRequest is a request to erase a person's data. Users erase their
+// account; applicants without an account erase a single access request.
+// Once completed the row is the record of the erasure and holds no personal
+// data.
+type ErasureRequest struct {
+	ID              uint       `json:"id" gorm:"primaryKey"`
+	UserID          *uint      `json:"userId" gorm:"index"`
+	AccessRequestID *uint      `json:"accessRequestId" gorm:"index"`
+	Email           string     `json:"-"` // Needed to find the data, cleared on completion
+	Status          string     `json:"status" gorm:"index;not null"`
+	RequestedByID   *uint      `json:"requestedById"` // Admin who scheduled it, nil when self-service
+	ScheduledFor    time.Time  `json:"scheduledFor" gorm:"index;not null"`
+	CancelledAt     *time.Time `json:"cancelledAt"`
+	CompletedAt     *time.Time `json:"completedAt"`
+	Summary         string     `json:"summary" gorm:"type:text"` // JSON count of rows purged or anonymized per table
+	CreatedAt       time.Time  `json:"createdAt"`
+	UpdatedAt       time.Time  `json:"updatedAt"`
+}
+
+// TableName specifies the table name for ErasureRequest
+func (ErasureRequest) TableName() string {
+	return "erasure_requests"
+}
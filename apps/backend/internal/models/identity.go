@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+)
+
+// UserIdentity links a user to an account at an external OpenID Connect
+// identity provider, or to a subject of a trusted token issuer, in which case
+// Provider is the issuer
+type UserIdentity struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	UserID      uint       `json:"userId" gorm:"index;not null"`
+	Provider    string     `json:"provider" gorm:"not null;uniqueIndex:idx_user_identities_provider_subject"`
+	Subject     string     `json:"-" gorm:"not null;uniqueIndex:idx_user_identities_provider_subject"`
+	Email       string     `json:"email"`
+	LastLoginAt *time.Time `json:"lastLoginAt"`
+	CreatedAt   time.Time  `json:"createdAt"`
+}
+
+// TableName specifies the table name for UserIdentity
+func (UserIdentity) TableName() string {
+	return "user_identities"
+}
+
+// OIDCLoginState holds the per-login secrets of an in-flight authorization
+// code flow until the provider redirects back. The state and PKCE verifier
+// live in a cookie on the browser that started the flow; only the state's
+// hash is kept here.
+type OIDCLoginState struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	StateHash  string    `json:"-" gorm:"uniqueIndex;not null"`
+	Provider   string    `json:"provider" gorm:"not null"`
+	Nonce      string    `json:"-" gorm:"not null"`
+	LinkUserID *uint     `json:"-"` // Set when a signed-in user is linking the identity to their account
+	ExpiresAt  time.Time `json:"expiresAt" gorm:"index;not null"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// TableName specifies the table name for OIDCLoginState
+func (OIDCLoginState) TableName() string {
+	return "oidc_login_states"
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+)
+
+// LockoutEvent records an account or client IP being locked out after
+// repeated authentication failures, for review by admins
+type LockoutEvent struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	Realm       string    `json:"realm" gorm:"index;not null"` // Which check failed, e.g. login or mfa
+	Scope       string    `json:"scope" gorm:"not null"`       // account or ip
+	Subject     string    `json:"subject" gorm:"index;not null"`
+	IPAddress   string    `json:"ipAddress"`
+	Failures    int64     `json:"failures"`
+	LockedUntil time.Time `json:"lockedUntil" gorm:"index"`
+	CreatedAt   time.Time `json:"createdAt" gorm:"index"`
+}
+
+// TableName specifies the table name for LockoutEvent
+func (LockoutEvent) TableName() string {
+	return "lockout_events"
+}
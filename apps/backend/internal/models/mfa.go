@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+)
+
+// MFARecoveryCode is a single-use code that can replace a TOTP code when the
+// user has lost access to their authenticator. Only a hash is stored.
+type MFARecoveryCode struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	UserID    uint       `json:"userId" gorm:"index;not null"`
+	CodeHash  string     `json:"-" gorm:"uniqueIndex;not null"`
+	UsedAt    *time.Time `json:"usedAt"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// TableName specifies the table name for MFARecoveryCode
+func (MFARecoveryCode) TableName() string {
+	return "mfa_recovery_codes"
+}
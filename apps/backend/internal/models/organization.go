@@ -0,0 +1,83 @@
+package models
+
+import (
+	"time"
+)
+
+// Roles a user can hold within an organization, from most to least privileged
+const (
+	OrgRoleOwner  = "owner"
+	OrgRoleAdmin  = "admin"
+	OrgRoleMember = "member"
+	OrgRoleViewer = "viewer"
+)
+
+// orgRoleRank orders organization roles so checks can ask for a minimum role
+var orgRoleRank = map[string]int{
+	OrgRoleOwner:  4,
+	OrgRoleAdmin:  3,
+	OrgRoleMember: 2,
+	OrgRoleViewer: 1,
+}
+
+// IsValidOrgRole reports whether role is a known organization role
+func IsValidOrgRole(role string) bool {
+	_, ok := orgRoleRank[role]
+	return ok
+}
+
+// OrgRoleAtLeast reports whether role grants at least the privileges of minimum
+func OrgRoleAtLeast(role, minimum string) bool {
+	return orgRoleRank[role] > 0 && orgRoleRank[role] >= orgRoleRank[minimum]
+}
+
+// Organization owns instances and other resources shared by its members
+type Organization struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	Name        string    `json:"name" gorm:"not null"`
+	Personal    bool      `json:"personal" gorm:"default:false"` // Created automatically for a user's own resources
+	CreatedByID uint      `json:"createdById" gorm:"index"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// TableName specifies the table name for Organization
+func (Organization) TableName() string {
+	return "organizations"
+}
+
+// OrganizationMember grants a user a role in an organization
+type OrganizationMember struct {
+	ID             uint      `json:"id" gorm:"primaryKey"`
+	OrganizationID uint      `json:"organizationId" gorm:"uniqueIndex:idx_org_member;not null"`
+	UserID         uint      `json:"userId" gorm:"uniqueIndex:idx_org_member;index;not null"`
+	Role           string    `json:"role" gorm:"not null"`
+	User           *User     `json:"user,omitempty" gorm:"constraint:OnDelete:CASCADE"`
+	CreatedAt      time.Time `json:"createdAt"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+}
+
+// TableName specifies the table name for OrganizationMember
+func (OrganizationMember) TableName() string {
+	return "organization_members"
+}
+
+// OrganizationInvitation is an emailed, single-use invitation to join an
+// organization. Only a hash of the token is stored.
+type OrganizationInvitation struct {
+	ID             uint       `json:"id" gorm:"primaryKey"`
+	OrganizationID uint       `json:"organizationId" gorm:"index;not null"`
+	Email          string     `json:"email" gorm:"index;not null"`
+	Role           string     `json:"role" gorm:"not null"`
+	TokenHash      string     `json:"-" gorm:"uniqueIndex;not null"`
+	InvitedByID    uint       `json:"invitedById"`
+	ExpiresAt      time.Time  `json:"expiresAt" gorm:"not null"`
+	AcceptedAt     *time.Time `json:"acceptedAt"`
+	RevokedAt      *time.Time `json:"revokedAt"`
+	CreatedAt      time.Time  `json:"createdAt"`
+}
+
+// TableName specifies the table name for OrganizationInvitation
+func (OrganizationInvitation) TableName() string {
+	return "organization_invitations"
+}
@@ -0,0 +1,59 @@
+package models
+
+// Roles that can be assigned to a user
+const (
+	RoleAdmin   = "admin"
+	RoleUser    = "user"
+	RoleViewer  = "viewer"
+	RolePending = "pending" // Signed up on their own; promoted to user when an access request is approved
+)
+
+// Permissions checked by the API
+const (
+	PermAccessRequestsRead   = "access_requests:read"
+	PermAccessRequestsReview = "access_requests:review"
+	PermAccessPoliciesManage = "access_policies:manage"
+	PermUsersManage          = "users:manage"
+	PermJobsManage           = "jobs:manage"
+	PermInstancesRead        = "instances:read"
+	PermInstancesWrite       = "instances:write"
+)
+
+// RolePermissions lists the permissions granted by each role
+var RolePermissions = map[string][]string{
+	RoleAdmin: {
+		PermAccessRequestsRead,
+		PermAccessRequestsReview,
+		PermAccessPoliciesManage,
+		PermUsersManage,
+		PermJobsManage,
+		PermInstancesRead,
+		PermInstancesWrite,
+	},
+	RoleUser: {
+		PermInstancesRead,
+		PermInstancesWrite,
+	},
+	RoleViewer: {
+		PermInstancesRead,
+	},
+	RolePending: {},
+}
+
+// IsValidRole reports whether role is a known role
+func IsValidRole(role string) bool {
+	_, ok := RolePermissions[role]
+	return ok
+}
+
+// IsValidPermission reports whether permission is granted by any role
+func IsValidPermission(permission string) bool {
+	for _, permissions := range RolePermissions {
+		for _, p := range permissions {
+			if p == permission {
+				return true
+			}
+		}
+	}
+	return false
+}
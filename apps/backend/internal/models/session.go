@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+)
+
+// Session is a signed-in device. It corresponds to one refresh token family
+// and lives until the family expires or is revoked.
+type Session struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	UserID      uint       `json:"userId" gorm:"index;not null"`
+	FamilyID    string     `json:"-" gorm:"uniqueIndex;not null"`
+	DeviceName  string     `json:"device"`
+	UserAgent   string     `json:"userAgent"`
+	IPAddress   string     `json:"ipAddress"`
+	MFAVerified bool       `json:"mfaVerified"`
+	LastSeenAt  time.Time  `json:"lastSeenAt"`
+	ExpiresAt   time.Time  `json:"expiresAt" gorm:"index;not null"`
+	RevokedAt   *time.Time `json:"revokedAt"`
+	CreatedAt   time.Time  `json:"createdAt"`
+}
+
+// TableName specifies the table name for Session
+func (Session) TableName() string {
+	return "user_sessions"
+}
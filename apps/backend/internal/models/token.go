@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+)
+
+// RefreshToken is a server-side record of an issued refresh token.
+// Tokens issued from the same login share a FamilyID so that reuse of an
+// already-rotated token can revoke every descendant at once.
+type RefreshToken struct {
+	ID            uint       `json:"id" gorm:"primaryKey"`
+	UserID        uint       `json:"userId" gorm:"index;not null"`
+	FamilyID      string     `json:"familyId" gorm:"index;not null"`
+	TokenHash     string     `json:"-" gorm:"uniqueIndex;not null"`
+	AccessTokenID string     `json:"-" gorm:"index"` // jti of the access token issued alongside
+	MFAVerified   bool       `json:"mfaVerified"`
+	ExpiresAt     time.Time  `json:"expiresAt" gorm:"not null"`
+	UsedAt        *time.Time `json:"usedAt"`    // Set when the token is rotated
+	RevokedAt     *time.Time `json:"revokedAt"` // Set when the family is revoked
+	CreatedAt     time.Time  `json:"createdAt"`
+}
+
+// TableName specifies the table name for RefreshToken
+func (RefreshToken) TableName() string {
+	return "refresh_tokens"
+}
+
+// RevokedToken is a deny-list entry for an access token ID (jti).
+// Entries can be purged once ExpiresAt has passed.
+type RevokedToken struct {
+	JTI       string    `json:"jti" gorm:"primaryKey"`
+	UserID    uint      `json:"userId" gorm:"index"`
+	ExpiresAt time.Time `json:"expiresAt" gorm:"index;not null"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// TableName specifies the table name for RevokedToken
+func (RevokedToken) TableName() string {
+	return "revoked_tokens"
+}
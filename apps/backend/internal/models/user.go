@@ -1,23 +1,59 @@
 package models
 
 import (
+	"fmt"
 	"time"
 
+	"github.com/lib/pq"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
 type User struct {
-	ID        uint      `json:"id" gorm:"primaryKey"`
-	FirstName string    `json:"firstName" gorm:"not null"`
-	LastName  string    `json:"lastName" gorm:"not null"`
-	Email     string    `json:"email" gorm:"unique;not null"`
-	Phone     string    `json:"phone"`
-	Company   string    `json:"company"`
-	Address   string    `json:"address"`
-	Password  string    `json:"-" gorm:"not null"` // Exclude from JSON responses
-	IsActive  bool      `json:"isActive" gorm:"default:true"`
-	CreatedAt time.Time `json:"createdAt"`
-	UpdatedAt time.Time `json:"updatedAt"`
+	ID                 uint           `json:"id" gorm:"primaryKey"`
+	FirstName          string         `json:"firstName" gorm:"not null"`
+	LastName           string         `json:"lastName" gorm:"not null"`
+	Email              string         `json:"email" gorm:"unique;not null"`
+	Phone              string         `json:"phone"`
+	Company            string         `json:"company"`
+	Address            string         `json:"address"`
+	Password           string         `json:"-" gorm:"not null"` // Exclude from JSON responses
+	IsActive           bool           `json:"isActive" gorm:"default:true"`
+	EmailVerified      bool           `json:"emailVerified" gorm:"default:false"`
+	EmailVerifiedAt    *time.Time     `json:"emailVerifiedAt"`
+	VerificationSentAt *time.Time     `json:"-"` // Last verification email, used for the resend cooldown
+	Role               string         `json:"role" gorm:"not null;default:'user'"`
+	Permissions        pq.StringArray `json:"permissions" gorm:"type:text[]"` // Granted in addition to the role's permissions
+	MFAEnabled         bool           `json:"mfaEnabled" gorm:"default:false"`
+	MFASecret          string         `json:"-"`                     // Base32 TOTP secret, set at enrollment
+	MFALastStep        int64          `json:"-" gorm:"default:0"`    // Last accepted TOTP step, prevents code replay
+	DefaultOrgID       *uint          `json:"defaultOrganizationId"` // Organization used when a request does not name one
+	CreatedAt          time.Time      `json:"createdAt"`
+	UpdatedAt          time.Time      `json:"updatedAt"`
+}
+
+// MigrateUsers creates or updates the users table. Accounts that existed
+// before email verification was added signed up when addresses were not
+// checked; they are marked verified when the column is first added so that
+// requiring a verified address does not lock them out. Run it before
+// AutoMigrate of the other models.
+func MigrateUsers(db *gorm.DB) error {
+	migrator := db.Migrator()
+	predatesVerification := migrator.HasTable(&User{}) && !migrator.HasColumn(&User{}, "EmailVerified")
+
+	if err := db.AutoMigrate(&User{}); err != nil {
+		return fmt.Errorf("failed to migrate users: %w", err)
+	}
+	if !predatesVerification {
+		return nil
+	}
+
+	result := db.Model(&User{}).Where("email_verified = ?", false).
+		UpdateColumns(map[string]interface{}{"email_verified": true, "email_verified_at": time.Now()})
+	if result.Error != nil {
+		return fmt.Errorf("failed to mark existing users verified: %w", result.Error)
+	}
+	return nil
 }
 
 // SetPassword hashes and sets the user's password
@@ -35,3 +71,43 @@ func (u *User) CheckPassword(password string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password))
 	return err == nil
 }
+
+// EffectivePermissions returns the permissions granted by the user's role
+// combined with any extra permissions assigned directly
+func (u *User) EffectivePermissions() []string {
+	seen := make(map[string]bool)
+	var permissions []string
+	for _, p := range append(append([]string{}, RolePermissions[u.Role]...), u.Permissions...) {
+		if !seen[p] {
+			seen[p] = true
+			permissions = append(permissions, p)
+		}
+	}
+	return permissions
+}
+
+// HasPermission reports whether the user's role or extra permissions grant
+// permission
+func (u *User) HasPermission(permission string) bool {
+	for _, p := range u.EffectivePermissions() {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// IsPrivileged reports whether the user holds any permission beyond those of
+// the plain user role, such as reviewing requests or managing users
+func (u *User) IsPrivileged() bool {
+	base := make(map[string]bool)
+	for _, p := range RolePermissions[RoleUser] {
+		base[p] = true
+	}
+	for _, p := range u.EffectivePermissions() {
+		if !base[p] {
+			return true
+		}
+	}
+	return false
+}
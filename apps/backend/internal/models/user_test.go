@@ -0,0 +1,68 @@
+package models
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name())), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
+
+func TestMigrateUsersVerifiesAccountsFromBeforeVerification(t *testing.T) {
+	db := openTestDB(t)
+	// The users table as it was before email verification
+	if err := db.Exec(`CREATE TABLE users (
+		id INTEGER PRIMARY KEY AUTOINCREMENT, first_name TEXT NOT NULL, last_name TEXT NOT NULL,
+		email TEXT UNIQUE NOT NULL, password TEXT NOT NULL, role TEXT NOT NULL DEFAULT 'user'
+	)`).Error; err != nil {
+		t.Fatal(err)
+	}
+	db.Exec(`INSERT INTO users (first_name, last_name, email, password) VALUES ('Old', 'User', 'old@example.com', 'x')`)
+
+	if err := MigrateUsers(db); err != nil {
+		t.Fatal(err)
+	}
+	var old User
+	db.Where("email = ?", "old@example.com").First(&old)
+	if !old.EmailVerified || old.EmailVerifiedAt == nil {
+		t.Fatalf("existing account not marked verified: %+v", old)
+	}
+
+	// Later runs leave new sign-ups alone
+	db.Create(&User{FirstName: "New", LastName: "User", Email: "new@example.com", Password: "x"})
+	if err := MigrateUsers(db); err != nil {
+		t.Fatal(err)
+	}
+	var fresh User
+	db.Where("email = ?", "new@example.com").First(&fresh)
+	if fresh.EmailVerified {
+		t.Fatal("account created after verification was added marked verified")
+	}
+}
+
+func TestMigrateUsersOnEmptyDatabase(t *testing.T) {
+	db := openTestDB(t)
+	if err := MigrateUsers(db); err != nil {
+		t.Fatal(err)
+	}
+	if !db.Migrator().HasColumn(&User{}, "EmailVerified") {
+		t.Fatal("users table created without email_verified")
+	}
+}
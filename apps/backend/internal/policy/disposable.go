@@ -0,0 +1,56 @@
+package policy
+
+import (
+	"strings"
+)
+
+// disposableDomains are well-known throwaway email providers. Admins can add
+// more through the values of a disposable_email rule.
+var disposableDomains = map[string]bool{
+	"10minutemail.com":  true,
+	"burnermail.io":     true,
+	"discard.email":     true,
+	"dispostable.com":   true,
+	"emailfake.com":     true,
+	"emailondeck.com":   true,
+	"fakeinbox.com":     true,
+	"getairmail.com":    true,
+	"getnada.com":       true,
+	"guerrillamail.com": true,
+	"guerrillamail.net": true,
+	"inboxkitten.com":   true,
+	"mail.tm":           true,
+	"maildrop.cc":       true,
+	"mailinator.com":    true,
+	"mailnesia.com":     true,
+	"mintemail.com":     true,
+	"moakt.com":         true,
+	"mohmal.com":        true,
+	"mytemp.email":      true,
+	"sharklasers.com":   true,
+	"spamgourmet.com":   true,
+	"temp-mail.org":     true,
+	"tempmail.com":      true,
+	"tempmailo.com":     true,
+	"tempr.email":       true,
+	"throwawaymail.com": true,
+	"trashmail.com":     true,
+	"yopmail.com":       true,
+	"yopmail.net":       true,
+}
+
+// IsDisposableDomain reports whether the domain, or a parent domain, is a
+// known disposable email provider
+func IsDisposableDomain(domain string) bool {
+	for domain != "" {
+		if disposableDomains[domain] {
+			return true
+		}
+		dot := strings.IndexByte(domain, '.')
+		if dot < 0 {
+			return false
+		}
+		domain = domain[dot+1:]
+	}
+	return false
+}
@@ -0,0 +1,157 @@
+// Package policy evaluates the admin-managed rules that can decide an access
+// request as soon as it is submitted.
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/models"
+)
+
+// Decisions returned by Evaluate. DecisionReview means no rule matched and
+// the request waits for a reviewer.
+const (
+	DecisionApprove = models.PolicyActionApprove
+	DecisionReject  = models.PolicyActionReject
+	DecisionFlag    = models.PolicyActionFlag
+	DecisionReview  = "review"
+)
+
+// Result is the outcome of evaluating the rules against a request
+type Result struct {
+	Decision string                    `json:"decision"`
+	Rule     *models.AccessPolicyRule  `json:"rule"`    // The rule that decided, nil for DecisionReview
+	Matched  []models.AccessPolicyRule `json:"matched"` // Every enabled rule that matched, in evaluation order
+}
+
+// Evaluate checks the enabled rules against the request in ascending priority,
+// ties broken by ID. The first matching rule decides.
+func Evaluate(rules []models.AccessPolicyRule, req *models.AccessRequest) Result {
+	ordered := make([]models.AccessPolicyRule, 0, len(rules))
+	for _, rule := range rules {
+		if rule.Enabled {
+			ordered = append(ordered, rule)
+		}
+	}
+	sort.SliceStable(ordered, func(i, j int) bool {
+		if ordered[i].Priority != ordered[j].Priority {
+			return ordered[i].Priority < ordered[j].Priority
+		}
+		return ordered[i].ID < ordered[j].ID
+	})
+
+	result := Result{Decision: DecisionReview, Matched: []models.AccessPolicyRule{}}
+	for _, rule := range ordered {
+		if !Matches(rule, req) {
+			continue
+		}
+		result.Matched = append(result.Matched, rule)
+		if result.Rule == nil {
+			decided := rule
+			result.Rule = &decided
+			result.Decision = rule.Action
+		}
+	}
+	return result
+}
+
+// Matches reports whether a single rule fires for the request
+func Matches(rule models.AccessPolicyRule, req *models.AccessRequest) bool {
+	var matched bool
+	switch rule.Type {
+	case models.PolicyRuleEmailDomain:
+		matched = domainIn(EmailDomain(req.Email), rule.Values)
+	case models.PolicyRuleDisposableEmail:
+		domain := EmailDomain(req.Email)
+		matched = IsDisposableDomain(domain) || domainIn(domain, rule.Values)
+	case models.PolicyRuleCountry:
+		matched = containsFold(rule.Values, strings.TrimSpace(req.Country))
+	case models.PolicyRuleReasonKeyword:
+		reason := strings.ToLower(req.BusinessReason)
+		for _, keyword := range rule.Values {
+			if keyword = strings.ToLower(strings.TrimSpace(keyword)); keyword != "" && strings.Contains(reason, keyword) {
+				matched = true
+				break
+			}
+		}
+	default:
+		return false
+	}
+	return matched != rule.Negate
+}
+
+// Validate checks that a rule is well formed before it is saved
+func Validate(rule *models.AccessPolicyRule) error {
+	switch rule.Type {
+	case models.PolicyRuleEmailDomain, models.PolicyRuleCountry, models.PolicyRuleReasonKeyword:
+		if len(rule.Values) == 0 {
+			return fmt.Errorf("%s rules need at least one value", rule.Type)
+		}
+	case models.PolicyRuleDisposableEmail:
+		// Values are optional extra domains
+	default:
+		return fmt.Errorf("unknown rule type %q", rule.Type)
+	}
+
+	switch rule.Action {
+	case models.PolicyActionApprove, models.PolicyActionReject, models.PolicyActionFlag:
+	default:
+		return fmt.Errorf("unknown action %q", rule.Action)
+	}
+
+	// A negated disposable check would approve every ordinary address
+	if rule.Type == models.PolicyRuleDisposableEmail && rule.Negate && rule.Action == models.PolicyActionApprove {
+		return errors.New("negated disposable_email rules cannot approve")
+	}
+
+	for i, value := range rule.Values {
+		rule.Values[i] = strings.TrimSpace(value)
+		if rule.Values[i] == "" {
+			return errors.New("rule values cannot be empty")
+		}
+	}
+	return nil
+}
+
+// EmailDomain returns the lower-cased domain of an email address
+func EmailDomain(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSpace(email[at+1:]))
+}
+
+// SameEmailDomain reports whether two addresses belong to the same domain,
+// counting subdomains as the same
+func SameEmailDomain(a, b string) bool {
+	domainA, domainB := EmailDomain(a), EmailDomain(b)
+	return domainIn(domainA, []string{domainB}) || domainIn(domainB, []string{domainA})
+}
+
+// domainIn reports whether domain is one of the listed domains or a subdomain
+// of one
+func domainIn(domain string, domains []string) bool {
+	if domain == "" {
+		return false
+	}
+	for _, d := range domains {
+		d = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(d), "@"))
+		if d != "" && (domain == d || strings.HasSuffix(domain, "."+d)) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(strings.TrimSpace(v), value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/models"
+)
+
+func TestMatches(t *testing.T) {
+	req := &models.AccessRequest{
+		Email:          "Dev@Eng.Acme.com",
+		Country:        " Germany ",
+		BusinessReason: "We need a staging cluster for our CRYPTO wallet",
+	}
+
+	for _, tc := range []struct {
+		name string
+		rule models.AccessPolicyRule
+		want bool
+	}{
+		{"domain", models.AccessPolicyRule{Type: models.PolicyRuleEmailDomain, Values: []string{"eng.acme.com"}}, true},
+		{"parent domain", models.AccessPolicyRule{Type: models.PolicyRuleEmailDomain, Values: []string{"@ACME.com"}}, true},
+		{"lookalike domain", models.AccessPolicyRule{Type: models.PolicyRuleEmailDomain, Values: []string{"me.com"}}, false},
+		{"other domain", models.AccessPolicyRule{Type: models.PolicyRuleEmailDomain, Values: []string{"example.com"}}, false},
+		{"extra disposable domain", models.AccessPolicyRule{Type: models.PolicyRuleDisposableEmail, Values: []string{"acme.com"}}, true},
+		{"not disposable", models.AccessPolicyRule{Type: models.PolicyRuleDisposableEmail}, false},
+		{"country", models.AccessPolicyRule{Type: models.PolicyRuleCountry, Values: []string{"germany"}}, true},
+		{"other country", models.AccessPolicyRule{Type: models.PolicyRuleCountry, Values: []string{"France"}}, false},
+		{"keyword", models.AccessPolicyRule{Type: models.PolicyRuleReasonKeyword, Values: []string{"mining", " crypto "}}, true},
+		{"blank keyword", models.AccessPolicyRule{Type: models.PolicyRuleReasonKeyword, Values: []string{" "}}, false},
+		{"negated allow list", models.AccessPolicyRule{Type: models.PolicyRuleCountry, Values: []string{"France"}, Negate: true}, true},
+		{"negated match", models.AccessPolicyRule{Type: models.PolicyRuleCountry, Values: []string{"Germany"}, Negate: true}, false},
+		{"unknown type", models.AccessPolicyRule{Type: "ip_range", Values: []string{"0.0.0.0/0"}}, false},
+		{"negated unknown type", models.AccessPolicyRule{Type: "ip_range", Negate: true}, false},
+	} {
+		if got := Matches(tc.rule, req); got != tc.want {
+			t.Errorf("%s: Matches = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+
+	if !Matches(models.AccessPolicyRule{Type: models.PolicyRuleDisposableEmail}, &models.AccessRequest{Email: "x@mail.10minutemail.com"}) {
+		t.Error("subdomain of a disposable provider not matched")
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	req := &models.AccessRequest{Email: "dev@acme.com", Country: "Germany"}
+	acme := []string{"acme.com"}
+
+	rules := []models.AccessPolicyRule{
+		{ID: 1, Name: "late approve", Type: models.PolicyRuleEmailDomain, Values: acme, Action: models.PolicyActionApprove, Priority: 50, Enabled: true},
+		{ID: 2, Name: "disabled reject", Type: models.PolicyRuleEmailDomain, Values: acme, Action: models.PolicyActionReject, Priority: 1, Enabled: false},
+		{ID: 4, Name: "tied flag", Type: models.PolicyRuleCountry, Values: []string{"Germany"}, Action: models.PolicyActionFlag, Priority: 10, Enabled: true},
+		{ID: 3, Name: "tied reject", Type: models.PolicyRuleEmailDomain, Values: acme, Action: models.PolicyActionReject, Priority: 10, Enabled: true},
+		{ID: 5, Name: "no match", Type: models.PolicyRuleCountry, Values: []string{"France"}, Action: models.PolicyActionReject, Priority: 0, Enabled: true},
+	}
+
+	result := Evaluate(rules, req)
+	if result.Decision != DecisionReject || result.Rule == nil || result.Rule.ID != 3 {
+		t.Fatalf("decided by %+v with %s, want rule 3 to reject", result.Rule, result.Decision)
+	}
+	var matched []uint
+	for _, rule := range result.Matched {
+		matched = append(matched, rule.ID)
+	}
+	if len(matched) != 3 || matched[0] != 3 || matched[1] != 4 || matched[2] != 1 {
+		t.Fatalf("matched rules %v, want [3 4 1]", matched)
+	}
+
+	none := Evaluate(rules, &models.AccessRequest{Email: "dev@example.com", Country: "Spain"})
+	if none.Decision != DecisionReview || none.Rule != nil || len(none.Matched) != 0 {
+		t.Fatalf("no matching rule gave %+v", none)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		rule  models.AccessPolicyRule
+		valid bool
+	}{
+		{"domain", models.AccessPolicyRule{Type: models.PolicyRuleEmailDomain, Values: []string{" acme.com "}, Action: models.PolicyActionApprove}, true},
+		{"disposable without values", models.AccessPolicyRule{Type: models.PolicyRuleDisposableEmail, Action: models.PolicyActionReject}, true},
+		{"no values", models.AccessPolicyRule{Type: models.PolicyRuleCountry, Action: models.PolicyActionFlag}, false},
+		{"blank value", models.AccessPolicyRule{Type: models.PolicyRuleCountry, Values: []string{"Germany", " "}, Action: models.PolicyActionFlag}, false},
+		{"unknown type", models.AccessPolicyRule{Type: "ip_range", Values: []string{"10.0.0.0/8"}, Action: models.PolicyActionFlag}, false},
+		{"unknown action", models.AccessPolicyRule{Type: models.PolicyRuleCountry, Values: []string{"Germany"}, Action: "escalate"}, false},
+		{"negated disposable approve", models.AccessPolicyRule{Type: models.PolicyRuleDisposableEmail, Negate: true, Action: models.PolicyActionApprove}, false},
+		{"negated disposable flag", models.AccessPolicyRule{Type: models.PolicyRuleDisposableEmail, Negate: true, Action: models.PolicyActionFlag}, true},
+	} {
+		err := Validate(&tc.rule)
+		if tc.valid && err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("%s: accepted", tc.name)
+		}
+	}
+
+	rule := models.AccessPolicyRule{Type: models.PolicyRuleEmailDomain, Values: []string{" acme.com "}, Action: models.PolicyActionApprove}
+	if err := Validate(&rule); err != nil || rule.Values[0] != "acme.com" {
+		t.Fatalf("values not trimmed: %q, %v", rule.Values, err)
+	}
+}
@@ -1,42 +1,56 @@
 package services
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"time"
 
 	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/jobs"
 )
 
+// ErrInstanceNotFound is returned for instances that do not exist in the
+// caller's organization
+var ErrInstanceNotFound = errors.New("instance not found")
+
 type CloudService struct {
-	db    *sql.DB
-	mongo *mongo.Client
+	db      *sql.DB
+	mongo   *mongo.Client
+	drivers *DriverRegistry
+	queue   *jobs.Queue
 }
 
 type Instance struct {
-	ID          string    `json:"id"`
-	Name        string    `json:"name"`
-	Type        string    `json:"type"`
-	Status      string    `json:"status"`
-	Provider    string    `json:"provider"`
-	Region      string    `json:"region"`
-	CPU         int       `json:"cpu"`
-	Memory      int       `json:"memory"`
-	Storage     int       `json:"storage"`
-	UserID      string    `json:"user_id"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID             string    `json:"id"`
+	Name           string    `json:"name"`
+	Type           string    `json:"type"`
+	Status         string    `json:"status"`
+	StatusReason   string    `json:"status_reason"` // Why the instance is in error
+	Provider       string    `json:"provider"`
+	ProviderID     string    `json:"provider_id"` // The provider's resource ID, set once it accepted the instance
+	Region         string    `json:"region"`
+	CPU            int       `json:"cpu"`
+	Memory         int       `json:"memory"`
+	Storage        int       `json:"storage"`
+	UserID         string    `json:"user_id"`
+	OrganizationID uint      `json:"organization_id"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
 }
 
 type CreateInstanceRequest struct {
-	Name     string `json:"name"`
-	Type     string `json:"type"`
-	Provider string `json:"provider"`
-	Region   string `json:"region"`
-	CPU      int    `json:"cpu"`
-	Memory   int    `json:"memory"`
-	Storage  int    `json:"storage"`
-	UserID   string `json:"user_id"`
+	Name           string `json:"name"`
+	Type           string `json:"type"`
+	Provider       string `json:"provider"`
+	Region         string `json:"region"`
+	CPU            int    `json:"cpu"`
+	Memory         int    `json:"memory"`
+	Storage        int    `json:"storage"`
+	UserID         string `json:"user_id"`
+	OrganizationID uint   `json:"-"` // Set from the caller's current organization
 }
 
 type Service struct {
@@ -49,54 +63,114 @@ type Service struct {
 	CreatedAt   time.Time `json:"created_at"`
 }
 
-func NewCloudService(db *sql.DB, mongo *mongo.Client) *CloudService {
-	return &CloudService{
-		db:    db,
-		mongo: mongo,
+// NewCloudService creates the service and registers its instance jobs with
+// queue
+func NewCloudService(db *sql.DB, mongo *mongo.Client, drivers *DriverRegistry, queue *jobs.Queue) *CloudService {
+	s := &CloudService{
+		db:      db,
+		mongo:   mongo,
+		drivers: drivers,
+		queue:   queue,
 	}
+	s.registerJobs()
+	return s
 }
 
+// Migrate creates the instances table and adds the columns introduced since
+// it was first deployed
+func (s *CloudService) Migrate() error {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS instances (
+			id VARCHAR(255) PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			type VARCHAR(100) NOT NULL,
+			status VARCHAR(50) NOT NULL DEFAULT 'creating',
+			provider VARCHAR(50) NOT NULL,
+			region VARCHAR(100) NOT NULL,
+			cpu INTEGER NOT NULL,
+			memory INTEGER NOT NULL,
+			storage INTEGER NOT NULL,
+			user_id VARCHAR(255) NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		)`,
+		`ALTER TABLE instances ADD COLUMN IF NOT EXISTS organization_id BIGINT`,
+		`CREATE INDEX IF NOT EXISTS idx_instances_organization_id ON instances(organization_id)`,
+		// Instances created before organizations belong to their owner's
+		// personal organization, where the owner has one yet
+		`UPDATE instances i SET organization_id = (
+			SELECT MIN(o.id) FROM organizations o WHERE o.personal AND o.created_by_id::text = i.user_id
+		) WHERE i.organization_id IS NULL`,
+		`ALTER TABLE instances ADD COLUMN IF NOT EXISTS provider_id VARCHAR(255) NOT NULL DEFAULT ''`,
+		`ALTER TABLE instances ADD COLUMN IF NOT EXISTS status_reason TEXT NOT NULL DEFAULT ''`,
+	}
+
+	for _, statement := range statements {
+		if _, err := s.db.Exec(statement); err != nil {
+			return fmt.Errorf("failed to migrate instances: %w", err)
+		}
+	}
+	return nil
+}
+
+// CreateInstance records an instance and queues its provisioning through the
+// driver for its provider. The instance is returned while still creating; it
+// becomes running, or error once the job gives up.
 func (s *CloudService) CreateInstance(req CreateInstanceRequest) (*Instance, error) {
+	if _, err := s.drivers.Driver(req.Provider); err != nil {
+		return nil, err
+	}
+
 	instance := &Instance{
-		ID:        generateInstanceID(),
-		Name:      req.Name,
-		Type:      req.Type,
-		Status:    "creating",
-		Provider:  req.Provider,
-		Region:    req.Region,
-		CPU:       req.CPU,
-		Memory:    req.Memory,
-		Storage:   req.Storage,
-		UserID:    req.UserID,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+		ID:             generateInstanceID(),
+		Name:           req.Name,
+		Type:           req.Type,
+		Status:         InstanceStatusCreating,
+		Provider:       req.Provider,
+		Region:         req.Region,
+		CPU:            req.CPU,
+		Memory:         req.Memory,
+		Storage:        req.Storage,
+		UserID:         req.UserID,
+		OrganizationID: req.OrganizationID,
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
 	}
 
 	query := `
-		INSERT INTO instances (id, name, type, status, provider, region, cpu, memory, storage, user_id, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		INSERT INTO instances (id, name, type, status, provider, region, cpu, memory, storage, user_id, organization_id, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
 	`
-	
-	_, err := s.db.Exec(query, instance.ID, instance.Name, instance.Type, instance.Status,
-		instance.Provider, instance.Region, instance.CPU, instance.Memory, instance.Storage,
-		instance.UserID, instance.CreatedAt, instance.UpdatedAt)
+
+	// The job is queued in the same transaction, so an instance is never left
+	// creating without one
+	err := s.inTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(query, instance.ID, instance.Name, instance.Type, instance.Status,
+			instance.Provider, instance.Region, instance.CPU, instance.Memory, instance.Storage,
+			instance.UserID, instance.OrganizationID, instance.CreatedAt, instance.UpdatedAt)
+		if err != nil {
+			return err
+		}
+		_, err = s.queue.Enqueue(context.Background(), tx, JobProvisionInstance, instanceJob{InstanceID: instance.ID})
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create instance: %w", err)
 	}
 
-	// TODO: Actually provision the instance in the cloud provider
-	go s.provisionInstance(instance)
-
 	return instance, nil
 }
 
-func (s *CloudService) ListInstances(userID string) ([]*Instance, error) {
-	query := `
-		SELECT id, name, type, status, provider, region, cpu, memory, storage, user_id, created_at, updated_at
-		FROM instances WHERE user_id = $1 ORDER BY created_at DESC
-	`
-	
-	rows, err := s.db.Query(query, userID)
+// Providers returns the providers instances can be created at
+func (s *CloudService) Providers() []string {
+	return s.drivers.Providers()
+}
+
+// ListInstances returns the instances owned by an organization
+func (s *CloudService) ListInstances(organizationID uint) ([]*Instance, error) {
+	query := `SELECT ` + instanceColumns + ` FROM instances WHERE organization_id = $1 ORDER BY created_at DESC`
+
+	rows, err := s.db.Query(query, organizationID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list instances: %w", err)
 	}
@@ -104,10 +178,7 @@ func (s *CloudService) ListInstances(userID string) ([]*Instance, error) {
 
 	var instances []*Instance
 	for rows.Next() {
-		instance := &Instance{}
-		err := rows.Scan(&instance.ID, &instance.Name, &instance.Type, &instance.Status,
-			&instance.Provider, &instance.Region, &instance.CPU, &instance.Memory,
-			&instance.Storage, &instance.UserID, &instance.CreatedAt, &instance.UpdatedAt)
+		instance, err := scanInstance(rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan instance: %w", err)
 		}
@@ -117,26 +188,96 @@ func (s *CloudService) ListInstances(userID string) ([]*Instance, error) {
 	return instances, nil
 }
 
-func (s *CloudService) DeleteInstance(id string, userID string) error {
-	query := `DELETE FROM instances WHERE id = $1 AND user_id = $2`
-	result, err := s.db.Exec(query, id, userID)
+// DeleteInstance marks an instance owned by the organization as deleting and
+// queues its removal at the provider. The record is dropped once the provider
+// has let go of the instance.
+func (s *CloudService) DeleteInstance(id string, organizationID uint) error {
+	_, err := s.changeInstance(id, organizationID, InstanceStatusDeleting, JobDeleteInstance, nil)
+	return err
+}
+
+// DBTX runs statements on a *sql.DB, a *sql.Tx or the connection of a gorm
+// transaction
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// DeleteUserInstances marks every instance a user created in their personal
+// organization, or before organizations existed, as deleting and queues its
+// removal. Instances they created in shared organizations belong to those
+// organizations and are kept. Pass the connection of the transaction that
+// erases the user so both happen or neither does.
+func (s *CloudService) DeleteUserInstances(ctx context.Context, db DBTX, userID uint) (int64, error) {
+	rows, err := db.QueryContext(ctx, `
+		UPDATE instances SET status = $1, status_reason = '', updated_at = $2
+		WHERE user_id = $3 AND status <> $1 AND (organization_id IS NULL OR organization_id IN (
+			SELECT id FROM organizations WHERE personal AND created_by_id = $4
+		))
+		RETURNING id`,
+		InstanceStatusDeleting, time.Now(), fmt.Sprint(userID), userID)
 	if err != nil {
-		return fmt.Errorf("failed to delete instance: %w", err)
+		return 0, fmt.Errorf("failed to delete instances of user %d: %w", userID, err)
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
 	}
 
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return fmt.Errorf("failed to get rows affected: %w", err)
+	for _, id := range ids {
+		if _, err := s.queue.Enqueue(ctx, db, JobDeleteInstance, instanceJob{InstanceID: id}); err != nil {
+			return 0, err
+		}
 	}
+	return int64(len(ids)), nil
+}
+
+// instanceColumns lists the columns scanInstance reads, in order
+const instanceColumns = `id, name, type, status, status_reason, provider, provider_id, region, cpu, memory, storage, user_id, organization_id, created_at, updated_at`
 
-	if rowsAffected == 0 {
-		return fmt.Errorf("instance not found or unauthorized")
+// scanner is a *sql.Row or *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanInstance reads a row of instanceColumns. An instance created before
+// organizations whose owner has no organization yet has a NULL
+// organization_id, read as 0.
+func scanInstance(row scanner) (*Instance, error) {
+	instance := &Instance{}
+	var organizationID sql.NullInt64
+	err := row.Scan(&instance.ID, &instance.Name, &instance.Type, &instance.Status, &instance.StatusReason,
+		&instance.Provider, &instance.ProviderID, &instance.Region, &instance.CPU, &instance.Memory,
+		&instance.Storage, &instance.UserID, &organizationID, &instance.CreatedAt, &instance.UpdatedAt)
+	if err != nil {
+		return nil, err
 	}
+	instance.OrganizationID = uint(organizationID.Int64)
+	return instance, nil
+}
 
-	// TODO: Actually delete the instance from the cloud provider
-	go s.destroyInstance(id)
+// inTx runs fn in a transaction, committing if it returns nil
+func (s *CloudService) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	return nil
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 func (s *CloudService) ListServices() ([]*Service, error) {
@@ -182,23 +323,6 @@ func (s *CloudService) ListServices() ([]*Service, error) {
 	return services, nil
 }
 
-func (s *CloudService) provisionInstance(instance *Instance) {
-	// Simulate provisioning delay
-	time.Sleep(30 * time.Second)
-	
-	// Update status to running
-	query := `UPDATE instances SET status = 'running', updated_at = $1 WHERE id = $2`
-	s.db.Exec(query, time.Now(), instance.ID)
-}
-
-func (s *CloudService) destroyInstance(instanceID string) {
-	// Simulate destruction delay
-	time.Sleep(10 * time.Second)
-	
-	// Instance should already be deleted from database
-	// This would handle cloud provider cleanup
-}
-
 func generateInstanceID() string {
 	return fmt.Sprintf("inst_%d", time.Now().UnixNano())
 }
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+
+	_ "github.com/lib/pq"
+
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/jobs"
+)
+
+// newTestCloudService returns a cloud service on empty instances and jobs
+// tables in the database at TEST_DATABASE_URL
+func newTestCloudService(t *testing.T) *CloudService {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	// Normally created by the models' migration
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS organizations (
+		id BIGSERIAL PRIMARY KEY,
+		name TEXT NOT NULL,
+		personal BOOLEAN DEFAULT FALSE,
+		created_by_id BIGINT,
+		created_at TIMESTAMP WITH TIME ZONE,
+		updated_at TIMESTAMP WITH TIME ZONE
+	)`); err != nil {
+		t.Fatal(err)
+	}
+
+	queue := jobs.NewQueue(db, jobs.ConfigFromEnv())
+	if err := queue.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	s := NewCloudService(db, nil, NewDriverRegistry(), queue)
+	if err := s.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range []string{"jobs", "instances", "organizations"} {
+		if _, err := db.Exec(`DELETE FROM ` + table); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+func TestDeleteUserInstancesKeepsSharedOrganizations(t *testing.T) {
+	s := newTestCloudService(t)
+	ctx := context.Background()
+
+	var personal, shared int64
+	if err := s.db.QueryRow(`INSERT INTO organizations (name, personal, created_by_id) VALUES ('Personal', TRUE, 7) RETURNING id`).Scan(&personal); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.db.QueryRow(`INSERT INTO organizations (name, personal, created_by_id) VALUES ('Team', FALSE, 7) RETURNING id`).Scan(&shared); err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range []struct {
+		id     string
+		userID string
+		org    interface{}
+	}{
+		{"inst-personal", "7", personal},
+		{"inst-legacy", "7", nil},
+		{"inst-shared", "7", shared},
+		{"inst-other", "8", personal},
+	} {
+		if _, err := s.db.Exec(`INSERT INTO instances (id, name, type, status, provider, region, cpu, memory, storage, user_id, organization_id)
+			VALUES ($1, $1, 'small', $2, 'aws', 'us-east-1', 1, 1, 10, $3, $4)`,
+			row.id, InstanceStatusRunning, row.userID, row.org); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deleted, err := s.DeleteUserInstances(ctx, s.db, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 2 {
+		t.Fatalf("deleted %d instances, want 2", deleted)
+	}
+
+	for id, want := range map[string]string{
+		"inst-personal": InstanceStatusDeleting,
+		"inst-legacy":   InstanceStatusDeleting,
+		"inst-shared":   InstanceStatusRunning,
+		"inst-other":    InstanceStatusRunning,
+	} {
+		var status string
+		if err := s.db.QueryRow(`SELECT status FROM instances WHERE id = $1`, id).Scan(&status); err != nil {
+			t.Fatal(err)
+		}
+		if status != want {
+			t.Errorf("%s is %s, want %s", id, status, want)
+		}
+	}
+
+	queued, err := s.queue.List(ctx, jobs.ListFilter{Kind: JobDeleteInstance, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queued) != 2 {
+		t.Fatalf("queued %d delete jobs, want 2", len(queued))
+	}
+}
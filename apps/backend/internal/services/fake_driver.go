@@ -0,0 +1,211 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ErrFakeProviderFailure is the error the fake driver returns for a
+// simulated provider outage
+var ErrFakeProviderFailure = errors.New("fake provider: simulated failure")
+
+// FakeDriver is an in-process ProviderDriver for development and tests. It
+// keeps instances in memory, delays each call and can fail calls and boots at
+// random, so callers can be exercised against a slow, unreliable provider
+// without any cloud account.
+type FakeDriver struct {
+	callLatency time.Duration // Upper bound of the random delay added to each call
+	bootTime    time.Duration // How long an instance stays pending or stopping
+	failureRate float64       // Chance, from 0 to 1, that a call fails or a boot ends in failed
+
+	mu        sync.Mutex
+	rand      *rand.Rand
+	nextID    int
+	instances map[string]*fakeInstance
+}
+
+type fakeInstance struct {
+	spec      InstanceSpec
+	state     string
+	message   string
+	changedAt time.Time
+	bootFails bool // Decided at creation so Describe answers consistently
+}
+
+func NewFakeDriver(callLatency, bootTime time.Duration, failureRate float64) *FakeDriver {
+	return &FakeDriver{
+		callLatency: callLatency,
+		bootTime:    bootTime,
+		failureRate: failureRate,
+		rand:        rand.New(rand.NewSource(time.Now().UnixNano())),
+		instances:   make(map[string]*fakeInstance),
+	}
+}
+
+func (d *FakeDriver) Create(ctx context.Context, spec InstanceSpec) (*ProviderInstance, error) {
+	if err := d.call(ctx); err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.nextID++
+	id := fmt.Sprintf("fake-%06d", d.nextID)
+	instance := &fakeInstance{
+		spec:      spec,
+		state:     ProviderStatePending,
+		changedAt: time.Now(),
+		bootFails: d.rand.Float64() < d.failureRate,
+	}
+	d.instances[id] = instance
+	return instance.view(id), nil
+}
+
+func (d *FakeDriver) Describe(ctx context.Context, id string) (*ProviderInstance, error) {
+	if err := d.call(ctx); err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	instance, err := d.find(id)
+	if err != nil {
+		return nil, err
+	}
+	return instance.view(id), nil
+}
+
+func (d *FakeDriver) Delete(ctx context.Context, id string) error {
+	if err := d.call(ctx); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, err := d.find(id); err != nil {
+		return err
+	}
+	delete(d.instances, id)
+	return nil
+}
+
+func (d *FakeDriver) Start(ctx context.Context, id string) error {
+	if err := d.call(ctx); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	instance, err := d.find(id)
+	if err != nil {
+		return err
+	}
+	switch instance.state {
+	case ProviderStateRunning, ProviderStatePending:
+		return nil
+	case ProviderStateStopped:
+		instance.state = ProviderStatePending
+		instance.changedAt = time.Now()
+		instance.bootFails = false
+		return nil
+	default:
+		return fmt.Errorf("fake provider: cannot start instance that is %s", instance.state)
+	}
+}
+
+func (d *FakeDriver) Stop(ctx context.Context, id string) error {
+	if err := d.call(ctx); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	instance, err := d.find(id)
+	if err != nil {
+		return err
+	}
+	switch instance.state {
+	case ProviderStateStopped, ProviderStateStopping:
+		return nil
+	case ProviderStateRunning:
+		instance.state = ProviderStateStopping
+		instance.changedAt = time.Now()
+		return nil
+	default:
+		return fmt.Errorf("fake provider: cannot stop instance that is %s", instance.state)
+	}
+}
+
+func (d *FakeDriver) Resize(ctx context.Context, id string, size InstanceSize) error {
+	if err := d.call(ctx); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	instance, err := d.find(id)
+	if err != nil {
+		return err
+	}
+	if instance.state != ProviderStateStopped {
+		return fmt.Errorf("fake provider: cannot resize instance that is %s", instance.state)
+	}
+	instance.spec.CPU = size.CPU
+	instance.spec.Memory = size.Memory
+	instance.spec.Storage = size.Storage
+	return nil
+}
+
+// call waits a random part of the call latency and fails at the failure rate
+func (d *FakeDriver) call(ctx context.Context) error {
+	d.mu.Lock()
+	delay := time.Duration(d.rand.Int63n(int64(d.callLatency) + 1))
+	fail := d.rand.Float64() < d.failureRate
+	d.mu.Unlock()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+	}
+	if fail {
+		return ErrFakeProviderFailure
+	}
+	return nil
+}
+
+// find returns the instance with its state brought up to date. The caller
+// must hold d.mu.
+func (d *FakeDriver) find(id string) (*fakeInstance, error) {
+	instance, ok := d.instances[id]
+	if !ok {
+		return nil, ErrProviderInstanceNotFound
+	}
+
+	if time.Since(instance.changedAt) >= d.bootTime {
+		switch instance.state {
+		case ProviderStatePending:
+			if instance.bootFails {
+				instance.state = ProviderStateFailed
+				instance.message = "simulated boot failure"
+			} else {
+				instance.state = ProviderStateRunning
+			}
+			instance.changedAt = time.Now()
+		case ProviderStateStopping:
+			instance.state = ProviderStateStopped
+			instance.changedAt = time.Now()
+		}
+	}
+	return instance, nil
+}
+
+func (i *fakeInstance) view(id string) *ProviderInstance {
+	return &ProviderInstance{ID: id, State: i.state, Message: i.message}
+}
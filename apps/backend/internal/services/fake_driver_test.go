@@ -0,0 +1,167 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// createFake creates an instance at the driver and fails the test on error
+func createFake(t *testing.T, d *FakeDriver) string {
+	t.Helper()
+	created, err := d.Create(context.Background(), InstanceSpec{Name: "web", Type: "small", Region: "us-east-1", CPU: 1, Memory: 1, Storage: 10})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if created.State != ProviderStatePending {
+		t.Fatalf("created instance is %s, want %s", created.State, ProviderStatePending)
+	}
+	return created.ID
+}
+
+func describeFake(t *testing.T, d *FakeDriver, id string) string {
+	t.Helper()
+	described, err := d.Describe(context.Background(), id)
+	if err != nil {
+		t.Fatalf("describe: %v", err)
+	}
+	return described.State
+}
+
+func TestFakeDriverLifecycle(t *testing.T) {
+	// With no boot time every pending or stopping instance settles on the
+	// next call
+	d := NewFakeDriver(0, 0, 0)
+	ctx := context.Background()
+	id := createFake(t, d)
+
+	for _, step := range []struct {
+		name string
+		do   func() error
+		want string
+	}{
+		{"boot", func() error { return nil }, ProviderStateRunning},
+		{"stop", func() error { return d.Stop(ctx, id) }, ProviderStateStopped},
+		{"resize", func() error { return d.Resize(ctx, id, InstanceSize{CPU: 4, Memory: 8, Storage: 100}) }, ProviderStateStopped},
+		{"start", func() error { return d.Start(ctx, id) }, ProviderStateRunning},
+	} {
+		if err := step.do(); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if state := describeFake(t, d, id); state != step.want {
+			t.Fatalf("after %s: instance is %s, want %s", step.name, state, step.want)
+		}
+	}
+
+	if err := d.Delete(ctx, id); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err := d.Describe(ctx, id); !errors.Is(err, ErrProviderInstanceNotFound) {
+		t.Fatalf("describe after delete: err %v, want ErrProviderInstanceNotFound", err)
+	}
+}
+
+func TestFakeDriverPreconditions(t *testing.T) {
+	ctx := context.Background()
+	resize := func(d *FakeDriver, id string) error {
+		return d.Resize(ctx, id, InstanceSize{CPU: 2, Memory: 2, Storage: 20})
+	}
+
+	for _, tc := range []struct {
+		name    string
+		state   string
+		op      func(d *FakeDriver, id string) error
+		wantErr bool
+	}{
+		{"start pending", ProviderStatePending, func(d *FakeDriver, id string) error { return d.Start(ctx, id) }, false},
+		{"stop pending", ProviderStatePending, func(d *FakeDriver, id string) error { return d.Stop(ctx, id) }, true},
+		{"resize pending", ProviderStatePending, resize, true},
+		{"start running", ProviderStateRunning, func(d *FakeDriver, id string) error { return d.Start(ctx, id) }, false},
+		{"stop running", ProviderStateRunning, func(d *FakeDriver, id string) error { return d.Stop(ctx, id) }, false},
+		{"resize running", ProviderStateRunning, resize, true},
+		{"start stopping", ProviderStateStopping, func(d *FakeDriver, id string) error { return d.Start(ctx, id) }, true},
+		{"stop stopping", ProviderStateStopping, func(d *FakeDriver, id string) error { return d.Stop(ctx, id) }, false},
+		{"resize stopping", ProviderStateStopping, resize, true},
+		{"start stopped", ProviderStateStopped, func(d *FakeDriver, id string) error { return d.Start(ctx, id) }, false},
+		{"stop stopped", ProviderStateStopped, func(d *FakeDriver, id string) error { return d.Stop(ctx, id) }, false},
+		{"resize stopped", ProviderStateStopped, resize, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// A long boot time holds the instance in the state under test
+			d := NewFakeDriver(0, time.Hour, 0)
+			id := createFake(t, d)
+			d.mu.Lock()
+			d.instances[id].state = tc.state
+			d.mu.Unlock()
+
+			err := tc.op(d, id)
+			if tc.wantErr && err == nil {
+				t.Fatalf("%s accepted", tc.name)
+			}
+			if !tc.wantErr && err != nil {
+				t.Fatalf("%s: %v", tc.name, err)
+			}
+		})
+	}
+}
+
+func TestFakeDriverUnknownInstance(t *testing.T) {
+	d := NewFakeDriver(0, 0, 0)
+	ctx := context.Background()
+
+	for name, op := range map[string]func() error{
+		"describe": func() error { _, err := d.Describe(ctx, "fake-missing"); return err },
+		"delete":   func() error { return d.Delete(ctx, "fake-missing") },
+		"start":    func() error { return d.Start(ctx, "fake-missing") },
+		"stop":     func() error { return d.Stop(ctx, "fake-missing") },
+		"resize":   func() error { return d.Resize(ctx, "fake-missing", InstanceSize{CPU: 1}) },
+	} {
+		if err := op(); !errors.Is(err, ErrProviderInstanceNotFound) {
+			t.Errorf("%s: err %v, want ErrProviderInstanceNotFound", name, err)
+		}
+	}
+}
+
+func TestFakeDriverFailureRate(t *testing.T) {
+	ctx := context.Background()
+
+	reliable := NewFakeDriver(0, 0, 0)
+	for i := 0; i < 50; i++ {
+		id := createFake(t, reliable)
+		if state := describeFake(t, reliable, id); state != ProviderStateRunning {
+			t.Fatalf("instance %s is %s with a failure rate of 0", id, state)
+		}
+	}
+
+	broken := NewFakeDriver(0, 0, 1)
+	for i := 0; i < 50; i++ {
+		if _, err := broken.Create(ctx, InstanceSpec{Name: "web"}); !errors.Is(err, ErrFakeProviderFailure) {
+			t.Fatalf("create: err %v, want ErrFakeProviderFailure", err)
+		}
+	}
+
+	// A boot that fails is reported by Describe
+	flaky := NewFakeDriver(0, 0, 0)
+	id := createFake(t, flaky)
+	flaky.mu.Lock()
+	flaky.instances[id].bootFails = true
+	flaky.mu.Unlock()
+	described, err := flaky.Describe(ctx, id)
+	if err != nil {
+		t.Fatalf("describe: %v", err)
+	}
+	if described.State != ProviderStateFailed || described.Message == "" {
+		t.Fatalf("failed boot described as %+v", described)
+	}
+}
+
+func TestFakeDriverCallHonoursContext(t *testing.T) {
+	d := NewFakeDriver(time.Hour, 0, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := d.Create(ctx, InstanceSpec{Name: "web"}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("create with a cancelled context: err %v, want context.Canceled", err)
+	}
+}
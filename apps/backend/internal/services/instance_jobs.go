@@ -0,0 +1,459 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/jobs"
+)
+
+// Kinds of instance jobs
+const (
+	JobProvisionInstance = "instance.provision"
+	JobDeleteInstance    = "instance.delete"
+	JobStartInstance     = "instance.start"
+	JobStopInstance      = "instance.stop"
+	JobRebootInstance    = "instance.reboot"
+	JobResizeInstance    = "instance.resize"
+)
+
+// jobCancelledReason is the status reason of an instance whose job was
+// cancelled
+const jobCancelledReason = "Operation cancelled by an administrator"
+
+// providerPollInterval is how often a provider is asked for progress
+const providerPollInterval = 2 * time.Second
+
+// instanceJob is the payload of every instance job
+type instanceJob struct {
+	InstanceID string        `json:"instanceId"`
+	Size       *InstanceSize `json:"size,omitempty"`    // Resize only: the new size
+	Restart    bool          `json:"restart,omitempty"` // Resize only: start the instance again afterwards
+}
+
+// registerJobs tells the queue how to run instance jobs. When a job gives up
+// or is cancelled the instance moves to error with the reason; retrying the
+// job moves it back.
+func (s *CloudService) registerJobs() {
+	s.queue.Register(JobProvisionInstance, jobs.Kind{
+		Handle:       s.runProvision,
+		DeadLettered: s.failInstance(InstanceStatusCreating),
+		Cancelled:    s.cancelInstance(InstanceStatusCreating),
+		Requeued:     s.resumeInstance(InstanceStatusCreating),
+	})
+	s.queue.Register(JobDeleteInstance, jobs.Kind{
+		Handle:       s.runDelete,
+		DeadLettered: s.failInstance(InstanceStatusDeleting),
+		Cancelled:    s.cancelInstance(InstanceStatusDeleting),
+		Requeued:     s.resumeInstance(InstanceStatusDeleting),
+	})
+	s.queue.Register(JobStartInstance, jobs.Kind{
+		Handle:       s.runStart,
+		DeadLettered: s.failInstance(InstanceStatusStarting),
+		Cancelled:    s.cancelInstance(InstanceStatusStarting),
+		Requeued:     s.resumeInstance(InstanceStatusStarting),
+	})
+	s.queue.Register(JobStopInstance, jobs.Kind{
+		Handle:       s.runStop,
+		DeadLettered: s.failInstance(InstanceStatusStopping),
+		Cancelled:    s.cancelInstance(InstanceStatusStopping),
+		Requeued:     s.resumeInstance(InstanceStatusStopping),
+	})
+	s.queue.Register(JobRebootInstance, jobs.Kind{
+		Handle:       s.runReboot,
+		DeadLettered: s.failInstance(InstanceStatusRebooting),
+		Cancelled:    s.cancelInstance(InstanceStatusRebooting),
+		Requeued:     s.resumeInstance(InstanceStatusRebooting),
+	})
+	s.queue.Register(JobResizeInstance, jobs.Kind{
+		Handle:       s.runResize,
+		DeadLettered: s.failInstance(InstanceStatusResizing),
+		Cancelled:    s.cancelInstance(InstanceStatusResizing),
+		Requeued:     s.resumeInstance(InstanceStatusResizing),
+	})
+}
+
+// RequeueStuckInstances queues a job for every instance left creating or
+// deleting without one, as happened before provisioning went through the job
+// queue. An instance whose latest job was cancelled before cancelling settled
+// the instance is moved to error instead, so the cancel is not undone. Run it
+// after both Migrate methods.
+func (s *CloudService) RequeueStuckInstances(ctx context.Context) error {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT i.id, i.status, COALESCE(latest.status, '') FROM instances i
+		LEFT JOIN LATERAL (
+			SELECT status FROM jobs WHERE kind IN ($3, $4) AND payload->>'instanceId' = i.id
+			ORDER BY id DESC LIMIT 1
+		) latest ON TRUE
+		WHERE i.status IN ($1, $2) AND (latest.status IS NULL OR latest.status NOT IN ($5, $6))`,
+		InstanceStatusCreating, InstanceStatusDeleting, JobProvisionInstance, JobDeleteInstance, jobs.StatusPending, jobs.StatusRunning)
+	if err != nil {
+		return fmt.Errorf("failed to find stuck instances: %w", err)
+	}
+	defer rows.Close()
+
+	kinds := map[string]string{}
+	var cancelled []string
+	for rows.Next() {
+		var id, status, latest string
+		if err := rows.Scan(&id, &status, &latest); err != nil {
+			return fmt.Errorf("failed to scan stuck instance: %w", err)
+		}
+		switch {
+		case latest == jobs.StatusCancelled:
+			cancelled = append(cancelled, id)
+		case status == InstanceStatusDeleting:
+			kinds[id] = JobDeleteInstance
+		default:
+			kinds[id] = JobProvisionInstance
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range cancelled {
+		if _, err := s.db.ExecContext(ctx, `UPDATE instances SET status = $1, status_reason = $2, updated_at = $3 WHERE id = $4 AND status IN ($5, $6)`,
+			InstanceStatusError, jobCancelledReason, time.Now(), id, InstanceStatusCreating, InstanceStatusDeleting); err != nil {
+			return fmt.Errorf("failed to settle instance %s: %w", id, err)
+		}
+		log.Printf("Moved instance %s to error: its job was cancelled", id)
+	}
+	for id, kind := range kinds {
+		if _, err := s.queue.Enqueue(ctx, s.db, kind, instanceJob{InstanceID: id}); err != nil {
+			return err
+		}
+		log.Printf("Queued %s for stuck instance %s", kind, id)
+	}
+	return nil
+}
+
+// runProvision creates the instance at its provider and waits for it to run.
+// Each step checks what earlier attempts already did, so the job can be
+// retried or resumed after a crash.
+func (s *CloudService) runProvision(ctx context.Context, job *jobs.Job) error {
+	instance, err := s.jobInstance(ctx, job)
+	if errors.Is(err, ErrInstanceNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if instance.Status != InstanceStatusCreating {
+		// Deleted meanwhile; the delete job cleans up
+		return nil
+	}
+
+	driver, err := s.drivers.Driver(instance.Provider)
+	if err != nil {
+		return jobs.Permanent(err)
+	}
+
+	providerID := instance.ProviderID
+	if providerID == "" {
+		created, err := driver.Create(ctx, InstanceSpec{
+			Name:    instance.Name,
+			Type:    instance.Type,
+			Region:  instance.Region,
+			CPU:     instance.CPU,
+			Memory:  instance.Memory,
+			Storage: instance.Storage,
+			Tags:    map[string]string{"addtocloud-instance": instance.ID},
+		})
+		if err != nil {
+			return fmt.Errorf("create at %s: %w", instance.Provider, err)
+		}
+
+		// Only while still creating: if it was deleted meanwhile, nobody
+		// else knows about the resource, so it is removed here
+		result, err := s.db.ExecContext(ctx,
+			`UPDATE instances SET provider_id = $1, updated_at = $2 WHERE id = $3 AND status = $4 AND provider_id = ''`,
+			created.ID, time.Now(), instance.ID, InstanceStatusCreating)
+		if err != nil {
+			return fmt.Errorf("failed to record provider resource %s: %w", created.ID, err)
+		}
+		if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+			if err := driver.Delete(ctx, created.ID); err != nil && !errors.Is(err, ErrProviderInstanceNotFound) {
+				log.Printf("Failed to remove resource %s of deleted instance %s: %v", created.ID, instance.ID, err)
+			}
+			return nil
+		}
+		providerID = created.ID
+	}
+
+	if err := awaitProviderState(ctx, driver, instance.Provider, providerID, ProviderStateRunning); err != nil {
+		return err
+	}
+	return s.finishInstance(ctx, instance.ID, InstanceStatusCreating, InstanceStatusRunning)
+}
+
+// runStart boots a stopped instance and waits for it to run
+func (s *CloudService) runStart(ctx context.Context, job *jobs.Job) error {
+	instance, driver, err := s.lifecycleInstance(ctx, job, InstanceStatusStarting)
+	if instance == nil || err != nil {
+		return err
+	}
+	if err := startAtProvider(ctx, driver, instance); err != nil {
+		return err
+	}
+	return s.finishInstance(ctx, instance.ID, InstanceStatusStarting, InstanceStatusRunning)
+}
+
+// runStop shuts an instance down and waits for it to stop
+func (s *CloudService) runStop(ctx context.Context, job *jobs.Job) error {
+	instance, driver, err := s.lifecycleInstance(ctx, job, InstanceStatusStopping)
+	if instance == nil || err != nil {
+		return err
+	}
+	if err := stopAtProvider(ctx, driver, instance); err != nil {
+		return err
+	}
+	return s.finishInstance(ctx, instance.ID, InstanceStatusStopping, InstanceStatusStopped)
+}
+
+// runReboot stops the instance and starts it again. Providers differ in what
+// a native reboot does, so it is built from the two.
+func (s *CloudService) runReboot(ctx context.Context, job *jobs.Job) error {
+	instance, driver, err := s.lifecycleInstance(ctx, job, InstanceStatusRebooting)
+	if instance == nil || err != nil {
+		return err
+	}
+	if err := stopAtProvider(ctx, driver, instance); err != nil {
+		return err
+	}
+	if err := startAtProvider(ctx, driver, instance); err != nil {
+		return err
+	}
+	return s.finishInstance(ctx, instance.ID, InstanceStatusRebooting, InstanceStatusRunning)
+}
+
+// runResize stops the instance, resizes it and, if it was running before,
+// starts it again. The new size is recorded together with the final status.
+func (s *CloudService) runResize(ctx context.Context, job *jobs.Job) error {
+	var payload instanceJob
+	if err := job.Decode(&payload); err != nil || payload.Size == nil {
+		return jobs.Permanent(errors.New("invalid payload: missing size"))
+	}
+	instance, driver, err := s.lifecycleInstance(ctx, job, InstanceStatusResizing)
+	if instance == nil || err != nil {
+		return err
+	}
+
+	if err := stopAtProvider(ctx, driver, instance); err != nil {
+		return err
+	}
+	if err := driver.Resize(ctx, instance.ProviderID, *payload.Size); err != nil {
+		if errors.Is(err, ErrProviderInstanceNotFound) {
+			return jobs.Permanent(fmt.Errorf("resource %s disappeared at %s", instance.ProviderID, instance.Provider))
+		}
+		return fmt.Errorf("resize at %s: %w", instance.Provider, err)
+	}
+
+	status := InstanceStatusStopped
+	if payload.Restart {
+		if err := startAtProvider(ctx, driver, instance); err != nil {
+			return err
+		}
+		status = InstanceStatusRunning
+	}
+
+	_, err = s.db.ExecContext(ctx, `
+		UPDATE instances SET status = $1, status_reason = '', cpu = $2, memory = $3, storage = $4, updated_at = $5
+		WHERE id = $6 AND status = $7`,
+		status, payload.Size.CPU, payload.Size.Memory, payload.Size.Storage, time.Now(), instance.ID, InstanceStatusResizing)
+	return err
+}
+
+// runDelete removes the instance at its provider, then drops the record
+func (s *CloudService) runDelete(ctx context.Context, job *jobs.Job) error {
+	instance, err := s.jobInstance(ctx, job)
+	if errors.Is(err, ErrInstanceNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if instance.Status != InstanceStatusDeleting {
+		return nil
+	}
+
+	if instance.ProviderID != "" {
+		driver, err := s.drivers.Driver(instance.Provider)
+		if err != nil {
+			return jobs.Permanent(err)
+		}
+		if err := driver.Delete(ctx, instance.ProviderID); err != nil && !errors.Is(err, ErrProviderInstanceNotFound) {
+			return fmt.Errorf("delete at %s: %w", instance.Provider, err)
+		}
+	}
+
+	_, err = s.db.ExecContext(ctx, `DELETE FROM instances WHERE id = $1 AND status = $2`, instance.ID, InstanceStatusDeleting)
+	return err
+}
+
+// lifecycleInstance loads the instance of a start, stop, reboot or resize job
+// along with its driver. It returns a nil instance, and no error, when the
+// instance is gone or no longer in status, so the job has nothing left to do.
+func (s *CloudService) lifecycleInstance(ctx context.Context, job *jobs.Job, status string) (*Instance, ProviderDriver, error) {
+	instance, err := s.jobInstance(ctx, job)
+	if errors.Is(err, ErrInstanceNotFound) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if instance.Status != status {
+		return nil, nil, nil
+	}
+	if instance.ProviderID == "" {
+		return nil, nil, jobs.Permanent(fmt.Errorf("instance %s has no resource at %s", instance.ID, instance.Provider))
+	}
+
+	driver, err := s.drivers.Driver(instance.Provider)
+	if err != nil {
+		return nil, nil, jobs.Permanent(err)
+	}
+	return instance, driver, nil
+}
+
+// finishInstance moves an instance from the status its job worked in to the
+// status the job ends in. Nothing changes if the instance has moved on, e.g.
+// because it is being deleted.
+func (s *CloudService) finishInstance(ctx context.Context, id, from, to string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE instances SET status = $1, status_reason = '', updated_at = $2 WHERE id = $3 AND status = $4`,
+		to, time.Now(), id, from)
+	return err
+}
+
+// startAtProvider starts the instance's resource and waits for it to run.
+// Drivers treat starting a running resource as done, so retries are safe.
+func startAtProvider(ctx context.Context, driver ProviderDriver, instance *Instance) error {
+	if err := driver.Start(ctx, instance.ProviderID); err != nil {
+		if errors.Is(err, ErrProviderInstanceNotFound) {
+			return jobs.Permanent(fmt.Errorf("resource %s disappeared at %s", instance.ProviderID, instance.Provider))
+		}
+		return fmt.Errorf("start at %s: %w", instance.Provider, err)
+	}
+	return awaitProviderState(ctx, driver, instance.Provider, instance.ProviderID, ProviderStateRunning)
+}
+
+// stopAtProvider stops the instance's resource and waits for it to stop
+func stopAtProvider(ctx context.Context, driver ProviderDriver, instance *Instance) error {
+	if err := driver.Stop(ctx, instance.ProviderID); err != nil {
+		if errors.Is(err, ErrProviderInstanceNotFound) {
+			return jobs.Permanent(fmt.Errorf("resource %s disappeared at %s", instance.ProviderID, instance.Provider))
+		}
+		return fmt.Errorf("stop at %s: %w", instance.Provider, err)
+	}
+	return awaitProviderState(ctx, driver, instance.Provider, instance.ProviderID, ProviderStateStopped)
+}
+
+// awaitProviderState waits for the resource to reach want. A resource that
+// disappears or fails makes the job give up.
+func awaitProviderState(ctx context.Context, driver ProviderDriver, provider, providerID, want string) error {
+	described, err := waitForProviderState(ctx, driver, providerID, want)
+	if errors.Is(err, ErrProviderInstanceNotFound) {
+		return jobs.Permanent(fmt.Errorf("resource %s disappeared at %s", providerID, provider))
+	}
+	if err != nil {
+		return err
+	}
+	if described.State == ProviderStateFailed {
+		return jobs.Permanent(fmt.Errorf("%s reported the instance failed: %s", provider, described.Message))
+	}
+	return nil
+}
+
+// failInstance returns a dead-letter hook that moves the job's instance from
+// status to error, with the job's last error as the reason
+func (s *CloudService) failInstance(status string) func(ctx context.Context, job *jobs.Job) {
+	return func(ctx context.Context, job *jobs.Job) {
+		var payload instanceJob
+		if err := job.Decode(&payload); err != nil {
+			return
+		}
+		if _, err := s.db.ExecContext(ctx, `UPDATE instances SET status = $1, status_reason = $2, updated_at = $3 WHERE id = $4 AND status = $5`,
+			InstanceStatusError, job.LastError, time.Now(), payload.InstanceID, status); err != nil {
+			log.Printf("Failed to mark instance %s as error: %v", payload.InstanceID, err)
+		}
+	}
+}
+
+// cancelInstance returns a cancel hook that moves the job's instance from
+// status to error, since nothing will finish the operation any more
+func (s *CloudService) cancelInstance(status string) func(ctx context.Context, tx *sql.Tx, job *jobs.Job) error {
+	return func(ctx context.Context, tx *sql.Tx, job *jobs.Job) error {
+		var payload instanceJob
+		if err := job.Decode(&payload); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `UPDATE instances SET status = $1, status_reason = $2, updated_at = $3 WHERE id = $4 AND status = $5`,
+			InstanceStatusError, jobCancelledReason, time.Now(), payload.InstanceID, status)
+		return err
+	}
+}
+
+// resumeInstance returns a requeue hook that moves the job's instance from
+// error back to status
+func (s *CloudService) resumeInstance(status string) func(ctx context.Context, tx *sql.Tx, job *jobs.Job) error {
+	return func(ctx context.Context, tx *sql.Tx, job *jobs.Job) error {
+		var payload instanceJob
+		if err := job.Decode(&payload); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `UPDATE instances SET status = $1, status_reason = '', updated_at = $2 WHERE id = $3 AND status = $4`,
+			status, time.Now(), payload.InstanceID, InstanceStatusError)
+		return err
+	}
+}
+
+// jobInstance loads the instance a job is about
+func (s *CloudService) jobInstance(ctx context.Context, job *jobs.Job) (*Instance, error) {
+	var payload instanceJob
+	if err := job.Decode(&payload); err != nil {
+		return nil, jobs.Permanent(fmt.Errorf("invalid payload: %w", err))
+	}
+
+	instance, err := scanInstance(s.db.QueryRowContext(ctx, `SELECT `+instanceColumns+` FROM instances WHERE id = $1`, payload.InstanceID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInstanceNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load instance %s: %w", payload.InstanceID, err)
+	}
+	return instance, nil
+}
+
+// waitForProviderState polls the provider until the instance reaches want or
+// failed. Errors from Describe are retried until ctx ends, since providers
+// fail transiently.
+func waitForProviderState(ctx context.Context, driver ProviderDriver, id, want string) (*ProviderInstance, error) {
+	ticker := time.NewTicker(providerPollInterval)
+	defer ticker.Stop()
+
+	var lastErr error
+	for {
+		described, err := driver.Describe(ctx, id)
+		switch {
+		case err == nil && (described.State == want || described.State == ProviderStateFailed):
+			return described, nil
+		case errors.Is(err, ErrProviderInstanceNotFound):
+			return nil, err
+		case err != nil:
+			lastErr = err
+		}
+
+		select {
+		case <-ctx.Done():
+			if lastErr != nil {
+				return nil, fmt.Errorf("timed out waiting for %s: %w", want, lastErr)
+			}
+			return nil, fmt.Errorf("timed out waiting for %s", want)
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Instance statuses. Statuses ending in -ing have a job in the queue that
+// moves the instance on; error is left when that job gives up or is cancelled.
+const (
+	InstanceStatusCreating  = "creating"
+	InstanceStatusRunning   = "running"
+	InstanceStatusStopping  = "stopping"
+	InstanceStatusStopped   = "stopped"
+	InstanceStatusStarting  = "starting"
+	InstanceStatusRebooting = "rebooting"
+	InstanceStatusResizing  = "resizing"
+	InstanceStatusDeleting  = "deleting"
+	InstanceStatusError     = "error"
+)
+
+// instanceOperations lists the statuses a user may move an instance to from
+// each status by asking for an operation. Jobs move instances out of -ing
+// statuses themselves, and an admin retrying a failed job moves its instance
+// from error back to the job's status; neither goes through this table.
+var instanceOperations = map[string][]string{
+	InstanceStatusCreating:  {InstanceStatusDeleting},
+	InstanceStatusRunning:   {InstanceStatusStopping, InstanceStatusRebooting, InstanceStatusResizing, InstanceStatusDeleting},
+	InstanceStatusStopping:  {InstanceStatusDeleting},
+	InstanceStatusStopped:   {InstanceStatusStarting, InstanceStatusResizing, InstanceStatusDeleting},
+	InstanceStatusStarting:  {InstanceStatusDeleting},
+	InstanceStatusRebooting: {InstanceStatusDeleting},
+	InstanceStatusResizing:  {InstanceStatusDeleting},
+	InstanceStatusError: {
+		InstanceStatusDeleting, InstanceStatusStarting, InstanceStatusStopping,
+		InstanceStatusRebooting, InstanceStatusResizing,
+	},
+}
+
+// CanOperateInstance reports whether a user may move an instance from one
+// status to another. An instance that failed before it got a resource at its
+// provider can only be deleted.
+func CanOperateInstance(from, to string, hasResource bool) bool {
+	if from == InstanceStatusError && !hasResource && to != InstanceStatusDeleting {
+		return false
+	}
+	for _, next := range instanceOperations[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	// ErrInvalidInstanceTransition is matched by every InstanceTransitionError
+	ErrInvalidInstanceTransition = errors.New("invalid instance status transition")
+	// ErrInvalidSize is returned for a resize the instance cannot take
+	ErrInvalidSize = errors.New("invalid instance size")
+)
+
+// InstanceTransitionError reports an operation the instance's status does not
+// allow
+type InstanceTransitionError struct {
+	From string
+	To   string
+}
+
+func (e *InstanceTransitionError) Error() string {
+	return fmt.Sprintf("instance cannot move from %s to %s", e.From, e.To)
+}
+
+func (e *InstanceTransitionError) Is(target error) bool {
+	return target == ErrInvalidInstanceTransition
+}
+
+// StartInstance boots a stopped instance
+func (s *CloudService) StartInstance(id string, organizationID uint) (*Instance, error) {
+	return s.changeInstance(id, organizationID, InstanceStatusStarting, JobStartInstance, nil)
+}
+
+// StopInstance shuts a running instance down. Its disk and size are kept.
+func (s *CloudService) StopInstance(id string, organizationID uint) (*Instance, error) {
+	return s.changeInstance(id, organizationID, InstanceStatusStopping, JobStopInstance, nil)
+}
+
+// RebootInstance restarts a running instance
+func (s *CloudService) RebootInstance(id string, organizationID uint) (*Instance, error) {
+	return s.changeInstance(id, organizationID, InstanceStatusRebooting, JobRebootInstance, nil)
+}
+
+// ResizeInstance changes the CPU, memory and storage of an instance. Zero
+// fields keep their current value, and storage can only grow. A running
+// instance is stopped for the resize and started again afterwards.
+func (s *CloudService) ResizeInstance(id string, organizationID uint, size InstanceSize) (*Instance, error) {
+	return s.changeInstance(id, organizationID, InstanceStatusResizing, JobResizeInstance, func(instance *Instance, payload *instanceJob) error {
+		if size.CPU < 0 || size.Memory < 0 || size.Storage < 0 {
+			return fmt.Errorf("%w: sizes must be positive", ErrInvalidSize)
+		}
+		if size.CPU == 0 {
+			size.CPU = instance.CPU
+		}
+		if size.Memory == 0 {
+			size.Memory = instance.Memory
+		}
+		if size.Storage == 0 {
+			size.Storage = instance.Storage
+		}
+		if size.Storage < instance.Storage {
+			return fmt.Errorf("%w: storage cannot shrink below %d", ErrInvalidSize, instance.Storage)
+		}
+		if size == (InstanceSize{CPU: instance.CPU, Memory: instance.Memory, Storage: instance.Storage}) {
+			return fmt.Errorf("%w: instance already has this size", ErrInvalidSize)
+		}
+
+		payload.Size = &size
+		payload.Restart = instance.Status == InstanceStatusRunning
+		return nil
+	})
+}
+
+// changeInstance moves an instance owned by the organization to an -ing
+// status and queues the job that carries the change out, in one transaction.
+// prepare, if set, validates the change and fills in the job payload while
+// the instance is locked.
+func (s *CloudService) changeInstance(id string, organizationID uint, to, kind string, prepare func(*Instance, *instanceJob) error) (*Instance, error) {
+	var instance *Instance
+	err := s.inTx(func(tx *sql.Tx) error {
+		var err error
+		instance, err = scanInstance(tx.QueryRow(`SELECT `+instanceColumns+` FROM instances WHERE id = $1 AND organization_id = $2 FOR UPDATE`,
+			id, organizationID))
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrInstanceNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if !CanOperateInstance(instance.Status, to, instance.ProviderID != "") {
+			return &InstanceTransitionError{From: instance.Status, To: to}
+		}
+
+		payload := instanceJob{InstanceID: id}
+		if prepare != nil {
+			if err := prepare(instance, &payload); err != nil {
+				return err
+			}
+		}
+
+		now := time.Now()
+		if _, err := tx.Exec(`UPDATE instances SET status = $1, status_reason = '', updated_at = $2 WHERE id = $3`, to, now, id); err != nil {
+			return err
+		}
+		if _, err := s.queue.Enqueue(context.Background(), tx, kind, payload); err != nil {
+			return err
+		}
+
+		instance.Status = to
+		instance.StatusReason = ""
+		instance.UpdatedAt = now
+		return nil
+	})
+	if errors.Is(err, ErrInstanceNotFound) || errors.Is(err, ErrInvalidInstanceTransition) || errors.Is(err, ErrInvalidSize) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update instance: %w", err)
+	}
+	return instance, nil
+}
@@ -0,0 +1,47 @@
+package services
+
+import "testing"
+
+func TestCanOperateInstance(t *testing.T) {
+	for _, tc := range []struct {
+		from        string
+		to          string
+		hasResource bool
+		want        bool
+	}{
+		{InstanceStatusRunning, InstanceStatusStopping, true, true},
+		{InstanceStatusRunning, InstanceStatusResizing, true, true},
+		{InstanceStatusRunning, InstanceStatusStarting, true, false},
+		{InstanceStatusStopped, InstanceStatusStarting, true, true},
+		{InstanceStatusStopped, InstanceStatusRebooting, true, false},
+
+		// Operations in progress can only be overtaken by a delete
+		{InstanceStatusCreating, InstanceStatusDeleting, false, true},
+		{InstanceStatusStarting, InstanceStatusStopping, true, false},
+		{InstanceStatusResizing, InstanceStatusResizing, true, false},
+		{InstanceStatusDeleting, InstanceStatusDeleting, true, false},
+
+		// Moves only jobs make
+		{InstanceStatusCreating, InstanceStatusRunning, true, false},
+		{InstanceStatusStopping, InstanceStatusStopped, true, false},
+		{InstanceStatusStarting, InstanceStatusError, true, false},
+
+		// A failed instance with a resource can be operated on again
+		{InstanceStatusError, InstanceStatusStarting, true, true},
+		{InstanceStatusError, InstanceStatusResizing, true, true},
+		{InstanceStatusError, InstanceStatusDeleting, true, true},
+
+		// One that never got a resource can only be deleted, and a failed
+		// create is only retried by an admin
+		{InstanceStatusError, InstanceStatusStarting, false, false},
+		{InstanceStatusError, InstanceStatusStopping, false, false},
+		{InstanceStatusError, InstanceStatusRebooting, false, false},
+		{InstanceStatusError, InstanceStatusResizing, false, false},
+		{InstanceStatusError, InstanceStatusCreating, false, false},
+		{InstanceStatusError, InstanceStatusDeleting, false, true},
+	} {
+		if got := CanOperateInstance(tc.from, tc.to, tc.hasResource); got != tc.want {
+			t.Errorf("CanOperateInstance(%s, %s, resource=%v) = %v, want %v", tc.from, tc.to, tc.hasResource, got, tc.want)
+		}
+	}
+}
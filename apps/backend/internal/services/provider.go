@@ -0,0 +1,108 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// States a provider reports for an instance
+const (
+	ProviderStatePending    = "pending"
+	ProviderStateRunning    = "running"
+	ProviderStateStopping   = "stopping"
+	ProviderStateStopped    = "stopped"
+	ProviderStateTerminated = "terminated"
+	ProviderStateFailed     = "failed"
+)
+
+var (
+	// ErrUnknownProvider is returned for a provider no driver is registered for
+	ErrUnknownProvider = errors.New("unknown provider")
+	// ErrProviderInstanceNotFound is returned by drivers for an unknown resource
+	ErrProviderInstanceNotFound = errors.New("instance not found at provider")
+)
+
+// InstanceSpec describes the instance to create at a provider
+type InstanceSpec struct {
+	Name    string
+	Type    string
+	Region  string
+	CPU     int
+	Memory  int
+	Storage int
+	Tags    map[string]string // Attached to the provider resource, e.g. our instance ID
+}
+
+// InstanceSize is the CPU, memory and storage of an instance
+type InstanceSize struct {
+	CPU     int `json:"cpu"`
+	Memory  int `json:"memory"`
+	Storage int `json:"storage"`
+}
+
+// ProviderInstance is a provider's view of an instance
+type ProviderInstance struct {
+	ID      string // The provider's resource ID
+	State   string // See ProviderStatePending and friends
+	Message string // Why the instance failed, when it did
+}
+
+// ProviderDriver manages instances at one cloud provider. Create returns
+// once the provider has accepted the request; the instance then becomes
+// running or failed, which Describe reports. Operations on a resource the
+// provider does not know return ErrProviderInstanceNotFound. Resize takes
+// effect at once and is only accepted for a stopped instance.
+type ProviderDriver interface {
+	Create(ctx context.Context, spec InstanceSpec) (*ProviderInstance, error)
+	Describe(ctx context.Context, id string) (*ProviderInstance, error)
+	Delete(ctx context.Context, id string) error
+	Start(ctx context.Context, id string) error
+	Stop(ctx context.Context, id string) error
+	Resize(ctx context.Context, id string, size InstanceSize) error
+}
+
+// DriverRegistry maps provider names, as stored in Instance.Provider, to
+// their drivers
+type DriverRegistry struct {
+	mu      sync.RWMutex
+	drivers map[string]ProviderDriver
+}
+
+func NewDriverRegistry() *DriverRegistry {
+	return &DriverRegistry{drivers: make(map[string]ProviderDriver)}
+}
+
+// Register makes driver handle instances of provider, replacing any driver
+// registered before. Provider names are case-insensitive.
+func (r *DriverRegistry) Register(provider string, driver ProviderDriver) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.drivers[strings.ToLower(provider)] = driver
+}
+
+// Driver returns the driver for provider
+func (r *DriverRegistry) Driver(provider string) (ProviderDriver, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	driver, ok := r.drivers[strings.ToLower(provider)]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownProvider, provider)
+	}
+	return driver, nil
+}
+
+// Providers returns the registered provider names, sorted
+func (r *DriverRegistry) Providers() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	providers := make([]string, 0, len(r.drivers))
+	for provider := range r.drivers {
+		providers = append(providers, provider)
+	}
+	sort.Strings(providers)
+	return providers
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/gokulupadhyayguragain/addtocloud/backend/internal/jobs"
+)
+
+func TestDriverRegistryIsCaseInsensitive(t *testing.T) {
+	registry := NewDriverRegistry()
+	aws := NewFakeDriver(0, 0, 0)
+	registry.Register("AWS", aws)
+	registry.Register("gcp", NewFakeDriver(0, 0, 0))
+
+	for _, tc := range []struct {
+		provider string
+		known    bool
+	}{
+		{"aws", true},
+		{"AWS", true},
+		{"Aws", true},
+		{"GCP", true},
+		{"azure", false},
+		{"", false},
+	} {
+		driver, err := registry.Driver(tc.provider)
+		if tc.known && err != nil {
+			t.Errorf("Driver(%q): %v", tc.provider, err)
+		}
+		if !tc.known && !errors.Is(err, ErrUnknownProvider) {
+			t.Errorf("Driver(%q): err %v, want ErrUnknownProvider", tc.provider, err)
+		}
+		if tc.provider == "aws" && driver != aws {
+			t.Error("Driver(\"aws\") returned another driver")
+		}
+	}
+
+	if providers := registry.Providers(); len(providers) != 2 || providers[0] != "aws" || providers[1] != "gcp" {
+		t.Fatalf("providers %v, want [aws gcp]", providers)
+	}
+}
+
+func TestInstanceThroughRegistry(t *testing.T) {
+	registry := NewDriverRegistry()
+	registry.Register("aws", NewFakeDriver(0, 0, 0))
+	ctx := context.Background()
+
+	driver, err := registry.Driver("AWS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	created, err := driver.Create(ctx, InstanceSpec{Name: "web", Region: "us-east-1", CPU: 1, Memory: 1, Storage: 10})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	instance := &Instance{Provider: "AWS", ProviderID: created.ID}
+	if err := awaitProviderState(ctx, driver, instance.Provider, instance.ProviderID, ProviderStateRunning); err != nil {
+		t.Fatalf("instance never ran: %v", err)
+	}
+	if err := stopAtProvider(ctx, driver, instance); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+	if err := startAtProvider(ctx, driver, instance); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+
+	if err := driver.Delete(ctx, created.ID); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if err := startAtProvider(ctx, driver, instance); err == nil {
+		t.Fatal("started an instance deleted at the provider")
+	}
+}
+
+func TestCreateInstanceRejectsUnknownProvider(t *testing.T) {
+	// The provider is checked before anything is written, so no database is
+	// needed
+	s := NewCloudService(nil, nil, NewDriverRegistry(), jobs.NewQueue(nil, jobs.Config{}))
+	if _, err := s.CreateInstance(CreateInstanceRequest{Name: "web", Provider: "aws"}); !errors.Is(err, ErrUnknownProvider) {
+		t.Fatalf("create at an unregistered provider: err %v, want ErrUnknownProvider", err)
+	}
+}
@@ -0,0 +1,453 @@
+package email
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+type SMTPConfig struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func NewSMTPConfig() *SMTPConfig {
+	return &SMTPConfig{
+		Host:     os.Getenv("SMTP_HOST"),
+		Port:     os.Getenv("SMTP_PORT"),
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     os.Getenv("SMTP_FROM"),
+	}
+}
+
+func (s *SMTPConfig) SendContactEmail(name, email, message string) error {
+	if s.Host == "" || s.Username == "" || s.Password == "" {
+		return fmt.Errorf("SMTP not configured")
+	}
+
+	subject := fmt.Sprintf("New Contact Form Message from %s", name)
+	body := fmt.Sprintf(`
+New contact form submission received:
+
+Name: %s
+Email: %s
+Submitted: %s
+
+Message:
+%s
+
+---
+Sent from AddToCloud Contact Form
+	`, name, email, time.Now().Format(time.RFC3339), message)
+
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s",
+		s.From, "admin@addtocloud.tech", subject, body)
+
+	return s.sendEmail("admin@addtocloud.tech", []byte(msg))
+}
+
+func (s *SMTPConfig) SendAutoReply(email, name string) error {
+	if s.Host == "" || s.Username == "" || s.Password == "" {
+		return fmt.Errorf("SMTP not configured")
+	}
+
+	subject := "Thank you for contacting AddToCloud"
+	body := fmt.Sprintf(`
+Hi %s,
+
+Thank you for reaching out to AddToCloud! We've received your message and will get back to you within 24 hours.
+
+In the meantime, feel free to explore our platform:
+- Dashboard: https://dashboard.addtocloud.tech
+- Documentation: https://docs.addtocloud.tech
+- Status Page: https://status.addtocloud.tech
+
+Best regards,
+The AddToCloud Team
+
+---
+This is an automated response. Please do not reply to this email.
+	`, name)
+
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s",
+		s.From, email, subject, body)
+
+	return s.sendEmail(email, []byte(msg))
+}
+
+func (s *SMTPConfig) SendAccessRequestNotification(requestID, name, email, company string) error {
+	if s.Host == "" || s.Username == "" || s.Password == "" {
+		return fmt.Errorf("SMTP not configured")
+	}
+
+	subject := fmt.Sprintf("New Access Request: %s from %s", requestID, company)
+	body := fmt.Sprintf(`
+New access request received:
+
+Request ID: %s
+Name: %s
+Email: %s
+Company: %s
+Submitted: %s
+
+Review at: https://admin.addtocloud.tech/access-requests/%s
+
+---
+AddToCloud Admin Notification
+	`, requestID, name, email, company, time.Now().Format(time.RFC3339), requestID)
+
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s",
+		s.From, "admin@addtocloud.tech", subject, body)
+
+	return s.sendEmail("admin@addtocloud.tech", []byte(msg))
+}
+
+func (s *SMTPConfig) SendPasswordResetEmail(email, name, resetLink string, expiresIn time.Duration) error {
+	if s.Host == "" || s.Username == "" || s.Password == "" {
+		return fmt.Errorf("SMTP not configured")
+	}
+
+	subject := "Reset your AddToCloud password"
+	body := fmt.Sprintf(`
+Hi %s,
+
+We received a request to reset the password for your AddToCloud account.
+
+Reset your password: %s
+
+This link can be used once and expires in %s. If you did not request a
+password reset, you can ignore this email and your password will stay the same.
+
+Best regards,
+The AddToCloud Team
+
+---
+This is an automated message. Please do not reply to this email.
+	`, name, resetLink, expiresIn)
+
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s",
+		s.From, email, subject, body)
+
+	return s.sendEmail(email, []byte(msg))
+}
+
+func (s *SMTPConfig) SendAccountSetupEmail(email, name, setupLink string, expiresIn time.Duration) error {
+	if s.Host == "" || s.Username == "" || s.Password == "" {
+		return fmt.Errorf("SMTP not configured")
+	}
+
+	subject := "Your AddToCloud account is ready"
+	body := fmt.Sprintf(`
+Hi %s,
+
+Your request for access to AddToCloud has been approved and your account
+has been created.
+
+Choose your password to sign in: %s
+
+This link can be used once and expires in %s. If it expires, use "Forgot
+password" on the sign-in page to get a new one.
+
+Best regards,
+The AddToCloud Team
+
+---
+This is an automated message. Please do not reply to this email.
+	`, name, setupLink, expiresIn)
+
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s",
+		s.From, email, subject, body)
+
+	return s.sendEmail(email, []byte(msg))
+}
+
+func (s *SMTPConfig) SendVerificationEmail(email, name, verifyLink string, expiresIn time.Duration) error {
+	if s.Host == "" || s.Username == "" || s.Password == "" {
+		return fmt.Errorf("SMTP not configured")
+	}
+
+	subject := "Verify your AddToCloud email address"
+	body := fmt.Sprintf(`
+Hi %s,
+
+Please confirm that this is your email address by opening the link below:
+
+%s
+
+The link expires in %s. If you did not create an AddToCloud account, you can
+ignore this email.
+
+Best regards,
+The AddToCloud Team
+
+---
+This is an automated message. Please do not reply to this email.
+	`, name, verifyLink, expiresIn)
+
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s",
+		s.From, email, subject, body)
+
+	return s.sendEmail(email, []byte(msg))
+}
+
+func (s *SMTPConfig) SendAccessRequestReceipt(email, name, requestID, trackingToken, statusLink string) error {
+	if s.Host == "" || s.Username == "" || s.Password == "" {
+		return fmt.Errorf("SMTP not configured")
+	}
+
+	subject := fmt.Sprintf("We received your AddToCloud access request %s", requestID)
+	body := fmt.Sprintf(`
+Hi %s,
+
+Thank you for requesting access to AddToCloud. Our team will review your
+request shortly.
+
+Request ID: %s
+Tracking token: %s
+
+Check the status of your request at any time: %s
+
+Keep the tracking token private. Anyone with it can see the status of your
+request and reply to our reviewers.
+
+Best regards,
+The AddToCloud Team
+
+---
+This is an automated message. Please do not reply to this email.
+	`, name, requestID, trackingToken, statusLink)
+
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s",
+		s.From, email, subject, body)
+
+	return s.sendEmail(email, []byte(msg))
+}
+
+func (s *SMTPConfig) SendAccessRequestReminder(email string, pending []string, reviewLink string) error {
+	if s.Host == "" || s.Username == "" || s.Password == "" {
+		return fmt.Errorf("SMTP not configured")
+	}
+
+	subject := fmt.Sprintf("%d AddToCloud access requests are waiting for review", len(pending))
+	body := fmt.Sprintf(`
+Hello,
+
+These access requests have been waiting for a decision for a while:
+
+%s
+
+Review them at: %s
+
+Requests that stay undecided are expired automatically and the requester is
+told to apply again.
+
+---
+This is an automated message. Please do not reply to this email.
+	`, "- "+strings.Join(pending, "\n- "), reviewLink)
+
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s",
+		s.From, email, subject, body)
+
+	return s.sendEmail(email, []byte(msg))
+}
+
+func (s *SMTPConfig) SendAccessRequestMessage(email, name, requestID, status, message, statusLink string) error {
+	if s.Host == "" || s.Username == "" || s.Password == "" {
+		return fmt.Errorf("SMTP not configured")
+	}
+
+	subject := fmt.Sprintf("Update on your AddToCloud access request %s", requestID)
+	body := fmt.Sprintf(`
+Hi %s,
+
+A reviewer has written about your access request %s (status: %s):
+
+%s
+
+Reply and follow your request here: %s
+
+You will need the tracking token you received when you submitted the request.
+
+Best regards,
+The AddToCloud Team
+
+---
+This is an automated message. Please do not reply to this email.
+	`, name, requestID, status, message, statusLink)
+
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s",
+		s.From, email, subject, body)
+
+	return s.sendEmail(email, []byte(msg))
+}
+
+func (s *SMTPConfig) SendOrganizationInvitation(email, organization, inviter, role, acceptLink string, expiresIn time.Duration) error {
+	if s.Host == "" || s.Username == "" || s.Password == "" {
+		return fmt.Errorf("SMTP not configured")
+	}
+
+	subject := fmt.Sprintf("You have been invited to join %s on AddToCloud", organization)
+	body := fmt.Sprintf(`
+Hi,
+
+%s has invited you to join the %s organization on AddToCloud as %s.
+
+Accept the invitation: %s
+
+Sign in or create an account with this email address to accept. The link
+expires in %s. If you were not expecting this invitation, you can ignore
+this email.
+
+Best regards,
+The AddToCloud Team
+
+---
+This is an automated message. Please do not reply to this email.
+	`, inviter, organization, role, acceptLink, expiresIn)
+
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s",
+		s.From, email, subject, body)
+
+	return s.sendEmail(email, []byte(msg))
+}
+
+func (s *SMTPConfig) SendErasureScheduled(email, name string, scheduledFor time.Time) error {
+	if s.Host == "" || s.Username == "" || s.Password == "" {
+		return fmt.Errorf("SMTP not configured")
+	}
+
+	subject := "Your AddToCloud data will be erased"
+	body := fmt.Sprintf(`
+Hi %s,
+
+We received a request to erase your personal data from AddToCloud. It will
+be erased permanently on %s.
+
+Until then you can cancel the erasure from your account settings, or from
+your access request page with your tracking token. After that date the data
+cannot be recovered.
+
+If you did not ask for this, cancel the erasure and contact support.
+
+Best regards,
+The AddToCloud Team
+
+---
+This is an automated message. Please do not reply to this email.
+	`, name, scheduledFor.UTC().Format(time.RFC1123))
+
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s",
+		s.From, email, subject, body)
+
+	return s.sendEmail(email, []byte(msg))
+}
+
+func (s *SMTPConfig) sendEmail(to string, message []byte) error {
+	// Connect to server
+	conn, err := smtp.Dial(s.Host + ":" + s.Port)
+	if err != nil {
+		return fmt.Errorf("failed to connect to SMTP server: %v", err)
+	}
+	defer conn.Close()
+
+	// Start TLS
+	if err = conn.StartTLS(&tls.Config{
+		ServerName: s.Host,
+		MinVersion: tls.VersionTLS12,
+	}); err != nil {
+		return fmt.Errorf("failed to start TLS: %v", err)
+	}
+
+	// Authenticate
+	auth := smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	if err = conn.Auth(auth); err != nil {
+		return fmt.Errorf("authentication failed: %v", err)
+	}
+
+	// Set sender
+	if err = conn.Mail(s.From); err != nil {
+		return fmt.Errorf("failed to set sender: %v", err)
+	}
+
+	// Set recipient
+	if err = conn.Rcpt(to); err != nil {
+		return fmt.Errorf("failed to set recipient: %v", err)
+	}
+
+	// Send message
+	w, err := conn.Data()
+	if err != nil {
+		return fmt.Errorf("failed to get data writer: %v", err)
+	}
+
+	if _, err = w.Write(message); err != nil {
+		return fmt.Errorf("failed to write message: %v", err)
+	}
+
+	if err = w.Close(); err != nil {
+		return fmt.Errorf("failed to close data writer: %v", err)
+	}
+
+	return nil
+}
+
+func (s *SMTPConfig) TestConnection() error {
+	if s.Host == "" || s.Username == "" || s.Password == "" {
+		return fmt.Errorf("SMTP configuration incomplete")
+	}
+
+	// Test basic connectivity
+	conn, err := smtp.Dial(s.Host + ":" + s.Port)
+	if err != nil {
+		return fmt.Errorf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	// Test TLS
+	if err = conn.StartTLS(&tls.Config{
+		ServerName: s.Host,
+		MinVersion: tls.VersionTLS12,
+	}); err != nil {
+		return fmt.Errorf("TLS failed: %v", err)
+	}
+
+	// Test authentication
+	auth := smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	if err = conn.Auth(auth); err != nil {
+		return fmt.Errorf("authentication failed: %v", err)
+	}
+
+	return nil
+}
+
+func (s *SMTPConfig) SendTestEmail(to string) error {
+	subject := "AddToCloud API - SMTP Test"
+	body := fmt.Sprintf(`
+This is a test email from the AddToCloud API.
+
+Sent at: %s
+From: %s
+To: %s
+
+If you receive this email, SMTP is working correctly!
+
+---
+AddToCloud Enterprise Platform
+	`, time.Now().Format(time.RFC3339), s.From, to)
+
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s",
+		s.From, to, subject, body)
+
+	return s.sendEmail(to, []byte(msg))
+}
+
+func (s *SMTPConfig) IsConfigured() bool {
+	return s.Host != "" && s.Username != "" && s.Password != "" && s.From != ""
+}
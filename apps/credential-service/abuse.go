@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/gokulupadhyayguragain/addtocloud/libs/security/abuse"
+	"github.com/gokulupadhyayguragain/addtocloud/libs/security/lockout"
+)
+
+// Anti-abuse protection for the public request form: a honeypot field,
+// per-IP and per-email rate limits and an optional captcha-style challenge,
+// checked by the shared abuse guard.
+
+// maxSubmissionBytes bounds the body read to look for the email and honeypot
+const maxSubmissionBytes = 64 << 10
+
+// SubmissionGuard protects the public forms
+type SubmissionGuard struct {
+	guard *abuse.Guard
+}
+
+// NewSubmissionGuard records blocked submissions in db when it is available.
+// Counters are kept in memory, so each replica limits independently.
+func NewSubmissionGuard(db *Database, verifier abuse.Verifier) *SubmissionGuard {
+	return &SubmissionGuard{guard: abuse.NewGuard(lockout.NewMemoryStore(), verifier, func(block abuse.Block) {
+		log.Printf("Blocked %s submission from %s (%s)", block.Endpoint, block.IPAddress, block.Reason)
+		if db != nil {
+			if err := db.SaveBlockedSubmission(block.Endpoint, block.Reason, block.IPAddress, block.Email, block.UserAgent); err != nil {
+				log.Printf("Failed to record blocked submission: %v", err)
+			}
+		}
+	})}
+}
+
+// Protect guards a JSON submission endpoint. The body is read for its email
+// and honeypot fields and restored for the handler. Honeypot hits get a
+// normal-looking response so bots do not learn they were caught.
+func (g *SubmissionGuard) Protect(endpoint string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxSubmissionBytes+1))
+		if err != nil || len(body) > maxSubmissionBytes {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request body too large"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		var fields struct {
+			Email    string `json:"email"`
+			Honeypot string `json:"website"`
+		}
+		_ = json.Unmarshal(body, &fields)
+
+		block := g.guard.Check(c.Request.Context(), abuse.Attempt{
+			Endpoint:       endpoint,
+			IPAddress:      c.ClientIP(),
+			Email:          fields.Email,
+			UserAgent:      c.Request.UserAgent(),
+			Honeypot:       fields.Honeypot,
+			ChallengeToken: c.GetHeader("X-Challenge-Token"),
+		})
+		if block == nil {
+			c.Next()
+			return
+		}
+
+		switch block.Reason {
+		case abuse.ReasonHoneypot:
+			c.AbortWithStatusJSON(http.StatusAccepted, gin.H{"message": "Request received"})
+		case abuse.ReasonChallenge:
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Challenge verification failed", "challenge_required": true})
+		default:
+			seconds := int(math.Ceil(block.RetryAfter.Seconds()))
+			c.Header("Retry-After", strconv.Itoa(seconds))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests. Please try again later.", "retry_after": seconds})
+		}
+	}
+}
@@ -2,8 +2,11 @@ package main
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
 	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
 	"net/smtp"
@@ -14,6 +17,9 @@ import (
 	"github.com/gin-gonic/gin"
 	_ "github.com/lib/pq"
 	"golang.org/x/crypto/bcrypt"
+
+	"github.com/gokulupadhyayguragain/addtocloud/libs/security/abuse"
+	"github.com/gokulupadhyayguragain/addtocloud/libs/security/clientip"
 )
 
 type CredentialRequest struct {
@@ -26,6 +32,15 @@ type CredentialRequest struct {
 	Status      string    `json:"status"` // pending, approved, denied
 }
 
+// RequestStatus is what a requester sees of their own request
+type RequestStatus struct {
+	ID          string    `json:"request_id"`
+	Status      string    `json:"status"`
+	RequestedAt time.Time `json:"requested_at"`
+}
+
+var errRequestNotFound = errors.New("request not found")
+
 type Credentials struct {
 	ID          string    `json:"id"`
 	Username    string    `json:"username"`
@@ -86,6 +101,8 @@ func (db *Database) createTables() error {
 			requested_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 			status VARCHAR(20) DEFAULT 'pending'
 		)`,
+		// Column added for requester status tracking
+		`ALTER TABLE credential_requests ADD COLUMN IF NOT EXISTS tracking_token_hash VARCHAR(64)`,
 		`CREATE TABLE IF NOT EXISTS user_credentials (
 			id VARCHAR(50) PRIMARY KEY,
 			request_id VARCHAR(50) REFERENCES credential_requests(id),
@@ -98,6 +115,15 @@ func (db *Database) createTables() error {
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 			is_active BOOLEAN DEFAULT true
 		)`,
+		`CREATE TABLE IF NOT EXISTS blocked_submissions (
+			id SERIAL PRIMARY KEY,
+			endpoint VARCHAR(50) NOT NULL,
+			reason VARCHAR(50) NOT NULL,
+			ip_address VARCHAR(100),
+			email VARCHAR(255),
+			user_agent TEXT,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
 		`CREATE TABLE IF NOT EXISTS service_access (
 			id SERIAL PRIMARY KEY,
 			credential_id VARCHAR(50) REFERENCES user_credentials(id),
@@ -116,11 +142,38 @@ func (db *Database) createTables() error {
 	return nil
 }
 
-func (db *Database) SaveCredentialRequest(req CredentialRequest) error {
-	query := `INSERT INTO credential_requests (id, email, full_name, company, purpose, requested_at, status) 
-			  VALUES ($1, $2, $3, $4, $5, $6, $7)`
+func (db *Database) SaveCredentialRequest(req CredentialRequest, trackingToken string) error {
+	query := `INSERT INTO credential_requests (id, email, full_name, company, purpose, requested_at, status, tracking_token_hash) 
+			  VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+
+	_, err := db.conn.Exec(query, req.ID, req.Email, req.FullName, req.Company, req.Purpose, req.RequestedAt, req.Status,
+		hashToken(trackingToken))
+	return err
+}
+
+// GetRequestStatus returns a request only if trackingToken is its tracking
+// token, so one requester cannot read another's request
+func (db *Database) GetRequestStatus(id, trackingToken string) (*RequestStatus, error) {
+	query := `SELECT id, status, requested_at
+			  FROM credential_requests WHERE id = $1 AND tracking_token_hash = $2`
+
+	var status RequestStatus
+	err := db.conn.QueryRow(query, id, hashToken(trackingToken)).Scan(
+		&status.ID, &status.Status, &status.RequestedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errRequestNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
 
-	_, err := db.conn.Exec(query, req.ID, req.Email, req.FullName, req.Company, req.Purpose, req.RequestedAt, req.Status)
+// SaveBlockedSubmission records a submission stopped by the abuse protection
+// so it can be reviewed
+func (db *Database) SaveBlockedSubmission(endpoint, reason, ipAddress, email, userAgent string) error {
+	_, err := db.conn.Exec(`INSERT INTO blocked_submissions (endpoint, reason, ip_address, email, user_agent) VALUES ($1, $2, $3, $4, $5)`,
+		endpoint, reason, ipAddress, email, userAgent)
 	return err
 }
 
@@ -189,6 +242,11 @@ func generateAPIKey() string {
 	return base64.URLEncoding.EncodeToString(bytes)
 }
 
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
 func hashPassword(password string) (string, error) {
 	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 12)
 	return string(bytes), err
@@ -308,6 +366,28 @@ func (e *EmailService) sendCredentialRequestNotification(req CredentialRequest,
 	return smtp.SendMail(e.SMTPHost+":"+e.SMTPPort, auth, e.From, []string{e.To}, msg)
 }
 
+func (e *EmailService) sendRequestReceipt(req CredentialRequest, trackingToken string) error {
+	subject := fmt.Sprintf("We received your AddToCloud access request %s", req.ID)
+
+	body := fmt.Sprintf(`Hi %s,
+
+Thank you for requesting access to AddToCloud. Our team will review your request shortly.
+
+Request ID: %s
+Tracking token: %s
+
+Check the status of your request with both values at %s/api/request-credentials/%s/status
+(send the token in the X-Tracking-Token header). Keep the token private.
+
+AddToCloud Team
+`, req.FullName, req.ID, trackingToken, getEnv("PUBLIC_URL", "https://credentials.addtocloud.tech"), req.ID)
+
+	msg := []byte(fmt.Sprintf("To: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s", req.Email, subject, body))
+
+	auth := smtp.PlainAuth("", e.From, e.Password, e.SMTPHost)
+	return smtp.SendMail(e.SMTPHost+":"+e.SMTPPort, auth, e.From, []string{req.Email}, msg)
+}
+
 func main() {
 	// Initialize database
 	db, err := NewDatabase()
@@ -327,8 +407,23 @@ func main() {
 		To:       getEnv("EMAIL_TO", "info@addtocloud.tech"),
 	}
 
+	verifier, err := abuse.VerifierFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid challenge configuration: %v", err)
+	}
+	if verifier == nil {
+		log.Println("⚠️  CHALLENGE_PROVIDER not set, request form is protected by rate limits only")
+	}
+	submissionGuard := NewSubmissionGuard(db, verifier)
+
 	r := gin.Default()
 
+	// Rate limits key off the client IP, so only our load balancers may
+	// report it through X-Forwarded-For
+	if err := r.SetTrustedProxies(clientip.TrustedProxiesFromEnv()); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+
 	// Serve static files
 	r.Static("/static", "./public")
 	r.StaticFile("/", "./public/index.html")
@@ -337,7 +432,7 @@ func main() {
 	r.Use(func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
 		c.Header("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type")
+		c.Header("Access-Control-Allow-Headers", "Content-Type, X-Tracking-Token, X-Challenge-Token")
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
 			return
@@ -361,7 +456,7 @@ func main() {
 	})
 
 	// Credential request endpoint
-	r.POST("/api/request-credentials", func(c *gin.Context) {
+	r.POST("/api/request-credentials", submissionGuard.Protect("request_credentials"), func(c *gin.Context) {
 		var req CredentialRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
 			c.JSON(400, gin.H{
@@ -371,8 +466,10 @@ func main() {
 			return
 		}
 
-		// Generate unique ID and timestamp
+		// Generate unique ID and timestamp. The tracking token lets the
+		// requester check on the request later.
 		req.ID = generateAPIKey()[:16]
+		trackingToken := generateAPIKey()
 		req.RequestedAt = time.Now()
 		req.Status = "pending" // Requires manual approval
 
@@ -396,7 +493,7 @@ func main() {
 
 		// Save to database if available
 		if db != nil {
-			if err := db.SaveCredentialRequest(req); err != nil {
+			if err := db.SaveCredentialRequest(req, trackingToken); err != nil {
 				log.Printf("Failed to save request: %v", err)
 			}
 			if err := db.SaveCredentials(creds, req.ID); err != nil {
@@ -414,15 +511,49 @@ func main() {
 			return
 		}
 
+		if err := emailService.sendRequestReceipt(req, trackingToken); err != nil {
+			log.Printf("Failed to send receipt for request %s: %v", req.ID, err)
+		}
+
 		c.JSON(200, gin.H{
-			"success":    true,
-			"message":    "Access request submitted successfully",
-			"request_id": req.ID,
-			"note":       "Your request is being reviewed. You will receive credentials via email if approved.",
-			"status":     "pending_approval",
+			"success":        true,
+			"message":        "Access request submitted successfully",
+			"request_id":     req.ID,
+			"tracking_token": trackingToken,
+			"note":           "Your request is being reviewed. Use the request ID and tracking token to check its status.",
+			"status":         "pending_approval",
 		})
 	})
 
+	// Requesters check on their request with its ID and tracking token.
+	// Unknown IDs and wrong tokens get the same response.
+	r.GET("/api/request-credentials/:id/status", func(c *gin.Context) {
+		if db == nil {
+			c.JSON(503, gin.H{"error": "Status tracking is temporarily unavailable"})
+			return
+		}
+
+		token := c.GetHeader("X-Tracking-Token")
+		if token == "" {
+			c.JSON(404, gin.H{"error": "Request not found"})
+			return
+		}
+
+		status, err := db.GetRequestStatus(c.Param("id"), token)
+		if errors.Is(err, errRequestNotFound) {
+			c.JSON(404, gin.H{"error": "Request not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to load request status: %v", err)
+			c.JSON(500, gin.H{"error": "Failed to load request status"})
+			return
+		}
+
+		c.Header("Cache-Control", "no-store")
+		c.JSON(200, gin.H{"request": status})
+	})
+
 	// Status endpoint
 	r.GET("/api/status", func(c *gin.Context) {
 		c.JSON(200, gin.H{
@@ -14,6 +14,8 @@ import (
 	"github.com/golang-jwt/jwt/v4"
 	"golang.org/x/crypto/bcrypt"
 	"gopkg.in/gomail.v2"
+
+	"addtocloud-backend/internal/jwtkeys"
 )
 
 // User represents a platform user
@@ -75,13 +77,14 @@ type EC2ProvisionResponse struct {
 // In-memory storage (replace with database in production)
 var users = make(map[string]*User)
 var accessRequests = make(map[string]*AccessRequest)
-var jwtSecret []byte
+var signingKeys *jwtkeys.KeySet
 
 func init() {
-	// Initialize JWT secret
-	jwtSecret = []byte(os.Getenv("JWT_SECRET"))
-	if len(jwtSecret) == 0 {
-		jwtSecret = []byte("addtocloud-admin-secret-2025")
+	// Load JWT signing keys
+	var err error
+	signingKeys, err = jwtkeys.Load()
+	if err != nil {
+		log.Fatalf("Failed to load JWT signing keys: %v", err)
 	}
 }
 
@@ -96,6 +99,9 @@ func main() {
 	config.AllowHeaders = []string{"Origin", "Content-Type", "Accept", "Authorization", "X-Requested-With"}
 	r.Use(cors.New(config))
 
+	// Public keys for verifying issued tokens
+	r.GET("/.well-known/jwks.json", gin.WrapF(signingKeys.ServeJWKS))
+
 	// Health check
 	r.GET("/api/health", func(c *gin.Context) {
 		c.JSON(200, gin.H{
@@ -400,9 +406,7 @@ func authMiddleware() gin.HandlerFunc {
 		}
 
 		tokenString := authHeader[7:] // Remove "Bearer " prefix
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			return jwtSecret, nil
-		})
+		token, err := signingKeys.Parse(tokenString)
 
 		if err != nil || !token.Valid {
 			c.JSON(401, gin.H{"error": "Invalid token"})
@@ -416,13 +420,13 @@ func authMiddleware() gin.HandlerFunc {
 
 // Generate JWT token
 func generateJWT(userID, email string) string {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+	claims := jwt.MapClaims{
 		"user_id": userID,
 		"email":   email,
 		"exp":     time.Now().Add(time.Hour * 24).Unix(),
-	})
+	}
 
-	tokenString, _ := token.SignedString(jwtSecret)
+	tokenString, _ := signingKeys.Sign(claims)
 	return tokenString
 }
 
@@ -15,6 +15,7 @@ import (
 	_ "github.com/lib/pq"
 	"golang.org/x/crypto/bcrypt"
 
+	"addtocloud-backend/internal/jwtkeys"
 	"addtocloud-backend/pkg/email"
 )
 
@@ -87,7 +88,7 @@ type Cluster struct {
 }
 
 var db *sql.DB
-var jwtSecret []byte
+var signingKeys *jwtkeys.KeySet
 
 func main() {
 	// Load environment variables
@@ -95,14 +96,14 @@ func main() {
 		log.Printf("Warning: Could not load .env.production file: %v", err)
 	}
 
-	// Initialize JWT secret
-	jwtSecret = []byte(os.Getenv("JWT_SECRET"))
-	if len(jwtSecret) == 0 {
-		jwtSecret = []byte("default-jwt-secret-change-in-production")
+	// Load JWT signing keys
+	var err error
+	signingKeys, err = jwtkeys.Load()
+	if err != nil {
+		log.Fatalf("Failed to load JWT signing keys: %v", err)
 	}
 
 	// Initialize database connection
-	var err error
 	db, err = initDB()
 	if err != nil {
 		log.Printf("Database connection failed: %v", err)
@@ -130,6 +131,9 @@ func main() {
 		AllowCredentials: true,
 	}))
 
+	// Public keys for verifying issued tokens
+	r.GET("/.well-known/jwks.json", gin.WrapF(signingKeys.ServeJWKS))
+
 	// Health check with real cluster info
 	r.GET("/api/health", func(c *gin.Context) {
 		health := HealthResponse{
@@ -467,14 +471,14 @@ func authenticateUser(login LoginRequest) (*User, error) {
 }
 
 func generateJWT(user *User) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+	claims := jwt.MapClaims{
 		"user_id": user.ID,
 		"email":   user.Email,
 		"role":    user.Role,
 		"exp":     time.Now().Add(time.Hour * 24).Unix(),
-	})
+	}
 
-	return token.SignedString(jwtSecret)
+	return signingKeys.Sign(claims)
 }
 
 func authMiddleware() gin.HandlerFunc {
@@ -491,9 +495,7 @@ func authMiddleware() gin.HandlerFunc {
 			tokenString = tokenString[7:]
 		}
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			return jwtSecret, nil
-		})
+		token, err := signingKeys.Parse(tokenString)
 
 		if err != nil || !token.Valid {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
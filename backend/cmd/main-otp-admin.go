@@ -2,19 +2,28 @@ package main
 
 import (
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/hex"
 	"fmt"
 	"log"
+	"math"
 	"math/big"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v4"
 	"gopkg.in/gomail.v2"
+
+	"addtocloud-backend/internal/jwtkeys"
+	"addtocloud-backend/pkg/database"
+
+	"github.com/gokulupadhyayguragain/addtocloud/libs/security/clientip"
+	"github.com/gokulupadhyayguragain/addtocloud/libs/security/lockout"
 )
 
 // Admin represents the admin user with OTP authentication
@@ -94,13 +103,38 @@ type VMCredentials struct {
 var (
 	accessRequests = make(map[string]AccessRequest)
 	users          = make(map[string]User)
-	adminOTPs      = make(map[string]Admin)
-	jwtSecret      = []byte("your-super-secret-jwt-key-change-this-in-production")
+	signingKeys    *jwtkeys.KeySet
+
+	// Pending OTPs by admin email
+	adminOTPs   = make(map[string]Admin)
+	adminOTPsMu sync.Mutex
+
+	// OTP guesses are throttled per admin email and per client IP
+	otpGuard *lockout.Guard
+
+	// Most recent lockouts, newest last, for the admin lockout view
+	lockoutEvents   []lockout.Event
+	lockoutEventsMu sync.Mutex
 )
 
+const maxLockoutEvents = 200
+
 func main() {
+	var err error
+	signingKeys, err = jwtkeys.Load()
+	if err != nil {
+		log.Fatalf("Failed to load JWT signing keys: %v", err)
+	}
+
+	initLockout()
+
 	r := gin.Default()
 
+	// Lockouts key off the client IP, so only our load balancers may report it
+	if err := r.SetTrustedProxies(clientip.TrustedProxiesFromEnv()); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+
 	// CORS configuration
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:3000", "https://addtocloud.pages.dev"},
@@ -111,6 +145,9 @@ func main() {
 		MaxAge:           12 * time.Hour,
 	}))
 
+	// Public keys for verifying issued tokens
+	r.GET("/.well-known/jwks.json", gin.WrapF(signingKeys.ServeJWKS))
+
 	// Health check
 	r.GET("/api/health", func(c *gin.Context) {
 		c.JSON(200, gin.H{
@@ -140,6 +177,7 @@ func main() {
 		admin.POST("/provision-vm", provisionUserVM)
 		admin.POST("/provision-admin-vm", provisionAdminVM)
 		admin.GET("/vm-status/:userId", getVMStatus)
+		admin.GET("/lockouts", getLockouts)
 	}
 
 	// Protected user endpoints
@@ -163,6 +201,78 @@ func main() {
 	r.Run(":" + port)
 }
 
+func initLockout() {
+	var store lockout.Store
+	if rdb, err := database.InitRedis(); err == nil {
+		store = lockout.NewRedisStore(rdb)
+	} else {
+		log.Printf("⚠️  Redis not available, using in-memory lockout counters: %v", err)
+		store = lockout.NewMemoryStore()
+	}
+	otpGuard = lockout.NewGuard(store, recordLockout)
+}
+
+// recordLockout keeps the event for the admin lockout view
+func recordLockout(event lockout.Event) {
+	log.Printf("🚫 OTP lockout: %s %s after %d failures (from %s) until %s",
+		event.Scope, event.Subject, event.Failures, event.IPAddress, event.LockedUntil.Format(time.RFC3339))
+
+	lockoutEventsMu.Lock()
+	defer lockoutEventsMu.Unlock()
+	lockoutEvents = append(lockoutEvents, event)
+	if len(lockoutEvents) > maxLockoutEvents {
+		lockoutEvents = lockoutEvents[len(lockoutEvents)-maxLockoutEvents:]
+	}
+}
+
+// beginOTPAttempt counts an OTP guess before it is checked. It responds with
+// 429 and returns false while the email or client is locked out.
+func beginOTPAttempt(c *gin.Context, email string) bool {
+	remaining, err := otpGuard.Attempt(c.Request.Context(), "otp", email, c.ClientIP())
+	if err != nil {
+		log.Printf("Failed to count OTP attempt: %v", err)
+		return true
+	}
+	if remaining > 0 {
+		// Force a new OTP once the lockout ends
+		adminOTPsMu.Lock()
+		delete(adminOTPs, email)
+		adminOTPsMu.Unlock()
+
+		seconds := int(math.Ceil(remaining.Seconds()))
+		c.Header("Retry-After", strconv.Itoa(seconds))
+		c.JSON(429, gin.H{
+			"error":      "Too many failed attempts. Please try again later.",
+			"retryAfter": seconds,
+		})
+		return false
+	}
+	return true
+}
+
+// Get recent lockouts
+func getLockouts(c *gin.Context) {
+	lockoutEventsMu.Lock()
+	events := make([]gin.H, 0, len(lockoutEvents))
+	for i := len(lockoutEvents) - 1; i >= 0; i-- {
+		event := lockoutEvents[i]
+		events = append(events, gin.H{
+			"scope":       event.Scope,
+			"subject":     event.Subject,
+			"ipAddress":   event.IPAddress,
+			"failures":    event.Failures,
+			"lockedUntil": event.LockedUntil,
+			"active":      time.Now().Before(event.LockedUntil),
+		})
+	}
+	lockoutEventsMu.Unlock()
+
+	c.JSON(200, gin.H{
+		"lockouts": events,
+		"total":    len(events),
+	})
+}
+
 // Generate OTP
 func generateOTP() string {
 	n, _ := rand.Int(rand.Reader, big.NewInt(1000000))
@@ -205,14 +315,16 @@ func userLogin(c *gin.Context) {
 	// For now, we'll use a simple check since users get auto-generated passwords
 
 	// Generate JWT token for user
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"userId": user.ID,
-		"email":  user.Email,
-		"role":   "user",
-		"exp":    time.Now().Add(24 * time.Hour).Unix(),
-	})
+	claims, err := signingKeys.AccessClaims(user.ID, 24*time.Hour)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Token generation failed"})
+		return
+	}
+	claims["userId"] = user.ID
+	claims["email"] = user.Email
+	claims["role"] = "user"
 
-	tokenString, err := token.SignedString(jwtSecret)
+	tokenString, err := signingKeys.Sign(claims)
 	if err != nil {
 		c.JSON(500, gin.H{"error": "Token generation failed"})
 		return
@@ -242,9 +354,7 @@ func verifyUserToken(c *gin.Context) {
 	}
 
 	tokenString := strings.Replace(authHeader, "Bearer ", "", 1)
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return jwtSecret, nil
-	})
+	token, err := signingKeys.Parse(tokenString)
 
 	if err != nil || !token.Valid {
 		c.JSON(401, gin.H{"error": "Invalid token"})
@@ -272,9 +382,7 @@ func getUserProfile(c *gin.Context) {
 	// Extract user from JWT token
 	authHeader := c.GetHeader("Authorization")
 	tokenString := strings.Replace(authHeader, "Bearer ", "", 1)
-	token, _ := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return jwtSecret, nil
-	})
+	token, _ := signingKeys.Parse(tokenString)
 
 	claims, _ := token.Claims.(jwt.MapClaims)
 	userId := claims["userId"].(string)
@@ -345,16 +453,23 @@ func requestOTP(c *gin.Context) {
 		return
 	}
 
+	// A fresh OTP does not lift an active lockout
+	if !beginOTPAttempt(c, req.Email) {
+		return
+	}
+
 	// Generate OTP
 	otp := generateOTP()
 	expiry := time.Now().Add(10 * time.Minute).Unix()
 
 	// Store OTP
+	adminOTPsMu.Lock()
 	adminOTPs[req.Email] = Admin{
 		Email:  req.Email,
 		OTP:    otp,
 		OTPExp: expiry,
 	}
+	adminOTPsMu.Unlock()
 
 	// Send OTP via email
 	if err := sendOTPEmail(req.Email, otp); err != nil {
@@ -378,41 +493,52 @@ func verifyOTP(c *gin.Context) {
 		return
 	}
 
-	// Check if OTP exists and is valid
+	if !beginOTPAttempt(c, req.Email) {
+		return
+	}
+
+	// Check the OTP and use it up in one step, so concurrent requests cannot
+	// log in twice with it
+	adminOTPsMu.Lock()
 	admin, exists := adminOTPs[req.Email]
+	expired := exists && time.Now().Unix() > admin.OTPExp
+	matched := exists && !expired && subtle.ConstantTimeCompare([]byte(admin.OTP), []byte(req.OTP)) == 1
+	if expired || matched {
+		delete(adminOTPs, req.Email)
+	}
+	adminOTPsMu.Unlock()
+
 	if !exists {
 		c.JSON(400, gin.H{"error": "OTP not found. Please request a new one."})
 		return
 	}
-
-	// Check OTP expiry
-	if time.Now().Unix() > admin.OTPExp {
-		delete(adminOTPs, req.Email)
+	if expired {
 		c.JSON(400, gin.H{"error": "OTP expired. Please request a new one."})
 		return
 	}
-
-	// Verify OTP
-	if admin.OTP != req.OTP {
+	if !matched {
 		c.JSON(400, gin.H{"error": "Invalid OTP"})
 		return
 	}
 
-	// OTP is valid, generate JWT token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"email": req.Email,
-		"role":  "admin",
-		"exp":   time.Now().Add(24 * time.Hour).Unix(),
-	})
+	if err := otpGuard.Succeed(c.Request.Context(), "otp", req.Email, c.ClientIP()); err != nil {
+		log.Printf("Failed to reset OTP attempts: %v", err)
+	}
 
-	tokenString, err := token.SignedString(jwtSecret)
+	// OTP is valid, generate JWT token
+	claims, err := signingKeys.AccessClaims(req.Email, 24*time.Hour)
 	if err != nil {
 		c.JSON(500, gin.H{"error": "Token generation failed"})
 		return
 	}
+	claims["email"] = req.Email
+	claims["role"] = "admin"
 
-	// Clear used OTP
-	delete(adminOTPs, req.Email)
+	tokenString, err := signingKeys.Sign(claims)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Token generation failed"})
+		return
+	}
 
 	log.Printf("✅ Admin authenticated: %s", req.Email)
 	c.JSON(200, gin.H{
@@ -436,9 +562,7 @@ func authMiddleware() gin.HandlerFunc {
 		}
 
 		tokenString := strings.Replace(authHeader, "Bearer ", "", 1)
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			return jwtSecret, nil
-		})
+		token, err := signingKeys.Parse(tokenString)
 
 		if err != nil || !token.Valid {
 			c.JSON(401, gin.H{"error": "Invalid token"})
@@ -16,6 +16,8 @@ import (
 	"github.com/golang-jwt/jwt/v4"
 	_ "github.com/lib/pq" // PostgreSQL driver
 	"gopkg.in/gomail.v2"
+
+	"addtocloud-backend/internal/jwtkeys"
 )
 
 // Database connection
@@ -71,7 +73,7 @@ type User struct {
 }
 
 var (
-	jwtSecret = []byte("your-super-secret-jwt-key-change-this-in-production")
+	signingKeys *jwtkeys.KeySet
 )
 
 // Initialize database connection
@@ -380,6 +382,13 @@ func sendOTPEmail(email, otp string) error {
 }
 
 func main() {
+	// Load JWT signing keys
+	var err error
+	signingKeys, err = jwtkeys.Load()
+	if err != nil {
+		log.Fatalf("Failed to load JWT signing keys: %v", err)
+	}
+
 	// Initialize database
 	if err := initDB(); err != nil {
 		log.Printf("Database initialization failed: %v", err)
@@ -397,6 +406,9 @@ func main() {
 		MaxAge:           12 * time.Hour,
 	}))
 
+	// Public keys for verifying issued tokens
+	r.GET("/.well-known/jwks.json", gin.WrapF(signingKeys.ServeJWKS))
+
 	// Health check
 	r.GET("/api/health", func(c *gin.Context) {
 		status := gin.H{
@@ -473,13 +485,13 @@ func main() {
 		}
 
 		// Generate JWT token
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		claims := jwt.MapClaims{
 			"email": req.Email,
 			"role":  "admin",
 			"exp":   time.Now().Add(24 * time.Hour).Unix(),
-		})
+		}
 
-		tokenString, err := token.SignedString(jwtSecret)
+		tokenString, err := signingKeys.Sign(claims)
 		if err != nil {
 			c.JSON(500, gin.H{"status": "error", "message": "Failed to generate token"})
 			return
@@ -0,0 +1,79 @@
+// Package jwtkeys signs and verifies tokens with the shared key set. Key
+// loading, rotation and the issuers whose tokens are trusted live in the
+// shared jwtkeys package; this adapter ties it to jwt/v4 and builds claims in
+// the same shape as the platform API's access tokens.
+package jwtkeys
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+
+	shared "github.com/gokulupadhyayguragain/addtocloud/libs/security/jwtkeys"
+)
+
+// TokenTypeAccess is the "typ" claim of access tokens
+const TokenTypeAccess = "access"
+
+// KeySet holds the signing keys
+type KeySet struct {
+	*shared.KeySet
+}
+
+// Load reads signing keys and trusted issuers from the environment; see the
+// shared package for the variables
+func Load() (*KeySet, error) {
+	keys, err := shared.Load()
+	if err != nil {
+		return nil, err
+	}
+	return &KeySet{keys}, nil
+}
+
+// AccessClaims returns the registered claims of an access token for subject,
+// issued by this service for its token audiences and expiring after ttl.
+// Callers add their own claims such as email and role.
+func (s *KeySet) AccessClaims(subject string, ttl time.Duration) (jwt.MapClaims, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss": s.Issuer(),
+		"sub": subject,
+		"aud": s.TokenAudiences(),
+		"jti": hex.EncodeToString(id),
+		"typ": TokenTypeAccess,
+		"iat": now.Unix(),
+		"exp": now.Add(ttl).Unix(),
+	}, nil
+}
+
+// Sign signs the claims with the active key
+func (s *KeySet) Sign(claims jwt.Claims) (string, error) {
+	kid, algorithm, key := s.SigningKey()
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(algorithm), claims)
+	token.Header["kid"] = kid
+	return token.SignedString(key)
+}
+
+// Parse verifies a token signed by one of the service's keys or a trusted
+// issuer
+func (s *KeySet) Parse(tokenString string) (*jwt.Token, error) {
+	parser := jwt.NewParser(jwt.WithValidMethods(s.Algorithms()))
+	return parser.Parse(tokenString, s.keyfunc)
+}
+
+func (s *KeySet) keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	var issuer string
+	if claims, ok := token.Claims.(jwt.MapClaims); ok {
+		issuer, _ = claims["iss"].(string)
+	}
+	return s.VerificationKey(context.Background(), kid, token.Method.Alg(), issuer)
+}
@@ -1,19 +1,29 @@
 package main
 
 import (
+	"bytes"
 	"crypto/tls"
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"net/http"
 	"net/smtp"
 	"os"
+	"strconv"
 	"time"
 
-	"github.com/golang-jwt/jwt/v4"
 	_ "github.com/lib/pq"
 	"golang.org/x/crypto/bcrypt"
+
+	"addtocloud-backend/internal/jwtkeys"
+	"addtocloud-backend/pkg/database"
+
+	"github.com/gokulupadhyayguragain/addtocloud/libs/security/abuse"
+	"github.com/gokulupadhyayguragain/addtocloud/libs/security/clientip"
+	"github.com/gokulupadhyayguragain/addtocloud/libs/security/lockout"
 )
 
 type ContactRequest struct {
@@ -39,6 +49,21 @@ type User struct {
 
 var db *sql.DB
 
+// signingKeys signs issued tokens; the public keys are served as a JWKS
+var signingKeys *jwtkeys.KeySet
+
+// guard throttles repeated login attempts per account and per client IP
+var guard *lockout.Guard
+
+// clientIPs finds the client behind our load balancers
+var clientIPs *clientip.Resolver
+
+// submissionGuard protects the contact form from bots
+var submissionGuard *abuse.Guard
+
+// maxSubmissionBytes bounds the body read to look for the email and honeypot
+const maxSubmissionBytes = 64 << 10
+
 func initDB() {
 	var err error
 	dbHost := os.Getenv("DB_HOST")
@@ -107,6 +132,170 @@ func createTables() {
 	if err != nil {
 		log.Printf("Failed to create contact_requests table: %v", err)
 	}
+
+	// Create lockout_events table
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS lockout_events (
+			id SERIAL PRIMARY KEY,
+			realm VARCHAR(50) NOT NULL,
+			scope VARCHAR(20) NOT NULL,
+			subject VARCHAR(255) NOT NULL,
+			ip_address VARCHAR(100),
+			failures BIGINT,
+			locked_until TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		log.Printf("Failed to create lockout_events table: %v", err)
+	}
+
+	// Create blocked_submissions table
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS blocked_submissions (
+			id SERIAL PRIMARY KEY,
+			endpoint VARCHAR(50) NOT NULL,
+			reason VARCHAR(50) NOT NULL,
+			ip_address VARCHAR(100),
+			email VARCHAR(255),
+			user_agent TEXT,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		log.Printf("Failed to create blocked_submissions table: %v", err)
+	}
+}
+
+func initLockout() {
+	var err error
+	clientIPs, err = clientip.FromEnv()
+	if err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+
+	var store lockout.Store
+	if rdb, err := database.InitRedis(); err == nil {
+		store = lockout.NewRedisStore(rdb)
+	} else {
+		log.Printf("Redis not available, using in-memory lockout counters: %v", err)
+		store = lockout.NewMemoryStore()
+	}
+	guard = lockout.NewGuard(store, recordLockout)
+
+	verifier, err := abuse.VerifierFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid challenge configuration: %v", err)
+	}
+	if verifier == nil {
+		log.Printf("CHALLENGE_PROVIDER not set, contact form is protected by rate limits only")
+	}
+	submissionGuard = abuse.NewGuard(store, verifier, recordBlockedSubmission)
+}
+
+// recordBlockedSubmission stores a blocked form submission for review
+func recordBlockedSubmission(block abuse.Block) {
+	log.Printf("Blocked %s submission from %s (%s)", block.Endpoint, block.IPAddress, block.Reason)
+
+	if db != nil {
+		_, err := db.Exec("INSERT INTO blocked_submissions (endpoint, reason, ip_address, email, user_agent) VALUES ($1, $2, $3, $4, $5)",
+			block.Endpoint, block.Reason, block.IPAddress, block.Email, block.UserAgent)
+		if err != nil {
+			log.Printf("Failed to store blocked submission: %v", err)
+		}
+	}
+}
+
+// protectSubmission runs the abuse checks before a public form handler. The
+// body is read for its email and honeypot fields and restored for the
+// handler. Honeypot hits get a normal-looking response.
+func protectSubmission(endpoint string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			next(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxSubmissionBytes+1))
+		if err != nil || len(body) > maxSubmissionBytes {
+			corsHeaders(w)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Request body too large"})
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		var fields struct {
+			Email    string `json:"email"`
+			Honeypot string `json:"website"`
+		}
+		_ = json.Unmarshal(body, &fields)
+
+		block := submissionGuard.Check(r.Context(), abuse.Attempt{
+			Endpoint:       endpoint,
+			IPAddress:      clientIP(r),
+			Email:          fields.Email,
+			UserAgent:      r.UserAgent(),
+			Honeypot:       fields.Honeypot,
+			ChallengeToken: r.Header.Get("X-Challenge-Token"),
+		})
+		if block == nil {
+			next(w, r)
+			return
+		}
+
+		corsHeaders(w)
+		w.Header().Set("Content-Type", "application/json")
+		switch block.Reason {
+		case abuse.ReasonHoneypot:
+			json.NewEncoder(w).Encode(map[string]string{"status": "received"})
+		case abuse.ReasonChallenge:
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]interface{}{"error": "Challenge verification failed", "challengeRequired": true})
+		default:
+			seconds := int(math.Ceil(block.RetryAfter.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+			w.WriteHeader(http.StatusTooManyRequests)
+			json.NewEncoder(w).Encode(map[string]interface{}{"error": "Too many submissions. Please try again later.", "retryAfter": seconds})
+		}
+	}
+}
+
+// recordLockout stores the lockout and notifies the admin
+func recordLockout(event lockout.Event) {
+	log.Printf("Lockout: %s %s locked after %d failed logins (from %s) until %s",
+		event.Scope, event.Subject, event.Failures, event.IPAddress, event.LockedUntil.Format(time.RFC3339))
+
+	if db != nil {
+		_, err := db.Exec("INSERT INTO lockout_events (realm, scope, subject, ip_address, failures, locked_until) VALUES ($1, $2, $3, $4, $5, $6)",
+			event.Realm, event.Scope, event.Subject, event.IPAddress, event.Failures, event.LockedUntil)
+		if err != nil {
+			log.Printf("Failed to store lockout event: %v", err)
+		}
+	}
+
+	adminEmail := os.Getenv("ADMIN_EMAIL")
+	if adminEmail == "" {
+		adminEmail = "admin@addtocloud.tech"
+	}
+
+	emailBody := fmt.Sprintf(`Login lockout triggered:
+
+Scope: %s
+Subject: %s
+Client IP: %s
+Failed attempts: %d
+Locked until: %s`,
+		event.Scope, event.Subject, event.IPAddress, event.Failures, event.LockedUntil.Format(time.RFC3339))
+
+	go sendEmail(adminEmail, "Login Lockout - AddToCloud", emailBody)
+}
+
+// clientIP returns the originating client address. Only proxies in
+// TRUSTED_PROXIES are believed when they report it in X-Forwarded-For.
+func clientIP(r *http.Request) string {
+	return clientIPs.ClientIP(r)
 }
 
 func createDefaultAdmin() {
@@ -205,7 +394,7 @@ func sendEmail(to, subject, body string) error {
 func corsHeaders(w http.ResponseWriter) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Challenge-Token")
 }
 
 func healthHandler(w http.ResponseWriter, r *http.Request) {
@@ -331,6 +520,26 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 
 	log.Printf("Login attempt for email: %s", req.Email)
 
+	ctx := r.Context()
+	ip := clientIP(r)
+	if remaining, err := guard.Attempt(ctx, "login", req.Email, ip); err != nil {
+		log.Printf("Failed to count login attempt: %v", err)
+	} else if remaining > 0 {
+		seconds := int(math.Ceil(remaining.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":      "Too many failed attempts. Please try again later.",
+			"retryAfter": seconds,
+		})
+		return
+	}
+
+	loginFailed := func() {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid credentials"})
+	}
+
 	var user User
 	var hashedPassword string
 
@@ -341,16 +550,14 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 
 		if err != nil {
 			log.Printf("User not found in database: %s", req.Email)
-			w.WriteHeader(http.StatusUnauthorized)
-			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid credentials"})
+			loginFailed()
 			return
 		}
 
 		// Verify password
 		if err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(req.Password)); err != nil {
 			log.Printf("Invalid password for user: %s", req.Email)
-			w.WriteHeader(http.StatusUnauthorized)
-			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid credentials"})
+			loginFailed()
 			return
 		}
 	} else {
@@ -364,26 +571,27 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 				Created: time.Now(),
 			}
 		} else {
-			w.WriteHeader(http.StatusUnauthorized)
-			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid credentials"})
+			loginFailed()
 			return
 		}
 	}
 
-	// Generate JWT token
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		jwtSecret = "default-secret-change-this"
+	if err := guard.Succeed(ctx, "login", req.Email, ip); err != nil {
+		log.Printf("Failed to reset login attempts: %v", err)
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": user.ID,
-		"email":   user.Email,
-		"role":    user.Role,
-		"exp":     time.Now().Add(time.Hour * 24).Unix(),
-	})
+	// Generate JWT token
+	claims, err := signingKeys.AccessClaims(strconv.Itoa(user.ID), 24*time.Hour)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to generate token"})
+		return
+	}
+	claims["user_id"] = user.ID
+	claims["email"] = user.Email
+	claims["role"] = user.Role
 
-	tokenString, err := token.SignedString([]byte(jwtSecret))
+	tokenString, err := signingKeys.Sign(claims)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to generate token"})
@@ -406,15 +614,24 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func main() {
+	// Load JWT signing keys
+	var err error
+	signingKeys, err = jwtkeys.Load()
+	if err != nil {
+		log.Fatalf("Failed to load JWT signing keys: %v", err)
+	}
+
 	// Initialize database
 	initDB()
+	initLockout()
 
 	// Setup routes
 	http.HandleFunc("/api/health", healthHandler)
-	http.HandleFunc("/api/v1/contact", contactHandler)
+	http.HandleFunc("/api/v1/contact", protectSubmission("contact", contactHandler))
 	http.HandleFunc("/api/v1/auth/login", loginHandler)
-	http.HandleFunc("/contact", contactHandler)
+	http.HandleFunc("/contact", protectSubmission("contact", contactHandler))
 	http.HandleFunc("/auth/login", loginHandler)
+	http.HandleFunc("/.well-known/jwks.json", signingKeys.ServeJWKS)
 
 	port := os.Getenv("PORT")
 	if port == "" {
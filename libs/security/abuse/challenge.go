@@ -0,0 +1,108 @@
+package abuse
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrChallengeFailed is returned when a challenge token is missing or wrong
+var ErrChallengeFailed = errors.New("challenge verification failed")
+
+// Verifier checks the token a client got from solving a captcha-style
+// challenge
+type Verifier interface {
+	Verify(ctx context.Context, token, remoteIP string) error
+}
+
+// StubVerifier accepts exactly one token. It stands in for a real provider in
+// local development and tests.
+type StubVerifier struct {
+	Token string
+}
+
+func (v StubVerifier) Verify(ctx context.Context, token, remoteIP string) error {
+	if token == "" || token != v.Token {
+		return ErrChallengeFailed
+	}
+	return nil
+}
+
+// SiteVerifier checks tokens against a siteverify endpoint, the API shared by
+// hCaptcha, Cloudflare Turnstile and reCAPTCHA
+type SiteVerifier struct {
+	URL    string
+	Secret string
+	Client *http.Client
+}
+
+func NewSiteVerifier(verifyURL, secret string) *SiteVerifier {
+	return &SiteVerifier{
+		URL:    verifyURL,
+		Secret: secret,
+		Client: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func (v *SiteVerifier) Verify(ctx context.Context, token, remoteIP string) error {
+	if token == "" {
+		return ErrChallengeFailed
+	}
+
+	form := url.Values{"secret": {v.Secret}, "response": {token}}
+	if remoteIP != "" {
+		form.Set("remoteip", remoteIP)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := v.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("challenge verification unavailable: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Success bool `json:"success"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("invalid challenge verification response: %w", err)
+	}
+	if !result.Success {
+		return ErrChallengeFailed
+	}
+	return nil
+}
+
+// VerifierFromEnv builds the verifier named by CHALLENGE_PROVIDER: "stub"
+// (accepting CHALLENGE_STUB_TOKEN) or "siteverify" (using CHALLENGE_VERIFY_URL
+// and CHALLENGE_SECRET). It returns nil when no provider is set.
+func VerifierFromEnv() (Verifier, error) {
+	switch provider := os.Getenv("CHALLENGE_PROVIDER"); provider {
+	case "", "none":
+		return nil, nil
+	case "stub":
+		token := os.Getenv("CHALLENGE_STUB_TOKEN")
+		if token == "" {
+			token = "pass"
+		}
+		return StubVerifier{Token: token}, nil
+	case "siteverify":
+		verifyURL, secret := os.Getenv("CHALLENGE_VERIFY_URL"), os.Getenv("CHALLENGE_SECRET")
+		if verifyURL == "" || secret == "" {
+			return nil, errors.New("CHALLENGE_VERIFY_URL and CHALLENGE_SECRET are required")
+		}
+		return NewSiteVerifier(verifyURL, secret), nil
+	default:
+		return nil, fmt.Errorf("unknown CHALLENGE_PROVIDER %q", provider)
+	}
+}
@@ -0,0 +1,175 @@
+// Package abuse protects unauthenticated submission endpoints from bots. An
+// attempt is checked against a honeypot field, per-IP and per-email rate
+// limits and, when configured, a captcha-style challenge. Blocked attempts are
+// handed to a callback so they can be recorded for review, sampled so a flood
+// cannot turn into a flood of records.
+package abuse
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gokulupadhyayguragain/addtocloud/libs/security/lockout"
+)
+
+// HoneypotField is a form field hidden from people. Only bots fill it in.
+const HoneypotField = "website"
+
+// Reasons an attempt is blocked
+const (
+	ReasonHoneypot       = "honeypot"
+	ReasonIPRateLimit    = "ip_rate_limit"
+	ReasonEmailRateLimit = "email_rate_limit"
+	ReasonChallenge      = "challenge_failed"
+)
+
+// Limit allows Requests attempts per Window
+type Limit struct {
+	Requests int
+	Window   time.Duration
+}
+
+// Policy sets the rate limits for an endpoint. A zero Limit disables that
+// check. Only the first block of an IP address, or of an email address for
+// the email limit, for each reason is handed to the callback per
+// RecordWindow; zero hands over every block.
+type Policy struct {
+	PerIP        Limit
+	PerEmail     Limit
+	RecordWindow time.Duration
+}
+
+// DefaultPolicy suits forms a person submits a handful of times at most
+var DefaultPolicy = Policy{
+	PerIP:        Limit{Requests: 10, Window: time.Hour},
+	PerEmail:     Limit{Requests: 3, Window: 24 * time.Hour},
+	RecordWindow: time.Hour,
+}
+
+// Attempt is one submission to a protected endpoint
+type Attempt struct {
+	Endpoint       string
+	IPAddress      string
+	Email          string
+	UserAgent      string
+	Honeypot       string // Value of HoneypotField
+	ChallengeToken string
+}
+
+// Block describes an attempt that was stopped
+type Block struct {
+	Attempt
+	Reason     string
+	RetryAfter time.Duration // Set for rate limits
+}
+
+// Guard checks attempts. Counters live in a lockout.Store so they are shared
+// between replicas when Redis is available.
+type Guard struct {
+	store    lockout.Store
+	policy   Policy
+	verifier Verifier
+	onBlock  func(Block)
+}
+
+// NewGuard returns a guard using DefaultPolicy. verifier may be nil to skip
+// the challenge.
+func NewGuard(store lockout.Store, verifier Verifier, onBlock func(Block)) *Guard {
+	return &Guard{
+		store:    store,
+		policy:   DefaultPolicy,
+		verifier: verifier,
+		onBlock:  onBlock,
+	}
+}
+
+// ChallengeRequired reports whether attempts must carry a challenge token
+func (g *Guard) ChallengeRequired() bool {
+	return g.verifier != nil
+}
+
+// Check returns the block for an attempt, or nil if it may proceed. Counter
+// failures are logged and the attempt allowed so an unavailable store cannot
+// take the endpoints down.
+func (g *Guard) Check(ctx context.Context, a Attempt) *Block {
+	a.Email = strings.ToLower(strings.TrimSpace(a.Email))
+
+	if strings.TrimSpace(a.Honeypot) != "" {
+		return g.block(ctx, a, ReasonHoneypot, 0)
+	}
+
+	if retry := g.limited(ctx, a.Endpoint, "ip", a.IPAddress, g.policy.PerIP); retry > 0 {
+		return g.block(ctx, a, ReasonIPRateLimit, retry)
+	}
+
+	if g.verifier != nil {
+		if err := g.verifier.Verify(ctx, a.ChallengeToken, a.IPAddress); err != nil {
+			return g.block(ctx, a, ReasonChallenge, 0)
+		}
+	}
+
+	// Counted after the challenge so a bot cannot use up someone else's quota
+	if a.Email != "" {
+		if retry := g.limited(ctx, a.Endpoint, "email", a.Email, g.policy.PerEmail); retry > 0 {
+			return g.block(ctx, a, ReasonEmailRateLimit, retry)
+		}
+	}
+	return nil
+}
+
+// limited counts the attempt and returns how long the subject must wait if it
+// is over the limit
+func (g *Guard) limited(ctx context.Context, endpoint, scope, subject string, limit Limit) time.Duration {
+	if limit.Requests <= 0 || subject == "" {
+		return 0
+	}
+
+	key := fmt.Sprintf("abuse:%s:%s:%s", endpoint, scope, subject)
+	count, err := g.store.Increment(ctx, key, limit.Window)
+	if err != nil {
+		log.Printf("Warning: Failed to count %s attempt: %v", endpoint, err)
+		return 0
+	}
+	if count <= int64(limit.Requests) {
+		return 0
+	}
+
+	// The counter's remaining lifetime is when the window resets
+	remaining, err := g.store.LockedFor(ctx, key)
+	if err != nil || remaining <= 0 {
+		return limit.Window
+	}
+	return remaining
+}
+
+func (g *Guard) block(ctx context.Context, a Attempt, reason string, retryAfter time.Duration) *Block {
+	b := Block{Attempt: a, Reason: reason, RetryAfter: retryAfter}
+	if g.onBlock != nil && g.shouldRecord(ctx, b) {
+		g.onBlock(b)
+	}
+	return &b
+}
+
+// shouldRecord reports whether the block is the first of its subject and
+// reason in the record window. If the store fails the block is recorded
+// anyway; the rate limits are off then, so few blocks happen.
+func (g *Guard) shouldRecord(ctx context.Context, b Block) bool {
+	if g.policy.RecordWindow <= 0 {
+		return true
+	}
+
+	subject := b.IPAddress
+	if b.Reason == ReasonEmailRateLimit {
+		subject = b.Email
+	}
+	key := fmt.Sprintf("abuse:recorded:%s:%s:%s", b.Endpoint, b.Reason, subject)
+	count, err := g.store.Increment(ctx, key, g.policy.RecordWindow)
+	if err != nil {
+		log.Printf("Warning: Failed to count blocked %s attempt: %v", b.Endpoint, err)
+		return true
+	}
+	return count == 1
+}
@@ -0,0 +1,68 @@
+package abuse
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gokulupadhyayguragain/addtocloud/libs/security/lockout"
+)
+
+func TestBlocksRecordedOncePerSubjectAndWindow(t *testing.T) {
+	ctx := context.Background()
+	var recorded []Block
+	guard := NewGuard(lockout.NewMemoryStore(), nil, func(b Block) { recorded = append(recorded, b) })
+	guard.policy = Policy{
+		PerIP:        Limit{Requests: 1, Window: time.Hour},
+		PerEmail:     Limit{Requests: 1, Window: time.Hour},
+		RecordWindow: time.Hour,
+	}
+
+	attempt := Attempt{Endpoint: "signup", IPAddress: "192.0.2.1", Email: "bot@example.com"}
+	if b := guard.Check(ctx, attempt); b != nil {
+		t.Fatalf("first attempt blocked: %s", b.Reason)
+	}
+	for i := 0; i < 20; i++ {
+		if b := guard.Check(ctx, attempt); b == nil || b.Reason != ReasonIPRateLimit {
+			t.Fatalf("attempt over the IP limit: %+v", b)
+		}
+	}
+
+	// Another address, sharing the email over its limit, and another reason
+	// for the first address each start their own sample
+	other := Attempt{Endpoint: "signup", IPAddress: "198.51.100.7", Email: "bot@example.com"}
+	guard.Check(ctx, other)
+	guard.Check(ctx, other)
+	trap := attempt
+	trap.Honeypot = "https://spam.example"
+	guard.Check(ctx, trap)
+	guard.Check(ctx, trap)
+
+	want := []struct{ reason, ip string }{
+		{ReasonIPRateLimit, "192.0.2.1"},
+		{ReasonEmailRateLimit, "198.51.100.7"},
+		{ReasonIPRateLimit, "198.51.100.7"},
+		{ReasonHoneypot, "192.0.2.1"},
+	}
+	if len(recorded) != len(want) {
+		t.Fatalf("recorded %d blocks, want %d: %+v", len(recorded), len(want), recorded)
+	}
+	for i, w := range want {
+		if recorded[i].Reason != w.reason || recorded[i].IPAddress != w.ip {
+			t.Errorf("record %d is %s from %s, want %s from %s", i, recorded[i].Reason, recorded[i].IPAddress, w.reason, w.ip)
+		}
+	}
+}
+
+func TestEveryBlockRecordedWithoutWindow(t *testing.T) {
+	var recorded int
+	guard := NewGuard(lockout.NewMemoryStore(), nil, func(Block) { recorded++ })
+	guard.policy.RecordWindow = 0
+
+	for i := 0; i < 5; i++ {
+		guard.Check(context.Background(), Attempt{Endpoint: "signup", IPAddress: "192.0.2.1", Honeypot: "x"})
+	}
+	if recorded != 5 {
+		t.Fatalf("recorded %d blocks, want 5", recorded)
+	}
+}
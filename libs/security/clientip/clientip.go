@@ -0,0 +1,95 @@
+// Package clientip finds the address of the client behind a request. Only
+// proxies listed in TRUSTED_PROXIES may vouch for a client through
+// X-Forwarded-For; everyone else is identified by their own address.
+package clientip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// TrustedProxiesFromEnv returns the addresses and CIDR ranges in the comma
+// separated TRUSTED_PROXIES variable. It returns nil, trusting no proxy, when
+// the variable is unset.
+func TrustedProxiesFromEnv() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
+
+// Resolver picks the client address out of a request
+type Resolver struct {
+	trusted []*net.IPNet
+}
+
+// NewResolver trusts the given addresses and CIDR ranges to report the client
+// they forward for
+func NewResolver(proxies []string) (*Resolver, error) {
+	r := &Resolver{}
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+		}
+		r.trusted = append(r.trusted, network)
+	}
+	return r, nil
+}
+
+// FromEnv returns a Resolver for TRUSTED_PROXIES
+func FromEnv() (*Resolver, error) {
+	return NewResolver(TrustedProxiesFromEnv())
+}
+
+// ClientIP returns the client address. X-Forwarded-For is read from the
+// right, the end our own proxies append to, and the first address that is not
+// a trusted proxy wins. Entries further left are whatever the client sent and
+// are ignored.
+func (r *Resolver) ClientIP(req *http.Request) string {
+	remote, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		remote = req.RemoteAddr
+	}
+	if !r.isTrusted(remote) {
+		return remote
+	}
+
+	hops := strings.Split(req.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		if !r.isTrusted(hop) {
+			return hop
+		}
+	}
+	return remote
+}
+
+func (r *Resolver) isTrusted(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range r.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package clientip
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	resolver, err := NewResolver([]string{"10.0.0.0/8", "192.0.2.10"})
+	if err != nil {
+		t.Fatalf("new resolver: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded string
+		want      string
+	}{
+		{"direct client", "203.0.113.5:4000", "", "203.0.113.5"},
+		{"untrusted peer cannot forward", "203.0.113.5:4000", "198.51.100.7", "203.0.113.5"},
+		{"trusted proxy", "10.1.2.3:4000", "198.51.100.7", "198.51.100.7"},
+		{"spoofed first hop", "10.1.2.3:4000", "1.2.3.4, 198.51.100.7", "198.51.100.7"},
+		{"proxy chain", "10.1.2.3:4000", "198.51.100.7, 192.0.2.10, 10.9.9.9", "198.51.100.7"},
+		{"garbage hop", "10.1.2.3:4000", "198.51.100.7, not-an-ip", "10.1.2.3"},
+		{"no header", "10.1.2.3:4000", "", "10.1.2.3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remote
+			if tt.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			if got := resolver.ClientIP(req); got != tt.want {
+				t.Fatalf("ClientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewResolverRejectsInvalidProxy(t *testing.T) {
+	if _, err := NewResolver([]string{"proxy.internal"}); err == nil {
+		t.Fatal("accepted a host name as a trusted proxy")
+	}
+}
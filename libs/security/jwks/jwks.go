@@ -0,0 +1,265 @@
+// Package jwks reads and writes JSON Web Key Sets (RFC 7517) and caches the
+// keys other issuers publish, for checking the signatures on their tokens.
+package jwks
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Minimum time between JWKS refreshes triggered by an unknown key ID
+const jwksRefreshInterval = time.Minute
+
+// JSONWebKey is a single public key from a JWKS document (RFC 7517)
+type JSONWebKey struct {
+	KeyID     string `json:"kid"`
+	KeyType   string `json:"kty"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+// JSONWebKeySet is a JWKS document
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// RemoteKeySet fetches and caches the public keys published at a JWKS URL
+type RemoteKeySet struct {
+	url    string
+	client *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]remoteKey
+	fetchedAt time.Time
+}
+
+// remoteKey is a published key and the algorithm its JWK is restricted to, if
+// any
+type remoteKey struct {
+	key       crypto.PublicKey
+	algorithm string
+}
+
+func NewRemoteKeySet(url string, client *http.Client) *RemoteKeySet {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &RemoteKeySet{url: url, client: client}
+}
+
+// PublicKey returns the key for kid that verifies signatures made with
+// algorithm, refreshing the cached JWKS when the key is unknown so issuer key
+// rotation is picked up automatically. The key must be of the type the
+// algorithm uses, and of the JWK's own alg when it names one.
+func (r *RemoteKeySet) PublicKey(ctx context.Context, kid, algorithm string) (crypto.PublicKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key, ok := r.lookup(kid)
+	if !ok {
+		if err := r.refresh(ctx); err != nil {
+			return nil, err
+		}
+		if key, ok = r.lookup(kid); !ok {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+	}
+
+	if key.algorithm != "" && key.algorithm != algorithm {
+		return nil, fmt.Errorf("signing key %q is for %s, not %s", kid, key.algorithm, algorithm)
+	}
+	if err := CheckAlgorithm(algorithm, key.key); err != nil {
+		return nil, err
+	}
+	return key.key, nil
+}
+
+func (r *RemoteKeySet) refresh(ctx context.Context) error {
+
+	if !r.fetchedAt.IsZero() && time.Since(r.fetchedAt) < jwksRefreshInterval {
+		return nil
+	}
+
+	var document JSONWebKeySet
+	if err := getJSON(ctx, r.client, r.url, &document); err != nil {
+		return fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+
+	keys := make(map[string]remoteKey)
+	for _, jwk := range document.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.PublicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.KeyID] = remoteKey{key: key, algorithm: jwk.Algorithm}
+	}
+	r.keys = keys
+	r.fetchedAt = time.Now()
+	return nil
+}
+
+func (r *RemoteKeySet) lookup(kid string) (remoteKey, bool) {
+	if key, ok := r.keys[kid]; ok {
+		return key, true
+	}
+	// Tokens without a kid are only acceptable when there is a single key
+	if kid == "" && len(r.keys) == 1 {
+		for _, key := range r.keys {
+			return key, true
+		}
+	}
+	return remoteKey{}, false
+}
+
+// CheckAlgorithm reports an error unless key is of the type that verifies
+// signatures made with the JWS algorithm, so a token cannot pick an algorithm
+// the key was never meant for
+func CheckAlgorithm(algorithm string, key crypto.PublicKey) error {
+	var ok bool
+	switch {
+	case strings.HasPrefix(algorithm, "RS"), strings.HasPrefix(algorithm, "PS"):
+		_, ok = key.(*rsa.PublicKey)
+	case algorithm == "ES256", algorithm == "ES384", algorithm == "ES512":
+		curves := map[string]string{"ES256": "P-256", "ES384": "P-384", "ES512": "P-521"}
+		k, isEC := key.(*ecdsa.PublicKey)
+		ok = isEC && k.Curve.Params().Name == curves[algorithm]
+	case algorithm == "EdDSA":
+		_, ok = key.(ed25519.PublicKey)
+	}
+	if !ok {
+		return fmt.Errorf("%s signatures cannot be verified with a %T key", algorithm, key)
+	}
+	return nil
+}
+
+// NewJSONWebKey describes a public key for publication in a JWKS document
+func NewJSONWebKey(kid, algorithm string, key crypto.PublicKey) (JSONWebKey, error) {
+	jwk := JSONWebKey{KeyID: kid, Use: "sig", Algorithm: algorithm}
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(k.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(k)
+	case *ecdsa.PublicKey:
+		jwk.KeyType = "EC"
+		jwk.Curve = k.Curve.Params().Name
+		size := (k.Curve.Params().BitSize + 7) / 8
+		jwk.X = base64.RawURLEncoding.EncodeToString(k.X.FillBytes(make([]byte, size)))
+		jwk.Y = base64.RawURLEncoding.EncodeToString(k.Y.FillBytes(make([]byte, size)))
+	default:
+		return jwk, fmt.Errorf("unsupported public key type %T", key)
+	}
+
+	return jwk, nil
+}
+
+// PublicKey converts the JWK into an RSA, ECDSA or Ed25519 public key
+func (k JSONWebKey) PublicKey() (crypto.PublicKey, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() {
+			return nil, errors.New("RSA exponent too large")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Curve)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("EC point is not on curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	case "OKP":
+		if k.Curve != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Curve)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 public key")
+		}
+		return ed25519.PublicKey(x), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.KeyType)
+	}
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64url value: %w", err)
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func getJSON(ctx context.Context, client *http.Client, target string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %d", target, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out)
+}
@@ -0,0 +1,385 @@
+// Package jwtkeys manages the asymmetric keys services sign their tokens with
+// and decides which keys may verify a token. Signing keys are identified by
+// the kid header and published as a JWKS document. Tokens from other services
+// are accepted only from issuers that are configured with the JWKS URL and
+// algorithms they sign with.
+//
+// The package does not depend on a JWT library, so modules pinned to
+// different versions share it through a thin adapter.
+package jwtkeys
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gokulupadhyayguragain/addtocloud/libs/security/jwks"
+)
+
+const minRSAKeyBits = 2048
+
+// DefaultIssuer is the iss claim used when JWT_ISSUER is not set
+const DefaultIssuer = "addtocloud"
+
+// Algorithms the keys in a set sign with
+const (
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// DefaultTrustedAlgorithms are accepted from a trusted issuer that does not
+// list its own
+var DefaultTrustedAlgorithms = []string{AlgorithmRS256, AlgorithmEdDSA}
+
+// ErrUnknownKey is returned when no key may verify a token
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// signingKey is a private key identified by the kid header of the tokens it signs
+type signingKey struct {
+	id        string
+	algorithm string
+	key       crypto.Signer
+}
+
+// TrustedIssuer is another service whose tokens are accepted. Only tokens
+// whose iss claim is Issuer are checked against the keys at JWKSURL, and only
+// when signed with one of Algorithms.
+type TrustedIssuer struct {
+	Issuer     string
+	JWKSURL    string
+	Algorithms []string
+
+	keys *jwks.RemoteKeySet
+}
+
+func (t *TrustedIssuer) allows(algorithm string) bool {
+	for _, a := range t.Algorithms {
+		if a == algorithm {
+			return true
+		}
+	}
+	return false
+}
+
+// KeySet holds a service's token signing keys. Tokens are signed with the
+// active key; every key in the set is published in the JWKS and accepted for
+// verification so tokens signed before a rotation stay valid until they
+// expire.
+type KeySet struct {
+	issuer    string
+	audience  string
+	audiences []string
+	keys      map[string]*signingKey
+	active    *signingKey
+	trusted   map[string]*TrustedIssuer
+}
+
+// Load reads signing keys and trusted issuers from the environment.
+//
+// JWT_KEYS_DIR is a directory of PEM encoded RSA (2048 bit or larger) or
+// Ed25519 private keys; each file's name without extension is its key ID.
+// JWT_ACTIVE_KEY_ID selects the signing key and defaults to the last key ID in
+// lexical order, so date-prefixed file names rotate by adding a file.
+// JWT_ISSUER is the iss claim this service puts in its tokens; services that
+// share JWT_KEYS_DIR must share it too.
+//
+// JWT_AUDIENCE is the aud value an access token must carry for this service
+// to accept it and defaults to JWT_ISSUER. Access tokens this service issues
+// name its own audience and those in JWT_TOKEN_AUDIENCES, a space separated
+// list of the other services they are meant for.
+//
+// JWT_TRUSTED_ISSUERS is an optional comma separated list of names of other
+// services whose tokens are accepted. Each is configured with
+// JWT_TRUSTED_<NAME>_ISSUER, JWT_TRUSTED_<NAME>_JWKS_URL and optionally
+// JWT_TRUSTED_<NAME>_ALGORITHMS, a space separated list defaulting to
+// RS256 and EdDSA.
+//
+// Without JWT_KEYS_DIR an ephemeral Ed25519 key is generated. Tokens then do
+// not survive a restart and are not shared between replicas.
+func Load() (*KeySet, error) {
+	set := &KeySet{
+		issuer:  os.Getenv("JWT_ISSUER"),
+		keys:    make(map[string]*signingKey),
+		trusted: make(map[string]*TrustedIssuer),
+	}
+	if set.issuer == "" {
+		set.issuer = DefaultIssuer
+	}
+	set.audience = os.Getenv("JWT_AUDIENCE")
+	if set.audience == "" {
+		set.audience = set.issuer
+	}
+	set.audiences = []string{set.audience}
+	for _, audience := range strings.Fields(os.Getenv("JWT_TOKEN_AUDIENCES")) {
+		if audience != set.audience {
+			set.audiences = append(set.audiences, audience)
+		}
+	}
+
+	dir := os.Getenv("JWT_KEYS_DIR")
+	if dir == "" {
+		log.Println("Warning: JWT_KEYS_DIR not set - signing tokens with an ephemeral key")
+		if err := set.addEphemeralKey(); err != nil {
+			return nil, err
+		}
+	} else if err := set.loadDir(dir); err != nil {
+		return nil, err
+	}
+
+	activeID := os.Getenv("JWT_ACTIVE_KEY_ID")
+	if activeID == "" {
+		ids := set.ids()
+		activeID = ids[len(ids)-1]
+	}
+	active, ok := set.keys[activeID]
+	if !ok {
+		return nil, fmt.Errorf("active signing key %q not found", activeID)
+	}
+	set.active = active
+
+	// A bare URL list trusted any key it served for any issuer
+	if os.Getenv("JWT_TRUSTED_JWKS_URLS") != "" {
+		return nil, errors.New("JWT_TRUSTED_JWKS_URLS is no longer supported, configure JWT_TRUSTED_ISSUERS instead")
+	}
+
+	for _, name := range strings.Split(os.Getenv("JWT_TRUSTED_ISSUERS"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		prefix := "JWT_TRUSTED_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		trusted := &TrustedIssuer{
+			Issuer:     os.Getenv(prefix + "ISSUER"),
+			JWKSURL:    os.Getenv(prefix + "JWKS_URL"),
+			Algorithms: strings.Fields(os.Getenv(prefix + "ALGORITHMS")),
+		}
+		if trusted.Issuer == "" || trusted.JWKSURL == "" {
+			return nil, fmt.Errorf("trusted issuer %q needs %sISSUER and %sJWKS_URL", name, prefix, prefix)
+		}
+		if err := set.Trust(trusted); err != nil {
+			return nil, fmt.Errorf("trusted issuer %q: %w", name, err)
+		}
+	}
+
+	return set, nil
+}
+
+// Trust accepts tokens from another issuer
+func (s *KeySet) Trust(trusted *TrustedIssuer) error {
+	if trusted.Issuer == s.issuer {
+		return fmt.Errorf("issuer %q is this service's own", trusted.Issuer)
+	}
+	if len(trusted.Algorithms) == 0 {
+		trusted.Algorithms = DefaultTrustedAlgorithms
+	}
+	for _, algorithm := range trusted.Algorithms {
+		if !supportedAlgorithm(algorithm) {
+			return fmt.Errorf("unsupported algorithm %q", algorithm)
+		}
+	}
+	trusted.keys = jwks.NewRemoteKeySet(trusted.JWKSURL, nil)
+	s.trusted[trusted.Issuer] = trusted
+	return nil
+}
+
+// Issuer returns the iss claim for tokens this service signs
+func (s *KeySet) Issuer() string {
+	return s.issuer
+}
+
+// Audience returns the aud value access tokens must carry to be accepted by
+// this service
+func (s *KeySet) Audience() string {
+	return s.audience
+}
+
+// TokenAudiences returns the aud values for access tokens this service issues
+func (s *KeySet) TokenAudiences() []string {
+	return s.audiences
+}
+
+// Trusts reports whether tokens from issuer are accepted
+func (s *KeySet) Trusts(issuer string) bool {
+	_, ok := s.trusted[issuer]
+	return ok
+}
+
+// SigningKey returns the active key, its ID and the algorithm it signs with
+func (s *KeySet) SigningKey() (kid, algorithm string, key crypto.Signer) {
+	return s.active.id, s.active.algorithm, s.active.key
+}
+
+// VerificationKey returns the key that checks the signature of a token with
+// the given kid and alg headers and iss claim. The service's own keys verify
+// only their own algorithm. Any other key must come from the JWKS of the
+// trusted issuer named by iss, be allowed that issuer's algorithms and be of
+// the type the algorithm uses.
+func (s *KeySet) VerificationKey(ctx context.Context, kid, algorithm, issuer string) (crypto.PublicKey, error) {
+	if key, ok := s.keys[kid]; ok && (issuer == "" || issuer == s.issuer) {
+		if algorithm != key.algorithm {
+			return nil, fmt.Errorf("signing key %q is for %s, not %s", kid, key.algorithm, algorithm)
+		}
+		return key.key.Public(), nil
+	}
+
+	trusted, ok := s.trusted[issuer]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	if !trusted.allows(algorithm) {
+		return nil, fmt.Errorf("issuer %q does not sign with %s", issuer, algorithm)
+	}
+	return trusted.keys.PublicKey(ctx, kid, algorithm)
+}
+
+// Algorithms lists every algorithm VerificationKey may accept, for the JWT
+// parser's allow-list
+func (s *KeySet) Algorithms() []string {
+	seen := map[string]bool{AlgorithmRS256: true, AlgorithmEdDSA: true}
+	algorithms := []string{AlgorithmRS256, AlgorithmEdDSA}
+	for _, trusted := range s.trusted {
+		for _, algorithm := range trusted.Algorithms {
+			if !seen[algorithm] {
+				seen[algorithm] = true
+				algorithms = append(algorithms, algorithm)
+			}
+		}
+	}
+	sort.Strings(algorithms[2:])
+	return algorithms
+}
+
+// JWKS returns the public half of every signing key
+func (s *KeySet) JWKS() jwks.JSONWebKeySet {
+	ids := s.ids()
+	set := jwks.JSONWebKeySet{Keys: make([]jwks.JSONWebKey, 0, len(ids))}
+	for _, id := range ids {
+		key := s.keys[id]
+		jwk, err := jwks.NewJSONWebKey(key.id, key.algorithm, key.key.Public())
+		if err != nil {
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+// ServeJWKS writes the JWKS document
+func (s *KeySet) ServeJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(s.JWKS())
+}
+
+func (s *KeySet) ids() []string {
+	ids := make([]string, 0, len(s.keys))
+	for id := range s.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (s *KeySet) loadDir(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no *.pem signing keys found in %s", dir)
+	}
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read signing key: %w", err)
+		}
+		key, err := parsePrivateKey(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		id := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+		if err := s.add(id, key); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *KeySet) addEphemeralKey() error {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate signing key: %w", err)
+	}
+	id := make([]byte, 4)
+	if _, err := rand.Read(id); err != nil {
+		return fmt.Errorf("failed to generate key id: %w", err)
+	}
+	return s.add("ephemeral-"+hex.EncodeToString(id), key)
+}
+
+func (s *KeySet) add(id string, key crypto.Signer) error {
+	var algorithm string
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < minRSAKeyBits {
+			return fmt.Errorf("RSA signing keys must be at least %d bits", minRSAKeyBits)
+		}
+		algorithm = AlgorithmRS256
+	case ed25519.PrivateKey:
+		algorithm = AlgorithmEdDSA
+	default:
+		return fmt.Errorf("unsupported signing key type %T", key)
+	}
+
+	s.keys[id] = &signingKey{id: id, algorithm: algorithm, key: key}
+	return nil
+}
+
+func supportedAlgorithm(algorithm string) bool {
+	switch algorithm {
+	case "RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA":
+		return true
+	}
+	return false
+}
+
+func parsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", key)
+		}
+		return signer, nil
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+}
@@ -0,0 +1,128 @@
+package jwtkeys
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gokulupadhyayguragain/addtocloud/libs/security/jwks"
+)
+
+// newTrustingKeySet returns a key set with an ephemeral key that trusts the
+// issuer "https://other.example" to sign with RS256, publishing an RSA key
+// "rsa-1" and a P-256 key "ec-1"
+func newTrustingKeySet(t *testing.T) *KeySet {
+	t.Helper()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaJWK, _ := jwks.NewJSONWebKey("rsa-1", "", &rsaKey.PublicKey)
+	ecJWK, _ := jwks.NewJSONWebKey("ec-1", "ES256", &ecKey.PublicKey)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jwks.JSONWebKeySet{Keys: []jwks.JSONWebKey{rsaJWK, ecJWK}})
+	}))
+	t.Cleanup(server.Close)
+
+	t.Setenv("JWT_KEYS_DIR", "")
+	t.Setenv("JWT_ISSUER", "https://self.example")
+	t.Setenv("JWT_TRUSTED_ISSUERS", "other")
+	t.Setenv("JWT_TRUSTED_OTHER_ISSUER", "https://other.example")
+	t.Setenv("JWT_TRUSTED_OTHER_JWKS_URL", server.URL)
+	t.Setenv("JWT_TRUSTED_OTHER_ALGORITHMS", "RS256 PS256")
+	set, err := Load()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	return set
+}
+
+func TestVerificationKeyOwnKeys(t *testing.T) {
+	set := newTrustingKeySet(t)
+	ctx := context.Background()
+	kid, algorithm, signer := set.SigningKey()
+
+	key, err := set.VerificationKey(ctx, kid, algorithm, set.Issuer())
+	if err != nil {
+		t.Fatalf("own key: %v", err)
+	}
+	if !key.(ed25519.PublicKey).Equal(signer.Public()) {
+		t.Fatal("own key: got a different key")
+	}
+	if _, err := set.VerificationKey(ctx, kid, "RS256", set.Issuer()); err == nil {
+		t.Fatal("own EdDSA key accepted for RS256")
+	}
+	if _, err := set.VerificationKey(ctx, kid, algorithm, "https://elsewhere.example"); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("own key for an unknown issuer: err %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestVerificationKeyTrustedIssuer(t *testing.T) {
+	set := newTrustingKeySet(t)
+	ctx := context.Background()
+
+	if _, err := set.VerificationKey(ctx, "rsa-1", "RS256", "https://other.example"); err != nil {
+		t.Fatalf("trusted RS256 key: %v", err)
+	}
+
+	tests := []struct {
+		name, kid, algorithm, issuer string
+	}{
+		{"untrusted issuer", "rsa-1", "RS256", "https://evil.example"},
+		{"no issuer", "rsa-1", "RS256", ""},
+		{"algorithm not allowed for issuer", "ec-1", "ES256", "https://other.example"},
+		{"key type does not fit algorithm", "ec-1", "PS256", "https://other.example"},
+		{"unknown key", "rsa-2", "RS256", "https://other.example"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := set.VerificationKey(ctx, tt.kid, tt.algorithm, tt.issuer); err == nil {
+				t.Fatal("key accepted")
+			}
+		})
+	}
+}
+
+func TestLoadRejectsUnboundJWKSURLs(t *testing.T) {
+	t.Setenv("JWT_KEYS_DIR", "")
+	t.Setenv("JWT_TRUSTED_JWKS_URLS", "https://other.example/.well-known/jwks.json")
+	if _, err := Load(); err == nil {
+		t.Fatal("loaded a JWKS URL without an issuer")
+	}
+}
+
+func TestLoadAudiences(t *testing.T) {
+	t.Setenv("JWT_KEYS_DIR", "")
+	t.Setenv("JWT_ISSUER", "https://self.example")
+	t.Setenv("JWT_AUDIENCE", "")
+	t.Setenv("JWT_TOKEN_AUDIENCES", "")
+	set, err := Load()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if set.Audience() != "https://self.example" {
+		t.Fatalf("default audience %q, want the issuer", set.Audience())
+	}
+
+	t.Setenv("JWT_AUDIENCE", "api")
+	t.Setenv("JWT_TOKEN_AUDIENCES", "api platform")
+	if set, err = Load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if got := set.TokenAudiences(); len(got) != 2 || got[0] != "api" || got[1] != "platform" {
+		t.Fatalf("token audiences %v, want [api platform]", got)
+	}
+}
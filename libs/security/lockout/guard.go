@@ -0,0 +1,214 @@
+// Package lockout throttles repeated authentication attempts. Attempts are
+// counted per account and per client IP before the credential is checked;
+// once a counter passes its policy's threshold the key is locked for an
+// exponentially growing period.
+package lockout
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Scopes a counter can apply to
+const (
+	ScopeAccount = "account"
+	ScopeIP      = "ip"
+)
+
+// Policy controls when a key is locked and for how long
+type Policy struct {
+	Threshold int           // Attempts allowed before the first lockout
+	BaseDelay time.Duration // Length of the first lockout, doubled for every further attempt
+	MaxDelay  time.Duration // Upper bound for a single lockout
+	Window    time.Duration // Attempts older than this are forgotten
+}
+
+var (
+	// DefaultAccountPolicy protects a single account against guessing
+	DefaultAccountPolicy = Policy{Threshold: 5, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: 24 * time.Hour}
+
+	// DefaultIPPolicy catches one client spraying guesses across many accounts
+	DefaultIPPolicy = Policy{Threshold: 20, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour}
+)
+
+// delay returns how long to lock a key after the given number of attempts
+func (p Policy) delay(attempts int64) time.Duration {
+	over := attempts - int64(p.Threshold)
+	if over <= 0 {
+		return 0
+	}
+
+	delay := p.BaseDelay
+	for i := int64(1); i < over && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+// Event describes a key that has just been locked
+type Event struct {
+	Realm       string
+	Scope       string
+	Subject     string // The account identifier or IP address that was locked
+	IPAddress   string // Client that triggered the lockout
+	Failures    int64  // Attempts that were let through before the lock
+	LockedUntil time.Time
+}
+
+// Guard tracks attempts for one or more authentication realms such as
+// "login" or "mfa". Each realm has independent counters.
+type Guard struct {
+	store     Store
+	account   Policy
+	ip        Policy
+	onLockout func(Event)
+}
+
+func NewGuard(store Store, onLockout func(Event)) *Guard {
+	return &Guard{
+		store:     store,
+		account:   DefaultAccountPolicy,
+		ip:        DefaultIPPolicy,
+		onLockout: onLockout,
+	}
+}
+
+// Attempt counts an attempt against the account and client IP before the
+// credential is verified and returns how long the caller must wait, or zero
+// if the attempt may proceed. Counting first means parallel requests cannot
+// all slip through while a slow check is running.
+//
+// Once a counter passes its threshold the key is locked. When the lock ends
+// one more attempt is let through, and the attempt locks the key again for
+// twice as long unless it succeeds.
+func (g *Guard) Attempt(ctx context.Context, realm, account, ip string) (time.Duration, error) {
+	var longest time.Duration
+	for _, key := range g.lockKeys(realm, account, ip) {
+		remaining, err := g.store.LockedFor(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+		if remaining > longest {
+			longest = remaining
+		}
+	}
+	if longest > 0 {
+		return longest, nil
+	}
+
+	if account != "" {
+		wait, err := g.attempt(ctx, realm, ScopeAccount, normalizeAccount(account), ip, g.account)
+		if err != nil {
+			return 0, err
+		}
+		longest = wait
+	}
+
+	if ip != "" {
+		wait, err := g.attempt(ctx, realm, ScopeIP, ip, ip, g.ip)
+		if err != nil {
+			return 0, err
+		}
+		if wait > longest {
+			longest = wait
+		}
+	}
+
+	return longest, nil
+}
+
+// Succeed clears the account's count after a successful attempt and takes
+// the attempt back off the IP counter. The rest of the IP count is left alone
+// so a valid login cannot launder a guessing client.
+func (g *Guard) Succeed(ctx context.Context, realm, account, ip string) error {
+	if account != "" {
+		subject := normalizeAccount(account)
+		if err := g.store.Reset(ctx, failKey(realm, ScopeAccount, subject), lockKey(realm, ScopeAccount, subject)); err != nil {
+			return err
+		}
+	}
+	if ip != "" {
+		return g.store.Decrement(ctx, failKey(realm, ScopeIP, ip))
+	}
+	return nil
+}
+
+// Unlock lifts a lockout and clears its failure count
+func (g *Guard) Unlock(ctx context.Context, realm, scope, subject string) error {
+	if scope == ScopeAccount {
+		subject = normalizeAccount(subject)
+	}
+	return g.store.Reset(ctx, failKey(realm, scope, subject), lockKey(realm, scope, subject))
+}
+
+func (g *Guard) attempt(ctx context.Context, realm, scope, subject, ip string, policy Policy) (time.Duration, error) {
+	attempts, err := g.store.Increment(ctx, failKey(realm, scope, subject), policy.Window)
+	if err != nil {
+		return 0, err
+	}
+
+	delay := policy.delay(attempts)
+	if delay == 0 {
+		return 0, nil
+	}
+
+	// Only the request that takes the lock gets to act on it, so a burst of
+	// parallel attempts past the threshold cannot all proceed
+	locked, err := g.store.TryLock(ctx, lockKey(realm, scope, subject), delay)
+	if err != nil {
+		return 0, err
+	}
+	if !locked {
+		remaining, err := g.store.LockedFor(ctx, lockKey(realm, scope, subject))
+		if err != nil {
+			return 0, err
+		}
+		return remaining, nil
+	}
+
+	if g.onLockout != nil {
+		g.onLockout(Event{
+			Realm:       realm,
+			Scope:       scope,
+			Subject:     subject,
+			IPAddress:   ip,
+			Failures:    attempts - 1,
+			LockedUntil: time.Now().Add(delay),
+		})
+	}
+
+	// The first attempt past the threshold is refused. Later ones have sat
+	// out a lockout and may try once more.
+	if attempts == int64(policy.Threshold)+1 {
+		return delay, nil
+	}
+	return 0, nil
+}
+
+func (g *Guard) lockKeys(realm, account, ip string) []string {
+	keys := make([]string, 0, 2)
+	if account != "" {
+		keys = append(keys, lockKey(realm, ScopeAccount, normalizeAccount(account)))
+	}
+	if ip != "" {
+		keys = append(keys, lockKey(realm, ScopeIP, ip))
+	}
+	return keys
+}
+
+func normalizeAccount(account string) string {
+	return strings.ToLower(strings.TrimSpace(account))
+}
+
+func failKey(realm, scope, subject string) string {
+	return fmt.Sprintf("lockout:%s:%s:%s:failures", realm, scope, subject)
+}
+
+func lockKey(realm, scope, subject string) string {
+	return fmt.Sprintf("lockout:%s:%s:%s:locked", realm, scope, subject)
+}
@@ -0,0 +1,90 @@
+package lockout
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestGuard(policy Policy) (*Guard, *[]Event) {
+	var events []Event
+	guard := NewGuard(NewMemoryStore(), func(event Event) { events = append(events, event) })
+	guard.account = policy
+	guard.ip = Policy{Threshold: 1000, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour}
+	return guard, &events
+}
+
+func TestAttemptLocksPastThreshold(t *testing.T) {
+	ctx := context.Background()
+	guard, events := newTestGuard(Policy{Threshold: 3, BaseDelay: 50 * time.Millisecond, MaxDelay: time.Second, Window: time.Hour})
+
+	for i := 1; i <= 3; i++ {
+		if wait, err := guard.Attempt(ctx, "login", "User@Example.com", "192.0.2.1"); err != nil || wait != 0 {
+			t.Fatalf("attempt %d: wait %v, err %v", i, wait, err)
+		}
+	}
+	wait, err := guard.Attempt(ctx, "login", "user@example.com", "192.0.2.1")
+	if err != nil || wait != 50*time.Millisecond {
+		t.Fatalf("attempt past threshold: wait %v, err %v, want 50ms", wait, err)
+	}
+	if len(*events) != 1 || (*events)[0].Subject != "user@example.com" || (*events)[0].Failures != 3 {
+		t.Fatalf("events %+v, want one lockout of user@example.com after 3 attempts", *events)
+	}
+
+	// Attempts while locked are refused without counting
+	if wait, _ := guard.Attempt(ctx, "login", "user@example.com", "192.0.2.2"); wait <= 0 {
+		t.Fatal("attempt while locked was let through")
+	}
+
+	// Once the lock ends one attempt goes through and locks for longer
+	time.Sleep(60 * time.Millisecond)
+	if wait, err := guard.Attempt(ctx, "login", "user@example.com", "192.0.2.1"); err != nil || wait != 0 {
+		t.Fatalf("attempt after lockout: wait %v, err %v", wait, err)
+	}
+	if wait, _ := guard.Attempt(ctx, "login", "user@example.com", "192.0.2.1"); wait <= 50*time.Millisecond {
+		t.Fatalf("second attempt after lockout: wait %v, want a doubled lock", wait)
+	}
+}
+
+func TestAttemptParallel(t *testing.T) {
+	ctx := context.Background()
+	guard, _ := newTestGuard(Policy{Threshold: 5, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour})
+
+	var allowed int64
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if wait, err := guard.Attempt(ctx, "login", "user@example.com", "192.0.2.1"); err == nil && wait == 0 {
+				atomic.AddInt64(&allowed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if allowed != 5 {
+		t.Fatalf("%d parallel attempts let through, want 5", allowed)
+	}
+}
+
+func TestSucceedResetsAccount(t *testing.T) {
+	ctx := context.Background()
+	guard, _ := newTestGuard(Policy{Threshold: 2, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour})
+
+	for i := 0; i < 10; i++ {
+		if wait, err := guard.Attempt(ctx, "login", "user@example.com", "192.0.2.1"); err != nil || wait != 0 {
+			t.Fatalf("attempt %d: wait %v, err %v", i, wait, err)
+		}
+		if err := guard.Succeed(ctx, "login", "user@example.com", "192.0.2.1"); err != nil {
+			t.Fatalf("succeed: %v", err)
+		}
+	}
+
+	count, _ := guard.store.Increment(ctx, failKey("login", ScopeIP, "192.0.2.1"), time.Hour)
+	if count != 1 {
+		t.Fatalf("IP counter at %d after successful attempts, want 0", count-1)
+	}
+}
@@ -0,0 +1,179 @@
+package lockout
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Store persists attempt counters and lock markers. Keys expire on their own.
+type Store interface {
+	// Increment adds one to the counter, starting its expiry window when the
+	// counter is created, and returns the new value
+	Increment(ctx context.Context, key string, window time.Duration) (int64, error)
+	// Decrement takes one off an existing counter, never going below zero
+	Decrement(ctx context.Context, key string) error
+	// TryLock marks the key as locked for d unless it is already locked, and
+	// reports whether it did
+	TryLock(ctx context.Context, key string, d time.Duration) (bool, error)
+	// LockedFor returns the remaining lock time, or zero if the key is unlocked
+	LockedFor(ctx context.Context, key string) (time.Duration, error)
+	// Reset deletes the keys
+	Reset(ctx context.Context, keys ...string) error
+}
+
+// incrementScript sets the expiry in the same round trip as the first
+// increment so a counter can never be left without one
+var incrementScript = redis.NewScript(`
+local n = redis.call("INCR", KEYS[1])
+if n == 1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return n
+`)
+
+// decrementScript leaves missing keys alone so the counter keeps its expiry
+var decrementScript = redis.NewScript(`
+local n = tonumber(redis.call("GET", KEYS[1]))
+if n and n > 0 then
+	return redis.call("DECR", KEYS[1])
+end
+return 0
+`)
+
+// RedisStore keeps counters in Redis so every replica sees the same state
+type RedisStore struct {
+	client *redis.Client
+}
+
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+func (s *RedisStore) Increment(ctx context.Context, key string, window time.Duration) (int64, error) {
+	return incrementScript.Run(ctx, s.client, []string{key}, window.Milliseconds()).Int64()
+}
+
+func (s *RedisStore) Decrement(ctx context.Context, key string) error {
+	return decrementScript.Run(ctx, s.client, []string{key}).Err()
+}
+
+func (s *RedisStore) TryLock(ctx context.Context, key string, d time.Duration) (bool, error) {
+	return s.client.SetNX(ctx, key, 1, d).Result()
+}
+
+func (s *RedisStore) LockedFor(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := s.client.PTTL(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+	// PTTL reports missing keys and keys without expiry as negative values
+	if ttl < 0 {
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+func (s *RedisStore) Reset(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	return s.client.Del(ctx, keys...).Err()
+}
+
+type memoryEntry struct {
+	count     int64
+	expiresAt time.Time
+}
+
+// MemoryStore is a process-local Store used when Redis is unavailable.
+// Counters are not shared between replicas and are lost on restart.
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]*memoryEntry
+	sweepAt time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[string]*memoryEntry)}
+}
+
+func (s *MemoryStore) Increment(ctx context.Context, key string, window time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+
+	entry, ok := s.entries[key]
+	if !ok || now.After(entry.expiresAt) {
+		entry = &memoryEntry{expiresAt: now.Add(window)}
+		s.entries[key] = entry
+	}
+	entry.count++
+	return entry.count, nil
+}
+
+func (s *MemoryStore) Decrement(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry, ok := s.entries[key]; ok && entry.count > 0 {
+		entry.count--
+	}
+	return nil
+}
+
+func (s *MemoryStore) TryLock(ctx context.Context, key string, d time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if entry, ok := s.entries[key]; ok && now.Before(entry.expiresAt) {
+		return false, nil
+	}
+	s.entries[key] = &memoryEntry{count: 1, expiresAt: now.Add(d)}
+	return true, nil
+}
+
+func (s *MemoryStore) LockedFor(ctx context.Context, key string) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok {
+		return 0, nil
+	}
+	remaining := time.Until(entry.expiresAt)
+	if remaining <= 0 {
+		delete(s.entries, key)
+		return 0, nil
+	}
+	return remaining, nil
+}
+
+func (s *MemoryStore) Reset(ctx context.Context, keys ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range keys {
+		delete(s.entries, key)
+	}
+	return nil
+}
+
+// sweep drops expired entries at most once a minute so the map cannot grow
+// without bound under a spraying attack
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Before(s.sweepAt) {
+		return
+	}
+	for key, entry := range s.entries {
+		if now.After(entry.expiresAt) {
+			delete(s.entries, key)
+		}
+	}
+	s.sweepAt = now.Add(time.Minute)
+}